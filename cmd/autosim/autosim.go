@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"flag"
 	"fmt"
 	"log"
 	"os"
@@ -50,10 +51,16 @@ func main () () {
 		nReader *bufio.Reader 
 		err error
 		line string
+		plan *slt.Plan
+		runErr error
 	)
 	
 	defer debug.TimeMe(time.Now())
 	
+	slt.AddPlanFlags(flag.CommandLine)
+	flag.IntVar(&slt.Jobs, "jobs", 1, "Number of runs of a folder to work at the same time")
+	flag.Parse()
+	
 	baseFolder, err = os.Getwd()
 	
 	////////////////////////
@@ -63,7 +70,10 @@ func main () () {
 		////////////////////////
 		// Wait the queue to finish
 		////////////////////////
-		Wait(user, waitingTime)
+		// Nothing is submitted in a dry run, no need to wait
+		if !slt.DryRun {
+			Wait(user, waitingTime)
+		}
 		
 		// Read again folders file, maybe something changed
 		if inFile, err = os.Open("folders.txt"); err != nil {
@@ -93,28 +103,25 @@ func main () () {
 			}
 			
 			////////////////////////
-			// Clean folder
-			////////////////////////
-			log.Println("Clean")
-			slt.SimClean()
-			
-			////////////////////////
-			// Continue good runs
-			////////////////////////
-			log.Println("Check and continue good runs")
-			slt.CAC()
-			
-			////////////////////////
-			// Launch new runs
+			// Clean folder, continue good runs and 
+			// launch new runs, as a single plan
 			////////////////////////
-			log.Println("Submit runs")
-			if err = slt.PbsLaunch(); err != nil {
-				if err.Error() == "qsub: Job exceeds queue resource limits" {
+			log.Println("Clean, check and continue good runs and submit them")
+			plan = slt.NewPlan("autosim")
+			runErr = slt.PlanRelaunch(plan)
+			if err = plan.Run(); err != nil {
+				if strings.Contains(err.Error(), "qsub: Job exceeds queue resource limits") {
+					log.Println(err)
 					Wait(user, waitingTime)
 				} else {
 					log.Fatal(err)
 				}
 			}
+			// The runs that failed, i.e. with invalid ICs, are left
+			// out, the other runs and folders go on
+			if runErr != nil {
+				log.Println("Some runs in ", folder, " were not continued: ", runErr)
+			}
 			////////////////////////
 			// Back to the base folder
 			////////////////////////
@@ -122,6 +129,11 @@ func main () () {
 				log.Fatalf("Can't enter in %v, error: %v\n", folder, err)
 			}
 		}
+		
+		// One pass is enough to see the plans
+		if slt.DryRun {
+			break
+		}
 	}	
 	
 	fmt.Print("\x07") // Beep when finish!!:D
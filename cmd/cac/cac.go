@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"time"
 	
@@ -10,6 +11,10 @@ import (
 
 func main () () {
 	defer debug.TimeMe(time.Now())
+
+	slt.AddPlanFlags(flag.CommandLine)
+	flag.IntVar(&slt.Jobs, "jobs", 1, "Number of runs to work at the same time")
+	flag.Parse()
 	
 	slt.CAC()
 	
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"time"
 	
@@ -11,7 +12,13 @@ import (
 func main () () {
 	defer debug.TimeMe(time.Now())
 	
+	flag.StringVar(&slt.StatusFormat, "format", "table", "Format of the report: table, json or csv")
+	flag.IntVar(&slt.Jobs, "jobs", 1, "Number of runs to work at the same time")
+	flag.Parse()
+	
 	slt.CheckStatus()
 		
-	fmt.Print("\x07") // Beep when finish!!:D
+	if slt.StatusFormat == "table" {
+		fmt.Print("\x07") // Beep when finish!!:D
+	}
 }
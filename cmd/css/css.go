@@ -35,7 +35,7 @@ func main() {
 	}
 	
 	
-	go slt.CreateStartScripts(cssInfo, machine, pbsLaunchChannel, done)
+	go slt.CreateStartScripts(cssInfo, machine, pbsLaunchChannel, done, nil)
 	// Condumes pbs file names
 	go func (pbsLaunchChannel chan string) {
 		for _ = range pbsLaunchChannel {
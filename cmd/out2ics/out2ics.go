@@ -24,7 +24,7 @@ func main() {
 
 	inFileName = os.Args[1]
 
-	go slt.Out2ICs(inFileNameChan, cssInfo)
+	go slt.Out2ICs(inFileNameChan, cssInfo, nil)
 
 	inFileNameChan <- inFileName
 	close(inFileNameChan)
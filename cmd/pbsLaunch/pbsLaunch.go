@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"time"
@@ -12,6 +13,9 @@ import (
 func main () () {
 	defer debug.TimeMe(time.Now())
 
+	slt.AddPlanFlags(flag.CommandLine)
+	flag.Parse()
+
 	if err := slt.PbsLaunch(); err != nil {
 		log.Fatal(err)
 	}
@@ -1,32 +1,23 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"log"
 	"time"
 	
 	"github.com/brunetto/sltools/slt"
 	"github.com/brunetto/goutils/debug"
-	"github.com/brunetto/goutils"
 )
 
 func main () () {
 	defer debug.TimeMe(time.Now())
+
+	slt.AddPlanFlags(flag.CommandLine)
+	flag.IntVar(&slt.Jobs, "jobs", 1, "Number of runs to work at the same time")
+	flag.Parse()
 	
-	// Clean folder
-	slt.SimClean()
-	
-	if !goutils.Exists("complete") {
-		// Check and continue
-		slt.CAC()
-		
-		// Submit // already in cac
-// 		if err := slt.PbsLaunch(); err != nil {
-// 			log.Fatal(err)
-// 		}
-	} else {
-		log.Println("'complete' file found, assume simulations are complete.")
-	}
+	// Clean folder, check and continue
+	slt.Relaunch()
 	
 	fmt.Print("\x07") // Beep when finish!!:D
 }
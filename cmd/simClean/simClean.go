@@ -1,6 +1,7 @@
 package main 
 
 import (
+	"flag"
 	"time"
 
 	"github.com/brunetto/goutils/debug"
@@ -10,6 +11,9 @@ import (
 
 func main () () {
 	defer debug.TimeMe(time.Now())
+
+	slt.AddPlanFlags(flag.CommandLine)
+	flag.Parse()
 	
 	slt.SimClean()
 }
@@ -1,13 +1,12 @@
 package slt
 
 import (
-	"bytes"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"strings"
+	"strconv"
 	"time"
 
 	"github.com/brunetto/goutils/debug"
@@ -16,19 +15,61 @@ import (
 
 // Check And Continue
 func CAC() {
+	var (
+		plan   = NewPlan("cac")
+		runErr = PlanCAC(plan)
+	)
+	if err := plan.Run(); err != nil {
+		log.Fatal(err)
+	}
+	if runErr != nil {
+		log.Fatal(runErr)
+	}
+}
+
+// Relaunch cleans the folder and checks and continues the runs, unless
+// there is a "complete" file, as a single plan.
+func Relaunch() {
+	var (
+		plan   = NewPlan("relaunch")
+		runErr = PlanRelaunch(plan)
+	)
+	if err := plan.Run(); err != nil {
+		log.Fatal(err)
+	}
+	if runErr != nil {
+		log.Fatal(runErr)
+	}
+}
+
+// PlanRelaunch adds the actions of Relaunch to plan: SimClean, then CAC
+// unless there is a "complete" file. The error is the one of PlanCAC.
+func PlanRelaunch(plan *Plan) error {
+	PlanSimClean(plan)
+	if goutils.Exists("complete") {
+		log.Println("'complete' file found, assume simulations are complete.")
+		return nil
+	}
+	// Submit: already included in CAC
+	return PlanCAC(plan)
+}
+
+// PlanCAC adds the actions of CAC to plan: removal of the broken rounds,
+// new ICs, start scripts and submissions, complete runs moved to Rounds.
+// The STDOUTs are read by Jobs goroutines, a run that fails is left out of
+// the plan and reported in the error.
+func PlanCAC(plan *Plan) error {
 	if Debug {
 		defer debug.TimeMe(time.Now())
 	}
 
 	var (
 		err, mapErr error
-		globName    string = "*-comb*-NCM*-fPB*-W*-Z*-run*-rnd*.txt"
+		globName    string
 		runMap      map[string]map[string][]string
 		// for example runMap["08"]["err"][3]
 		// will give ["err-....run08-rnd03.txt"]
 		nProcs           int = 1
-		inFileNameChan       = make(chan string, 1)
-		cssInfo0              = make(chan map[string]string, 1)
 		cssInfo1              = make(chan map[string]string, 1)
 		pbsLaunchChannel0      = make(chan string, 1)
 		pbsLaunchChannel1      = make(chan string, 1)
@@ -36,134 +77,88 @@ func CAC() {
 		done1                 = make(chan struct{}, 1)
 		runs             []string
 		run              string
-		lastErr, lastOut string
-		errInfo, outInfo os.FileInfo
+		lastOut          string
+		checked          = []*cacRun{}
+		runErrs          []error
 		toRemove         = []string{}
 		machine          string
-		machineDiscovery *exec.Cmd
-		stdo             bytes.Buffer
+		profile          *MachineProfile
 		removedFileName string = "Removed.txt"
-		removedFile *os.File
 		tmp map[string]string
 		tmp1 string
 		toContinue = []map[string]string{}
-		completeFile *os.File
 		fileName string
 		fInfo os.FileInfo
+		id RunID
 	)
 
-	log.Println("Try to discover machine name")
-	machineDiscovery = exec.Command("hostname", "-A")
-	if machineDiscovery.Stdout = &stdo; err != nil {
-		log.Fatal("Error connecting STDOUT: ", err)
-	}
+	// Understand the names of the files in the folder
+	LoadNaming(ConfName)
+	globName = Naming.Glob("*", ".txt")
 
-	if err = machineDiscovery.Start(); err != nil {
-		log.Fatal("Error starting machineDiscovery: ", err)
-	}
-
-	if err = machineDiscovery.Wait(); err != nil {
-		log.Fatal("Error while waiting for machineDiscovery: ", err)
-	}
-
-	switch {
-	case strings.Contains(stdo.String(), "eurora"):
-		machine = "eurora"
-	case strings.Contains(stdo.String(), "spritz"):
-		machine = "spritz"
-	case strings.Contains(stdo.String(), "longisland"):
-		machine = "longisland"
-	case strings.Contains(stdo.String(), "sfursat"):
-		machine = "sfursat"
-	case strings.Contains(stdo.String(), "plx"):
-		machine = "plx"
-	case strings.Contains(stdo.String(), "auriga"):
-		machine = "auriga"
-	case strings.Contains(stdo.String(), "tesla"):
-		machine = "tesla"
-	case strings.Contains(stdo.String(), "swin"):
-		machine = "g2swin"
+	log.Println("Try to discover machine name")
+	if profile, err = DetectMachine(); err != nil {
+		log.Fatal(err)
 	}
+	machine = profile.Name
+	// Submit with the scheduler of this machine
+	profile.GetScheduler()
 
 	log.Println("machine set to: ", machine)
 
 	log.Println("Starting goroutines...")
 	
+	fmt.Printf("\tSimulation stop set to (slightly more than) %v and calculated from the snapshot units\n", endOfSimMyrString)
 	for idx := 0; idx < nProcs; idx++ {
-		go Out2ICsEmbed(inFileNameChan, cssInfo0)
-		go CreateStartScripts(cssInfo1, machine, pbsLaunchChannel0, done)
-		go PbsLaunchOnTheFly(pbsLaunchChannel1, done1)
+		go CreateStartScripts(cssInfo1, machine, pbsLaunchChannel0, done, plan)
+		go PbsLaunchOnTheFly(pbsLaunchChannel1, done1, plan)
 	}
 	
 	log.Println("Searching for files in the form: ", globName)
 	// Find last round for each run in the folder
 	// Runs are sorted
-	runs, runMap, mapErr = FindLastRound(globName)
+	runs, runMap, mapErr = FindLastRound(".txt")
 	// Some round are present because of the ics ma don't have errs or outSize,
 	// probably they were run somewhere else (Spritz?)
 	if mapErr != nil {
 		log.Println(mapErr)
 	}
-	
-	// Loop over the last rounds found and print infos
-	fmt.Println(".................................")
 	for _, run = range runs {
 		// In case we have only ics
 		if mapErr != nil && (len(runMap[run]["err"]) == 0 || len(runMap[run]["out"]) == 0) {
 			continue
 		}
-		lastErr = runMap[run]["err"][len(runMap[run]["err"])-1]
-		lastOut = runMap[run]["out"][len(runMap[run]["out"])-1]
-
-		// Check files dimension
-		if errInfo, err = os.Stat(lastErr); err != nil {
-			log.Fatal("Error checking STDERR file size, err")
-		}
-		if outInfo, err = os.Stat(lastOut); err != nil {
-			log.Fatal("Error checking STDOUT file size, err")
-		}
-
-		outSize, outUnit := SizeUnit(outInfo.Size())
-		errSize, errUnit := SizeUnit(errInfo.Size())
-
-		fmt.Printf("%v\t%2.2f %v %v\n\t%2.2f %v %v\n",
-			run, outSize, outUnit, lastOut,
-			errSize, errUnit, lastErr)
-
-		// Removing wrong files
-		if outUnit == "bytes" || errUnit == "GB" {
-			toRemove = append(toRemove, lastErr, lastOut)
-			fmt.Printf("\tRemove because of suspicious dimensions (probably broken):\n\t%v\n\t%v\n ", lastOut, lastErr)
-			for _, file := range []string{lastOut, lastErr} {
-				if err = os.Remove(file); err != nil {
-					log.Fatal("Error while removing ", file, ": ", err)
-				}
-			}
-			if len(runMap[run]["out"])-2 > 0 {
-				inFileNameChan <- runMap[run]["out"][len(runMap[run]["out"])-2] // rerun previous run
-				tmp = <- cssInfo0
-			} else { // Only ics is still here: need to only recreate start script, no new ics
-				tmp = map[string]string{
-					"remainingTime": "500",
-					"randomSeed": "",
-					"newICsFileName": runMap[run]["ics"][0],
-				}				
-			}
-			
-		} else {
-			inFileNameChan <- runMap[run]["out"][len(runMap[run]["out"])-1]
-			tmp = <- cssInfo0
+		checked = append(checked, &cacRun{run: run, files: runMap[run], plan: plan.Sub()})
+	}
+	
+	// Loop over the last rounds found and print infos,
+	// the runs are checked in parallel and continued in order
+	fmt.Println(".................................")
+	runErrs = ForEachRun(len(checked), func(idx int, out io.Writer) error {
+		return checked[idx].check(out)
+	}, func(idx int, err error) {
+		run = checked[idx].run
+		if err != nil {
+			log.Println("Can't check and continue run ", run, ": ", err)
+			fmt.Println()
+			fmt.Println(".................................")
+			return
 		}
+		plan.ForRun(run)
+		plan.Merge(checked[idx].plan)
+		toRemove = append(toRemove, checked[idx].removed...)
+		tmp = checked[idx].info
+		lastOut = runMap[run]["out"][len(runMap[run]["out"])-1]
 		if len(tmp) != 0 {
 			toContinue = append(toContinue, tmp)
 		} else {
 			// Check "Rounds" folder exists, in case create it
 			if fInfo, err = os.Stat("Rounds"); err != nil {
 				if os.IsNotExist(err) {
-					if err = os.Mkdir("Rounds", 0700); err != nil {
+					if err = plan.Mkdir("Rounds", "complete runs"); err != nil {
 						log.Fatal("Can't create folder ", err)
 					}
-					fmt.Println("\tCreated Rounds folder")
+					fmt.Println("\tCreate Rounds folder")
 				} else {
 					log.Fatal("Can't check Rounds folder existance: ", err)
 				}
@@ -178,11 +173,15 @@ func CAC() {
 			fmt.Printf("\tMove all the run %v files to Rounds", run)
 			for _, kindOfFile := range []string{"ics", "err", "out"} {
 				for _, fileName = range runMap[run][kindOfFile]{
-					if err = os.Rename(fileName, filepath.Join("Rounds", fileName)); err != nil {
+					if err = plan.Rename(fileName, filepath.Join("Rounds", fileName), "run "+run+" complete"); err != nil {
 						log.Fatalf("Can't rename %v because %v\n", fileName, err)
 					}
 				}
 			}
+			// Keep track of the run even if the files are moved again
+			if id, err = ParseRunID(lastOut); err == nil {
+				planCompleteState(plan, id, lastOut, "")
+			}
 			
 		}
 		// Create start scripts
@@ -193,20 +192,20 @@ func CAC() {
 		
 		fmt.Println()
 		fmt.Println(".................................")
-	}
+	})
+	plan.ForRun("")
 	
-	if len(toContinue) == 0 {
+	// Runs that failed are not complete
+	if len(toContinue) == 0 && JobsError(runErrs) == nil {
 		log.Println("It seems that all the runs are complete, creating the 'complete' file")
 		
-		if completeFile, err = os.Create("complete"); err != nil {
+		if err = plan.WriteFile("complete", "", "all the runs are complete"); err != nil {
 			log.Fatal("Can't create complete file with error: ", err)
 		}
-		completeFile.Close()
 	}
 	
 	// Close the channel, if you forget it, goroutines
 	// will wait forever
-	close(inFileNameChan)
 	close(cssInfo1)
 	close(pbsLaunchChannel0)
 	close(pbsLaunchChannel1)
@@ -218,20 +217,115 @@ func CAC() {
 	fmt.Println()
 	
 	log.Println("Write removed files to file")
-	if !goutils.Exists(removedFileName) {
-		removedFile, err = os.Create(removedFileName)
-	} else {
-		removedFile, err = os.OpenFile(removedFileName, os.O_APPEND|os.O_WRONLY, 0600)
+	plan.Add(ActionWrite, removedFileName, "", "append the removed files", func() error {
+		var (
+			removedFile *os.File
+			err         error
+		)
+		if !goutils.Exists(removedFileName) {
+			removedFile, err = os.Create(removedFileName)
+		} else {
+			removedFile, err = os.OpenFile(removedFileName, os.O_APPEND|os.O_WRONLY, 0600)
+		}
+		if err != nil {
+			return fmt.Errorf("error while opening removed files file: %v", err)
+		}
+		defer removedFile.Close()
+		if _, err = removedFile.WriteString(fmt.Sprintf("%v %v\n", time.Now().Format(time.RFC850), toRemove)); err != nil {
+			return fmt.Errorf("error while writing removed files to file: %v", err)
+		}
+		return nil
+	})
+	return JobsError(runErrs)
+}
+
+// cacRun is a run checked by PlanCAC.
+type cacRun struct {
+	run string
+	// files are the ics, err and out of the run, by round.
+	files map[string][]string
+	// plan has the actions of the run, merged in the plan of CAC in order.
+	plan *Plan
+	// info is for CreateStartScripts, empty if the run is complete.
+	info    map[string]string
+	removed []string
+}
+
+// check looks at the last round of the run: broken outputs are removed and
+// the round restarted from the previous one, otherwise the new ICs are
+// created from the last STDOUT.
+func (checked *cacRun) check(out io.Writer) error {
+	var (
+		files            = checked.files
+		lastErr          = files["err"][len(files["err"])-1]
+		lastOut          = files["out"][len(files["out"])-1]
+		errInfo, outInfo os.FileInfo
+		id               RunID
+		icsReport        *ICsReport
+		err              error
+	)
+	// Check files dimension
+	if errInfo, err = os.Stat(lastErr); err != nil {
+		return fmt.Errorf("error checking STDERR file size: %v", err)
 	}
-	if err != nil {
-		log.Fatal("Error while opening removed files file: ", err)
+	if outInfo, err = os.Stat(lastOut); err != nil {
+		return fmt.Errorf("error checking STDOUT file size: %v", err)
 	}
-	defer removedFile.Close()
-	if _, err = removedFile.WriteString(fmt.Sprintf("%v %v\n", time.Now().Format(time.RFC850), toRemove)); err != nil {
-		log.Fatal("Error while writing removed files to file: ", err)
+
+	outSize, outUnit := SizeUnit(outInfo.Size())
+	errSize, errUnit := SizeUnit(errInfo.Size())
+
+	fmt.Fprintf(out, "%v\t%2.2f %v %v\n\t%2.2f %v %v\n",
+		checked.run, outSize, outUnit, lastOut,
+		errSize, errUnit, lastErr)
+
+	if !(outUnit == "bytes" || errUnit == "GB") {
+		checked.info, err = Out2ICsFile(lastOut, checked.plan, out, true)
+		return err
 	}
-	
-	
 
-	
+	// Removing wrong files
+	checked.removed = []string{lastErr, lastOut}
+	fmt.Fprintf(out, "\tRemove because of suspicious dimensions (probably broken):\n\t%v\n\t%v\n ", lastOut, lastErr)
+	for _, file := range []string{lastOut, lastErr} {
+		if err = checked.plan.Remove(file, "suspicious size, probably broken"); err != nil {
+			return fmt.Errorf("error while removing %v: %v", file, err)
+		}
+	}
+	if id, err = ParseRunID(lastOut); err == nil {
+		planRoundRemoved(checked.plan, id)
+	}
+	if len(files["out"])-2 > 0 {
+		// rerun previous run
+		checked.info, err = Out2ICsFile(files["out"][len(files["out"])-2], checked.plan, out, true)
+		return err
+	}
+	// Only ics is still here: need to only recreate start script, no new ics.
+	// Invalid ICs only leave this run out, PlanCAC goes on with the others
+	if icsReport, err = ValidateICs(files["ics"][0], ICsExpectFromName(files["ics"][0])); err != nil {
+		return fmt.Errorf("can't validate %v, run not relaunched: %v", files["ics"][0], err)
+	}
+	if err = icsReport.Err(); err != nil {
+		fmt.Fprintln(out, "\tInvalid ICs, run not relaunched")
+		return err
+	}
+	checked.info = map[string]string{
+		"remainingTime":  "500",
+		"randomSeed":     "",
+		"newICsFileName": files["ics"][0],
+	}
+	return nil
+}
+
+// planRoundRemoved plans marking the round of id as removed in the state.
+func planRoundRemoved(plan *Plan, id RunID) {
+	plan.Add(ActionState, StateFileName, "", "round "+strconv.Itoa(id.Round)+" of run "+id.RunStr()+" removed", func() error {
+		if err := UpdateState(".", func(db *StateDB) error {
+			db.Round(id).Removed = true
+			return nil
+		}); err != nil {
+			log.Println("Can't update the state: ", err)
+		}
+		return nil
+	})
 }
@@ -0,0 +1,411 @@
+package slt
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/brunetto/goutils/debug"
+)
+
+// CampaignStruct describes a parameter sweep: the values to explore for
+// each cluster parameter and the settings shared by all the combinations.
+// A campaign file looks like:
+//
+//	{
+//		"Base": {"Runs": 10, "EndTime": 500, "Machine": "eurora",
+//		         "UserName": "bziosi00", "PName": "IscrC_SCmerge", "BinFolder": "$HOME/bin/"},
+//		"FirstComb": 70,
+//		"Seed": 20140106,
+//		"Ncm": [5000, 10000],
+//		"Fpb": [0.05, 0.10],
+//		"W":   [5, 9],
+//		"Z":   [0.01, 0.10, 1.00],
+//		"Rv":  [1],
+//		"Only": [{"Ncm": 10000}, {"Ncm": 5000, "W": 5}]
+//	}
+type CampaignStruct struct {
+	// Base contains the settings common to all the combinations and the
+	// default for the parameters without a list of values.
+	Base ConfigStruct
+	// FirstComb is the number of the first combination,
+	// the following are numbered sequentially.
+	FirstComb int
+	Ncm       []int
+	Fpb       []float64
+	W         []int
+	Z         []float64
+	Rv        []int
+	Tf        []string
+	// Only selects a subset of the Cartesian product: a combination is
+	// kept if it matches all the values of at least one element.
+	// Keys are the parameter names (Ncm, Fpb, W, Z, Rv, Tf).
+	Only []map[string]interface{}
+	// Manifest is the file where the combinations are recorded,
+	// campaign-manifest.json if empty.
+	Manifest string
+	// Seed is the master seed of the campaign, the seeds of every run
+	// are derived from it and the comb and run numbers. 0 to let the
+	// programs choose, the Seed of Base is used then.
+	Seed int64
+}
+
+// campaignParameters are the parameters Only can select.
+var campaignParameters = map[string]bool{
+	"Ncm": true, "Fpb": true, "W": true, "Z": true, "Rv": true, "Tf": true,
+}
+
+// CampaignManifest records the combinations created from a campaign.
+type CampaignManifest struct {
+	Campaign string
+	Updated  time.Time
+	// Seed is the master seed the seeds of the entries come from.
+	Seed         int64
+	Combinations []CampaignEntry
+}
+
+// CampaignEntry maps a combination number to its parameters,
+// configuration file and folder.
+type CampaignEntry struct {
+	Comb     int
+	ConfFile string
+	Folder   string
+	Ncm      int
+	Fpb      float64
+	W        int
+	Z        float64
+	Rv       int
+	Tf       string
+	// Seeds are the seeds of every run, to regenerate any realization.
+	Seeds []*RunSeeds `json:",omitempty"`
+}
+
+// ReadCampaign loads a campaign file.
+func ReadCampaign(campaignName string) (*CampaignStruct, error) {
+	var (
+		campaign = new(CampaignStruct)
+		content  []byte
+		err      error
+	)
+	if content, err = ioutil.ReadFile(campaignName); err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(content, campaign); err != nil {
+		return nil, fmt.Errorf("parse campaign %v: %v", campaignName, err)
+	}
+	if campaign.Manifest == "" {
+		campaign.Manifest = "campaign-manifest.json"
+	}
+	if campaign.Base.Naming != nil {
+		if err = campaign.Base.Naming.Compile(); err != nil {
+			return nil, fmt.Errorf("naming scheme in campaign %v: %v", campaignName, err)
+		}
+	}
+	return campaign, nil
+}
+
+// Expand returns the configurations of the combinations of the campaign,
+// without comb numbers. Only must select by campaign parameters.
+func (campaign *CampaignStruct) Expand() ([]*ConfigStruct, error) {
+	var (
+		base  = campaign.Base
+		confs = []*ConfigStruct{}
+		ncms  = campaign.Ncm
+		fpbs  = campaign.Fpb
+		ws    = campaign.W
+		zs    = campaign.Z
+		rvs   = campaign.Rv
+		tfs   = campaign.Tf
+	)
+	for _, filter := range campaign.Only {
+		for name := range filter {
+			if !campaignParameters[name] {
+				return nil, fmt.Errorf("unknown parameter %v in Only, use Ncm, Fpb, W, Z, Rv or Tf", name)
+			}
+		}
+	}
+	if campaign.Seed != 0 {
+		base.Seed = campaign.Seed
+	}
+	// Parameters without a list take the base value
+	if len(ncms) == 0 {
+		ncms = []int{base.Ncm}
+	}
+	if len(fpbs) == 0 {
+		fpbs = []float64{base.Fpb}
+	}
+	if len(ws) == 0 {
+		ws = []int{base.W}
+	}
+	if len(zs) == 0 {
+		zs = []float64{base.Z}
+	}
+	if len(rvs) == 0 {
+		rvs = []int{base.Rv}
+	}
+	if len(tfs) == 0 {
+		tfs = []string{base.Tf}
+	}
+	// Same default of ReadConf, so that the names don't change
+	// when the config files are read back
+	for idx := range tfs {
+		if tfs[idx] == "" {
+			tfs[idx] = "no"
+		}
+	}
+
+	for _, ncm := range ncms {
+		for _, fpb := range fpbs {
+			for _, w := range ws {
+				for _, z := range zs {
+					for _, rv := range rvs {
+						for _, tf := range tfs {
+							conf := base
+							conf.Ncm, conf.Fpb, conf.W, conf.Z, conf.Rv, conf.Tf = ncm, fpb, w, z, rv, tf
+							if campaign.selected(&conf) {
+								confs = append(confs, &conf)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return confs, nil
+}
+
+// selected tells whether conf is in the subset chosen with Only.
+func (campaign *CampaignStruct) selected(conf *ConfigStruct) bool {
+	var id = conf.RunID(0)
+	if len(campaign.Only) == 0 {
+		return true
+	}
+Filters:
+	for _, filter := range campaign.Only {
+		for name, value := range filter {
+			if !sameValue(value, id.Value(name)) {
+				continue Filters
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// sameValue tells whether the value of a filter of Only, as read from
+// JSON, is the value of a parameter as given by RunID.Value. Numbers are
+// compared as numbers, 1e+06 or "0.10" are fine.
+func sameValue(value interface{}, str string) bool {
+	var (
+		num, filterNum float64
+		err            error
+	)
+	if num, err = strconv.ParseFloat(str, 64); err != nil {
+		return fmt.Sprintf("%v", value) == str
+	}
+	switch filter := value.(type) {
+	case float64:
+		filterNum = filter
+	case string:
+		if filterNum, err = strconv.ParseFloat(filter, 64); err != nil {
+			return false
+		}
+	default:
+		return false
+	}
+	return filterNum == num
+}
+
+// sameParameters tells whether the entry describes conf.
+func (entry *CampaignEntry) sameParameters(conf *ConfigStruct) bool {
+	return entry.Ncm == conf.Ncm && entry.Fpb == conf.Fpb && entry.W == conf.W &&
+		entry.Z == conf.Z && entry.Rv == conf.Rv && entry.Tf == conf.Tf
+}
+
+// ReadManifest loads a campaign manifest, an empty one if the file doesn't exist.
+func ReadManifest(manifestName string) (*CampaignManifest, error) {
+	var (
+		manifest = new(CampaignManifest)
+		content  []byte
+		err      error
+	)
+	if content, err = ioutil.ReadFile(manifestName); err != nil {
+		if os.IsNotExist(err) {
+			return manifest, nil
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal(content, manifest); err != nil {
+		return nil, fmt.Errorf("parse manifest %v: %v", manifestName, err)
+	}
+	return manifest, nil
+}
+
+// WriteManifest saves the manifest as indented JSON.
+func (manifest *CampaignManifest) WriteManifest(manifestName string) error {
+	var (
+		content []byte
+		err     error
+	)
+	manifest.Updated = time.Now()
+	if content, err = json.MarshalIndent(manifest, "", "\t"); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(manifestName, append(content, '\n'), 0644)
+}
+
+// setSeed records the master seed, a campaign whose seeds are already in
+// the manifest can't change it: the recorded realizations would not be
+// reproducible anymore.
+func (manifest *CampaignManifest) setSeed(seed int64) error {
+	if seed == manifest.Seed {
+		return nil
+	}
+	for _, entry := range manifest.Combinations {
+		if len(entry.Seeds) > 0 {
+			return fmt.Errorf("the master seed changed from %v to %v, the seeds in the manifest would not match", manifest.Seed, seed)
+		}
+	}
+	manifest.Seed = seed
+	return nil
+}
+
+// entry returns the entry of the combination comb, nil if not present.
+func (manifest *CampaignManifest) entry(comb int) *CampaignEntry {
+	for idx := range manifest.Combinations {
+		if manifest.Combinations[idx].Comb == comb {
+			return &manifest.Combinations[idx]
+		}
+	}
+	return nil
+}
+
+// assignCombs gives a comb number to each configuration. Combinations
+// already in the manifest keep their number, new ones get the next free
+// number so that a campaign can be extended and created again.
+func (manifest *CampaignManifest) assignCombs(confs []*ConfigStruct, firstComb int) []bool {
+	var (
+		nextComb = firstComb
+		isNew    = make([]bool, len(confs))
+		found    bool
+	)
+	for _, entry := range manifest.Combinations {
+		if entry.Comb >= nextComb {
+			nextComb = entry.Comb + 1
+		}
+	}
+	for idx, conf := range confs {
+		found = false
+		for _, entry := range manifest.Combinations {
+			if entry.sameParameters(conf) {
+				conf.Comb = entry.Comb
+				found = true
+				break
+			}
+		}
+		if !found {
+			conf.Comb = nextComb
+			nextComb++
+			isNew[idx] = true
+		}
+	}
+	return isNew
+}
+
+// CreateCampaign expands the campaign file into one JSON config file for
+// each combination, records them in the manifest and creates the folders
+// and the ICs scripts like CreateICsWrap does.
+// Combinations whose folder already exists are skipped.
+func CreateCampaign(campaignName string, runICC bool) {
+	if Debug {
+		defer debug.TimeMe(time.Now())
+	}
+
+	var (
+		campaign *CampaignStruct
+		manifest *CampaignManifest
+		confs    []*ConfigStruct
+		isNew    []bool
+		content  []byte
+		err      error
+		nProcs   = workerCount()
+		confChan = make(chan *ConfigStruct, 1)
+		done     = make(chan struct{})
+		toCreate = []*ConfigStruct{}
+	)
+
+	if campaign, err = ReadCampaign(campaignName); err != nil {
+		log.Fatal(err)
+	}
+	if manifest, err = ReadManifest(campaign.Manifest); err != nil {
+		log.Fatal(err)
+	}
+	manifest.Campaign = campaignName
+
+	if confs, err = campaign.Expand(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Campaign %v expands to %v combinations\n", campaignName, len(confs))
+	isNew = manifest.assignCombs(confs, campaign.FirstComb)
+	if len(confs) > 0 {
+		if err = manifest.setSeed(confs[0].Seed); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	for idx, conf := range confs {
+		conf.FileName = "conf" + conf.CombStr() + ".json"
+		if !isNew[idx] {
+			if _, err = os.Stat(conf.BaseName()); err == nil {
+				log.Printf("Combination %v already created in %v, skipping\n", conf.Comb, conf.BaseName())
+				continue
+			}
+		} else {
+			manifest.Combinations = append(manifest.Combinations, CampaignEntry{
+				Comb:     conf.Comb,
+				ConfFile: conf.FileName,
+				Folder:   conf.BaseName(),
+				Ncm:      conf.Ncm,
+				Fpb:      conf.Fpb,
+				W:        conf.W,
+				Z:        conf.Z,
+				Rv:       conf.Rv,
+				Tf:       conf.Tf,
+			})
+		}
+		manifest.entry(conf.Comb).Seeds = conf.AllRunSeeds()
+		if Verb {
+			log.Println("Write ", conf.FileName, " for ", conf.BaseName())
+		}
+		if content, err = json.MarshalIndent(conf, "", "\t"); err != nil {
+			log.Fatal("Can't encode ", conf.FileName, ": ", err)
+		}
+		if err = ioutil.WriteFile(conf.FileName, append(content, '\n'), 0644); err != nil {
+			log.Fatal("Can't write ", conf.FileName, ": ", err)
+		}
+		toCreate = append(toCreate, conf)
+	}
+
+	if err = manifest.WriteManifest(campaign.Manifest); err != nil {
+		log.Fatal("Can't write manifest ", campaign.Manifest, ": ", err)
+	}
+	log.Println("Combinations recorded in ", campaign.Manifest)
+
+	for idx := 0; idx < nProcs; idx++ {
+		go CreateICs(confChan, done)
+	}
+	for _, conf := range toCreate {
+		// Read back the file to have the same checks of the hand-written ones
+		conf = InitVars(conf.FileName)
+		conf.RunICC = runICC
+		confChan <- conf
+	}
+	close(confChan)
+	for idx := 0; idx < nProcs; idx++ {
+		<-done
+	}
+}
@@ -0,0 +1,318 @@
+package slt
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/brunetto/goutils/debug"
+)
+
+// outlierRatio is how far from the median a combination or a run must be
+// to be an outlier: less than outlierRatio times the median.
+const outlierRatio = 0.5
+
+// CombStatus aggregates the runs of a combination folder.
+type CombStatus struct {
+	Comb   int
+	Ncm    int
+	Folder string
+	// Complete, Running, Broken and Pending count the runs in each State.
+	Complete, Running, Broken, Pending int
+	// Myr is the simulated time of all the runs.
+	Myr float64
+	// Done is the mean percent done of the runs.
+	Done float64
+	// Rate is the median rate of the runs with one, in Myr per hour.
+	Rate float64
+	// Outliers describe what is odd in the combination or in its runs.
+	Outliers []string
+	Runs     []*RunStatus
+}
+
+// CampaignStatus is the status of the combination folders under Root.
+type CampaignStatus struct {
+	Root     string
+	Time     time.Time
+	EndOfSim string
+	// Complete, Running, Broken, Pending and Myr are the totals.
+	Complete, Running, Broken, Pending int
+	Myr                                float64
+	Combinations                       []*CombStatus
+}
+
+// CampaignStatusReport prints the status of the campaign under root in
+// StatusFormat.
+func CampaignStatusReport(root string) {
+	if Debug {
+		defer debug.TimeMe(time.Now())
+	}
+	var (
+		status *CampaignStatus
+		err    error
+	)
+	LoadNaming(ConfName)
+	if status, err = GatherCampaignStatus(root); err != nil {
+		log.Fatal(err)
+	}
+	if err = status.Print(os.Stdout, StatusFormat); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// FindCombFolders returns the folders under root, root included, with
+// files named after the naming scheme, a state, a "complete" marker or a
+// Rounds folder: once complete, cac moves all the files in Rounds.
+// The Rounds folders and the hidden ones are not searched.
+func FindCombFolders(root string) ([]string, error) {
+	var folders = []string{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if path != root && (info.Name() == "Rounds" || strings.HasPrefix(info.Name(), ".")) {
+			return filepath.SkipDir
+		}
+		if isCombFolder(path) {
+			folders = append(folders, path)
+		}
+		return nil
+	})
+	return folders, err
+}
+
+// isCombFolder tells whether folder is a combination folder.
+func isCombFolder(folder string) bool {
+	if _, ok := combFolderID(folder); ok {
+		return true
+	}
+	for _, name := range []string{StateFileName, "complete", "Rounds"} {
+		if _, err := os.Stat(filepath.Join(folder, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// combFolderID returns the id of a file of the folder or of its Rounds
+// following the naming scheme, or of a file recorded in its state,
+// false if there is none.
+func combFolderID(folder string) (RunID, bool) {
+	var (
+		fileNames []string
+		db        *StateDB
+		id        RunID
+		err       error
+	)
+	for _, dir := range []string{folder, filepath.Join(folder, "Rounds")} {
+		if fileNames, err = Naming.GlobFiles(dir, "*", ".*"); err == nil && len(fileNames) > 0 {
+			id, err = ParseRunID(fileNames[0])
+			return id, err == nil
+		}
+	}
+	if db, err = ReadState(folder); err != nil {
+		return id, false
+	}
+	for _, key := range db.RunNames() {
+		for _, round := range db.Runs[key].Rounds {
+			for _, fileName := range []string{round.ICs, round.Out, round.Err} {
+				if id, err = ParseRunID(fileName); err == nil {
+					return id, true
+				}
+			}
+		}
+	}
+	return id, false
+}
+
+// GatherCampaignStatus builds the status of every combination folder
+// under root with GatherStatus and finds the outliers.
+func GatherCampaignStatus(root string) (*CampaignStatus, error) {
+	var (
+		folders  []string
+		folder   string
+		campaign = &CampaignStatus{Root: root, Time: time.Now(), EndOfSim: endOfSimMyrString, Combinations: []*CombStatus{}}
+		report   *StatusReport
+		id       RunID
+		err      error
+	)
+	if folders, err = FindCombFolders(root); err != nil {
+		return nil, err
+	}
+	if len(folders) == 0 {
+		return nil, fmt.Errorf("no combination folders under %v with names like %v", root, Naming.Glob("*", ".*"))
+	}
+	for _, path := range folders {
+		id, _ = combFolderID(path)
+		if folder, err = filepath.Rel(root, path); err != nil {
+			folder = path
+		}
+		report, _ = GatherStatus(path)
+		comb := newCombStatus(folder, id, report.Runs)
+		campaign.Complete += comb.Complete
+		campaign.Running += comb.Running
+		campaign.Broken += comb.Broken
+		campaign.Pending += comb.Pending
+		campaign.Myr += comb.Myr
+		campaign.Combinations = append(campaign.Combinations, comb)
+	}
+	sort.SliceStable(campaign.Combinations, func(i, j int) bool {
+		return campaign.Combinations[i].Comb < campaign.Combinations[j].Comb
+	})
+	campaign.findOutliers()
+	return campaign, nil
+}
+
+// newCombStatus counts the runs of a combination.
+func newCombStatus(folder string, id RunID, runs []*RunStatus) *CombStatus {
+	var (
+		comb  = &CombStatus{Comb: id.Comb, Ncm: id.Ncm, Folder: folder, Runs: runs}
+		rates = []float64{}
+	)
+	for _, run := range runs {
+		switch run.State {
+		case StateComplete:
+			comb.Complete++
+		case StateRunning:
+			comb.Running++
+		case StateBroken:
+			comb.Broken++
+		default:
+			comb.Pending++
+		}
+		comb.Myr += run.LastMyr
+		comb.Done += run.Done
+		if run.Rate > 0 {
+			rates = append(rates, run.Rate)
+		}
+	}
+	if len(runs) > 0 {
+		comb.Done /= float64(len(runs))
+	}
+	comb.Rate = median(rates)
+	return comb
+}
+
+// findOutliers marks the combinations with broken runs, the ones behind
+// the others and the ones slower than the others with the same Ncm (the
+// rate depends on the number of stars), and the runs behind the others
+// of their combination.
+func (campaign *CampaignStatus) findOutliers() {
+	var (
+		done  = []float64{}
+		rates = map[int][]float64{}
+	)
+	for _, comb := range campaign.Combinations {
+		done = append(done, comb.Done)
+		if comb.Rate > 0 {
+			rates[comb.Ncm] = append(rates[comb.Ncm], comb.Rate)
+		}
+	}
+	medianDone := median(done)
+	for _, comb := range campaign.Combinations {
+		if comb.Broken > 0 {
+			comb.Outliers = append(comb.Outliers, fmt.Sprintf("%v broken runs", comb.Broken))
+		}
+		if comb.Done < outlierRatio*medianDone {
+			comb.Outliers = append(comb.Outliers, fmt.Sprintf("behind: %2.1f%% done, median %2.1f%%", comb.Done, medianDone))
+		}
+		if medianRate := median(rates[comb.Ncm]); comb.Rate > 0 && len(rates[comb.Ncm]) > 2 && comb.Rate < outlierRatio*medianRate {
+			comb.Outliers = append(comb.Outliers, fmt.Sprintf("slow: %2.3f Myr/h, median %2.3f Myr/h with Ncm %v", comb.Rate, medianRate, comb.Ncm))
+		}
+
+		runDone := []float64{}
+		for _, run := range comb.Runs {
+			runDone = append(runDone, run.Done)
+		}
+		medianRunDone := median(runDone)
+		for _, run := range comb.Runs {
+			if run.State != StateComplete && run.State != StateBroken && run.Done < outlierRatio*medianRunDone {
+				comb.Outliers = append(comb.Outliers, fmt.Sprintf("%v behind: %2.1f%% done, median %2.1f%%",
+					run.Run[strings.LastIndex(run.Run, "-")+1:], run.Done, medianRunDone))
+			}
+		}
+	}
+}
+
+// Print writes the campaign status as a table, as JSON or as CSV, one row
+// for each combination.
+func (campaign *CampaignStatus) Print(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		content, err := json.MarshalIndent(campaign, "", "\t")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(content))
+		return err
+	case "csv":
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"comb", "ncm", "folder", "runs", "complete", "running", "broken", "pending",
+			"myr", "done", "rate_myr_per_hour", "outliers"})
+		for _, comb := range campaign.Combinations {
+			writer.Write([]string{
+				strconv.Itoa(comb.Comb), strconv.Itoa(comb.Ncm), comb.Folder, strconv.Itoa(len(comb.Runs)),
+				strconv.Itoa(comb.Complete), strconv.Itoa(comb.Running), strconv.Itoa(comb.Broken), strconv.Itoa(comb.Pending),
+				formatFloat(comb.Myr), formatFloat(comb.Done), formatFloat(comb.Rate), strings.Join(comb.Outliers, "; "),
+			})
+		}
+		writer.Flush()
+		return writer.Error()
+	case "table", "":
+		tab := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tab, "Campaign %v at %v, end of the simulations at %v\n", campaign.Root, campaign.Time.Format("2006-01-02 15:04"), campaign.EndOfSim)
+		fmt.Fprintln(tab, "COMB\tFOLDER\tRUNS\tCOMPLETE\tRUNNING\tBROKEN\tPENDING\tMYR\tDONE\tRATE\t")
+		for _, comb := range campaign.Combinations {
+			mark := ""
+			if len(comb.Outliers) > 0 {
+				mark = "!"
+			}
+			rate := "-"
+			if comb.Rate > 0 {
+				rate = fmt.Sprintf("%2.3f Myr/h", comb.Rate)
+			}
+			fmt.Fprintf(tab, "%02d\t%v\t%v\t%v\t%v\t%v\t%v\t%2.2f\t%2.1f%%\t%v\t%v\n",
+				comb.Comb, comb.Folder, len(comb.Runs), comb.Complete, comb.Running, comb.Broken, comb.Pending,
+				comb.Myr, comb.Done, rate, mark)
+		}
+		fmt.Fprintf(tab, "total\t%v folders\t%v\t%v\t%v\t%v\t%v\t%2.2f\t\t\t\n",
+			len(campaign.Combinations), campaign.Complete+campaign.Running+campaign.Broken+campaign.Pending,
+			campaign.Complete, campaign.Running, campaign.Broken, campaign.Pending, campaign.Myr)
+		if err := tab.Flush(); err != nil {
+			return err
+		}
+		for _, comb := range campaign.Combinations {
+			for _, outlier := range comb.Outliers {
+				fmt.Fprintf(w, "! comb%02d %v\n", comb.Comb, outlier)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown status format %v, use table, json or csv", format)
+	}
+}
+
+// median returns the median of values, 0 if empty.
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	if len(sorted)%2 == 1 {
+		return sorted[len(sorted)/2]
+	}
+	return (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+}
@@ -0,0 +1,84 @@
+package slt
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestCampaignExpand(t *testing.T) {
+	var tests = []struct {
+		name     string
+		campaign string
+		want     []string
+		fails    bool
+	}{
+		{
+			name:     "all",
+			campaign: `{"Base": {"Rv": 1}, "Ncm": [5000, 10000], "Fpb": [0.05], "W": [5], "Z": [0.01, 0.1]}`,
+			want: []string{
+				"TFno-Rv1-NCM5000-fPB005-W5-Z001",
+				"TFno-Rv1-NCM5000-fPB005-W5-Z010",
+				"TFno-Rv1-NCM10000-fPB005-W5-Z001",
+				"TFno-Rv1-NCM10000-fPB005-W5-Z010",
+			},
+		},
+		{
+			name: "only",
+			campaign: `{"Base": {"Rv": 1, "W": 5}, "Ncm": [5000, 10000], "Fpb": [0.05, 0.1], "Z": [0.01, 1],
+				"Only": [{"Ncm": 10000, "Z": 1}, {"Ncm": 5000, "Fpb": 0.1}]}`,
+			want: []string{
+				"TFno-Rv1-NCM5000-fPB01-W5-Z001",
+				"TFno-Rv1-NCM5000-fPB01-W5-Z100",
+				"TFno-Rv1-NCM10000-fPB005-W5-Z100",
+				"TFno-Rv1-NCM10000-fPB01-W5-Z100",
+			},
+		},
+		{
+			name: "numbers as written",
+			campaign: `{"Base": {"Rv": 1, "W": 5, "Fpb": 0.1}, "Ncm": [100000, 1000000], "Z": [0.1, 0.01],
+				"Only": [{"Ncm": 1e+06, "Z": "0.10"}]}`,
+			want: []string{"TFno-Rv1-NCM1000000-fPB01-W5-Z010"},
+		},
+		{
+			name:     "strings",
+			campaign: `{"Base": {"Rv": 1, "Ncm": 5000, "W": 5, "Fpb": 0.1, "Z": 0.1}, "Tf": ["no", "yes"], "Only": [{"Tf": "yes"}]}`,
+			want:     []string{"TFyes-Rv1-NCM5000-fPB01-W5-Z010"},
+		},
+		{
+			name:     "nothing selected",
+			campaign: `{"Base": {"Rv": 1}, "Ncm": [5000], "Only": [{"Ncm": 10000}]}`,
+			want:     []string{},
+		},
+		{
+			name:     "unknown parameter",
+			campaign: `{"Base": {"Rv": 1}, "Ncm": [5000], "Only": [{"NCM": 5000}]}`,
+			fails:    true,
+		},
+	}
+	for _, test := range tests {
+		var (
+			campaign CampaignStruct
+			confs    []*ConfigStruct
+			names    = []string{}
+			err      error
+		)
+		if err = json.Unmarshal([]byte(test.campaign), &campaign); err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		if confs, err = campaign.Expand(); (err != nil) != test.fails {
+			t.Errorf("%v: error %v", test.name, err)
+			continue
+		}
+		if test.fails {
+			continue
+		}
+		for _, conf := range confs {
+			// Without the comb number
+			names = append(names, conf.BaseName()[len("comb00-"):])
+		}
+		if !reflect.DeepEqual(names, test.want) {
+			t.Errorf("%v: got %v, want %v", test.name, names, test.want)
+		}
+	}
+}
@@ -1,110 +1,81 @@
 package slt
 
 import (
-	"bufio"
-	"compress/gzip"
 	"fmt"
 	"log"
-	"math"
-	"os"
 	"strconv"
 )
 
+// CheckSnapshot reads the snapshots of a STDOUT and tells when
+// the simulation will stop and where it is now.
 func CheckSnapshot(inFileName string) {
 	var (
-		err     error
-		inFile  *os.File
-		nReader *bufio.Reader
-		fZip    *gzip.Reader
-		ext     string
-		regRes                         map[string]string
-		lengthUnit, length, nStars, fPB, ncm       float64
-		simulationStop                 int64 
+		err            error
+		units          Units
+		endOfSim       float64
+		nbody          bool
+		simulationStop int64
+		lastTimestep   float64
 	)
-	
-	// 	log.Println("Checking ", inFileName)
-	if inFile, err = os.Open(inFileName); err != nil {
+
+	if endOfSim, nbody, err = ParseSimTime(endOfSimMyrString); err != nil {
 		log.Fatal(err)
 	}
-	defer inFile.Close()
-	
-	// Extract fileNameBody, round and ext
-	if regRes, err = DeepReg(inFileName); err == nil {
-		
-		ext = regRes["ext"]
-		
-		if length, err = strconv.ParseFloat(regRes["Rv"], 64); err != nil {
-			log.Fatalf("Can't convert cluster length %v to float64: %v\n", regRes["Rv"], err)
-		}
-		if fPB, err = strconv.ParseFloat(regRes["fPB"][:1]+"."+regRes["fPB"][1:], 64); err != nil {
-			log.Fatalf("Can't convert cluster fPB %v to float64: %v\n", regRes["fPB"], err)
-		}
-		if ncm, err = strconv.ParseFloat(regRes["NCM"], 64); err != nil {
-			log.Fatalf("Can't convert cluster NCM %v to float64: %v\n", regRes["NCM"], err)
-		}
-		nStars = ncm * (1 + fPB)
-	
-	} else if regRes, err = Reg(inFileName); err == nil {	
-		
-		log.Println("Can't derive deep info from name, only take the names and go standard for the rest")
-		
-		ext = regRes["ext"]
-		
-		// Default
-		length = float64(1)
-		nStars = float64(5500)
-		
-		fmt.Printf("Set default parameters for cluster: \n")
-		fmt.Printf("Radius: %v\n", length)
-		fmt.Printf("Number of stars: %v\n", nStars)
-	} else {
-		log.Println("Can't derive standard names or deep info from STDOUT => wrap it!!")
-		ext = ".txt"
-		
-		// Default
-		length = float64(1)
-		nStars = float64(5500)
-		
-		fmt.Printf("Set default parameters for cluster: \n")
-		fmt.Printf("Radius: %v\n", length)
-		fmt.Printf("Number of stars: %v\n", nStars)
+
+	// 	log.Println("Checking ", inFileName)
+	if units, _, lastTimestep, err = SnapshotProgress(inFileName); err != nil {
+		log.Println("Can't find the units of ", inFileName, ": ", err)
+		return
 	}
-	
-	lengthUnit = math.Sqrt(0.25*0.25*math.Pow(length, 3)*(5500./nStars))
-	simulationStop = 1 + int64(math.Floor(110./lengthUnit))
-	fmt.Printf("\tApprox length unit: %2.2f || simulationStop: %v\n", lengthUnit, simulationStop)
-	
-	switch ext {
-	case ".txt":
-		{
-			nReader = bufio.NewReader(inFile)
-		}
-	case ".gz":
-		{
-			fZip, err = gzip.NewReader(inFile)
-			if err != nil {
-				log.Fatal("Can't open %s: error: %s\n", inFile, err)
-			}
-			nReader = bufio.NewReader(fZip)
-		}
-	case ".txt.gz":
-	{
-		fZip, err = gzip.NewReader(inFile)
-		if err != nil {
-			log.Fatal("Can't open %s: error: %s\n", inFile, err)
+	simulationStop = units.StopTimestep(endOfSim, nbody)
+	fmt.Printf("\t%v || simulationStop: %v (%2.2f Myr)\n", units, simulationStop, units.Myr(float64(simulationStop)))
+	if lastTimestep >= 0 {
+		fmt.Printf("\tLast complete timestep: %v (%2.2f Myr), remaining: %v\n",
+			lastTimestep, units.Myr(lastTimestep), simulationStop-int64(lastTimestep))
+	}
+}
+
+// SnapshotProgress reads the first and the last complete snapshots of a
+// STDOUT and returns the units and their timesteps, -1 if there are none.
+// The units are approximated from the name if the snapshots don't have them,
+// the error is for when even that fails.
+func SnapshotProgress(inFileName string) (units Units, firstTimestep, lastTimestep float64, err error) {
+	var (
+		inFile   *StdReader
+		unitsErr error
+		snap     *DumbSnapshot
+		parsed   *Snapshot
+		read     = false
+	)
+
+	firstTimestep, lastTimestep = -1, -1
+	if inFile, err = OpenStd(inFileName); err != nil {
+		return units, firstTimestep, lastTimestep, err
+	}
+	// The scales are the same in all the snapshots, take the first
+	if snap, err = ReadOutSnapshot(inFile.Reader); err == nil && snap.Integrity {
+		read = true
+		firstTimestep, _ = strconv.ParseFloat(snap.Timestep, 64)
+		if parsed, unitsErr = snap.Parse(); unitsErr == nil {
+			units, unitsErr = UnitsFromSnapshot(parsed)
 		}
-		nReader = bufio.NewReader(fZip)
 	}
-	default:
-		{
-			log.Println("Unrecognized file type", inFileName)
-			log.Fatal("with extension ", ext)
+	inFile.Close()
+
+	// Only the end of uncompressed files is read
+	if read {
+		if snap, err = LastCompleteSnapshot(inFileName); err == nil {
+			lastTimestep, _ = strconv.ParseFloat(snap.Timestep, 64)
+		} else {
+			lastTimestep = firstTimestep
 		}
 	}
 
-	for {
-		if _, err = ReadOutSnapshot(nReader); err != nil {
-			break
+	if lastTimestep < 0 || !read || unitsErr != nil {
+		// Guess from the name
+		if units, err = FindUnits(inFileName); err != nil {
+			return units, firstTimestep, lastTimestep, err
 		}
 	}
+	return units, firstTimestep, lastTimestep, nil
 }
@@ -1,22 +1,125 @@
 package slt
 
 import (
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/brunetto/goutils/debug"
 )
 
+// StatusFormat is how checkStatus prints the report: table, json or csv.
+var StatusFormat = "table"
+
+// Flags of RunStatus for the outputs CAC would remove.
+const (
+	StatusEmptyOut = "empty-out"
+	StatusHugeErr  = "huge-err"
+)
+
+// States of RunStatus.
+const (
+	StateComplete = "complete"
+	StateRunning  = "running"
+	StateBroken   = "broken"
+	// StatePending runs are waiting in the queue or for cac to relaunch them.
+	StatePending = "pending"
+)
+
+// StatusActiveWindow is how recent the last write of a STDOUT must be to
+// take the run as running when the scheduler doesn't know it.
+var StatusActiveWindow = time.Hour
+
+// RunStatus is the row of a run in the status report.
+type RunStatus struct {
+	// Run is the base name of the run, like comb16-...-Z010-run06.
+	Run   string
+	Round int
+	// Out and Err are the last STDOUT and STDERR, empty if the run
+	// has no files in the folder.
+	Out, Err         string
+	OutSize, ErrSize int64
+	// Updated is the last write of Out.
+	Updated time.Time
+	// LastTimestep is the last complete snapshot, -1 if none.
+	LastTimestep float64
+	StopTimestep int64
+	LastMyr      float64
+	StopMyr      float64
+	// Done is the percent of StopMyr reached.
+	Done float64
+	// Rate is the simulated Myr per hour of this round, 0 if unknown.
+	Rate float64
+	// ETA is when the run should reach StopMyr at Rate.
+	ETA *time.Time `json:",omitempty"`
+	// JobID and Queue come from the state and the scheduler.
+	JobID    string
+	Queue    string
+	Complete bool
+	// Flags are StatusEmptyOut and StatusHugeErr.
+	Flags []string
+	// State is one of StateComplete, StateRunning, StateBroken, StatePending.
+	State string
+	// Error is why the run couldn't be checked, the run is broken then.
+	Error string `json:",omitempty"`
+}
+
+// StatusReport is the status of the runs in a folder.
+type StatusReport struct {
+	Time     time.Time
+	EndOfSim string
+	Runs     []*RunStatus
+}
+
+// CheckStatus prints the status of the runs in the folder in StatusFormat
+// and returns the outputs that look broken.
 func CheckStatus() []string {
 	if Debug {
 		defer debug.TimeMe(time.Now())
 	}
 
+	var (
+		report   *StatusReport
+		toRemove []string
+		err      error
+	)
+
+	// Understand the names of the files in the folder
+	LoadNaming(ConfName)
+	report, toRemove = GatherStatus(".")
+	if err = report.Print(os.Stdout, StatusFormat); err != nil {
+		log.Fatal(err)
+	}
+
+	// Suggest to delete broken rounds if it is the case
+	if len(toRemove) > 0 {
+		if StatusFormat == "table" {
+			log.Println("Suggestion, run: ")
+			fmt.Printf("rm %v\n", strings.Join(toRemove, " "))
+		} else {
+			log.Println("Suggestion, run: rm", strings.Join(toRemove, " "))
+		}
+	}
+	return toRemove
+}
+
+// GatherStatus builds the status report of the runs in folder from
+// the last round files and the state, and returns the outputs CAC would
+// remove. The queue state is asked to the scheduler for the rounds with
+// a job ID. The file names are found with Naming, set it before.
+func GatherStatus(folder string) (*StatusReport, []string) {
 	var (
 		err, mapErr error
-		globName    string = "*-comb*-TF*-Rv*-NCM*-fPB*-W*-Z*-run*-*.*"
+		globName    string
 		runMap      map[string]map[string][]string
 		// for example runMap["08"]["err"][3]
 		// will give ["err-....run08-rnd03.txt"]
@@ -26,61 +129,369 @@ func CheckStatus() []string {
 		lastErr, lastOut string
 		errInfo, outInfo os.FileInfo
 		toRemove         = []string{}
+		db               *StateDB
+		id               RunID
+		round            *RoundState
+		seen             = map[string]bool{}
+		endOfSim         float64
+		nbody            bool
+		report           = &StatusReport{Time: time.Now(), EndOfSim: endOfSimMyrString, Runs: []*RunStatus{}}
+		status           *RunStatus
+		scheduler        Scheduler
+		started          time.Time
+		progress         = []statusJob{}
 	)
 
-	log.Println("Searching for files in the form: ", globName)
+	if endOfSim, nbody, err = ParseSimTime(endOfSimMyrString); err != nil {
+		log.Fatal(err)
+	}
+
+	globName = Naming.Glob("*", ".*")
+
+	log.Println("Searching for files in ", folder, " in the form: ", globName)
+
+	// The state has the history the files can't tell
+	if db, err = ReadState(folder); err != nil {
+		log.Println("Can't read the state: ", err)
+		db = &StateDB{Runs: map[string]*RunState{}}
+	}
 
 	// Find last round for each run in the folder
 	// Runs are sorted
-	runs, runMap, mapErr = FindLastRound(globName)
+	runs, runMap, mapErr = FindLastRoundIn(folder, ".*")
 	// Some round are present because of the ics ma don't have errs or outSize,
 	// probably they were run somewhere else (Spritz?)
-	if err != nil {
-		log.Println(err)
+	if mapErr != nil {
+		log.Println(mapErr)
 	}
 
-	// Loop over the last rounds found and print infos
-	fmt.Println(".................................")
 	for _, run = range runs {
 		// In case we have only ics
-		if mapErr != nil && (len(runMap[run]["err"]) == 0 || len(runMap[run]["out"]) == 0) {
+		if len(runMap[run]["err"]) == 0 || len(runMap[run]["out"]) == 0 {
 			continue
 		}
 		lastErr = runMap[run]["err"][len(runMap[run]["err"])-1]
 		lastOut = runMap[run]["out"][len(runMap[run]["out"])-1]
+		status = &RunStatus{Run: run, Out: lastOut, Err: lastErr, LastTimestep: -1}
+		report.Runs = append(report.Runs, status)
 
 		// Check files dimension
 		if errInfo, err = os.Stat(lastErr); err != nil {
-			log.Fatal("Error checking STDERR file size: ", err)
+			log.Println("Error checking STDERR file size: ", err)
+			status.Error = err.Error()
+			continue
 		}
 		if outInfo, err = os.Stat(lastOut); err != nil {
-			log.Fatal("Error checking STDOUT file size: ", err)
+			log.Println("Error checking STDOUT file size: ", err)
+			status.Error = err.Error()
+			continue
 		}
 
-		outSize, outUnit := SizeUnit(outInfo.Size())
-		errSize, errUnit := SizeUnit(errInfo.Size())
-
-		if outUnit == "bytes" || errUnit == "GB" {
+		status.OutSize, status.ErrSize, status.Updated = outInfo.Size(), errInfo.Size(), outInfo.ModTime()
+		_, outUnit := SizeUnit(outInfo.Size())
+		_, errUnit := SizeUnit(errInfo.Size())
+		// Same rule as CAC
+		if outUnit == "bytes" {
+			status.Flags = append(status.Flags, StatusEmptyOut)
+		}
+		if errUnit == "GB" {
+			status.Flags = append(status.Flags, StatusHugeErr)
+		}
+		if len(status.Flags) > 0 {
 			toRemove = append(toRemove, lastErr, lastOut)
 		}
 
-		fmt.Printf("%v\t%2.2f %v %v\n\t%2.2f %v %v\n",
-			run, outSize, outUnit, lastOut,
-			errSize, errUnit, lastErr)
-		CheckSnapshot(lastOut)
-		fmt.Println()
-		fmt.Println(".................................")
+		round, started = nil, time.Time{}
+		if id, err = ParseRunID(lastOut); err == nil {
+			status.Run = stateKey(id)
+			status.Round = id.Round
+			seen[status.Run] = true
+			if runState, ok := db.Runs[status.Run]; ok {
+				status.Complete = runState.Complete
+				for _, r := range runState.Rounds {
+					if r.Round == id.Round {
+						started = r.Started
+					}
+				}
+			}
+			// The last round in the state can be the next one, waiting in the queue
+			round = db.LastRound(id)
+		}
+		if started.IsZero() {
+			// Includes the time in the queue, the rate is underestimated
+			started = roundICsTime(runMap[run]["ics"], status.Round)
+		}
+		if round != nil && round.JobID != "" {
+			status.JobID = round.JobID
+			if scheduler == nil {
+				scheduler = GetScheduler()
+			}
+		}
+		progress = append(progress, statusJob{status: status, started: started})
 	}
-	// Suggest to delete broken rounds if it is the case
-	if len(toRemove) > 0 {
-		log.Println("Suggestion, run: ")
-		fmt.Printf("rm ")
-		for _, fileToDelete := range toRemove {
-			fmt.Printf(" %v ", fileToDelete)
+
+	// Reading the STDOUTs is the slow part, Jobs at a time
+	ForEachRun(len(progress), func(idx int, out io.Writer) error {
+		job := progress[idx]
+		job.status.progress(job.status.Out, job.started, job.status.Updated, endOfSim, nbody)
+		if job.status.JobID != "" {
+			job.status.queue(scheduler, job.status.JobID)
+		}
+		return nil
+	}, nil)
+
+	// Runs without files here, i.e. moved to Rounds or cleaned
+	for _, key := range db.RunNames() {
+		runState := db.Runs[key]
+		if seen[key] || len(runState.Rounds) == 0 {
+			continue
+		}
+		round = runState.Rounds[len(runState.Rounds)-1]
+		status = &RunStatus{Run: key, Round: round.Round, LastTimestep: -1, Complete: runState.Complete}
+		status.recorded(runState, folder, endOfSim, nbody)
+		if round.JobID != "" {
+			if scheduler == nil {
+				scheduler = GetScheduler()
+			}
+			status.queue(scheduler, round.JobID)
 		}
+		report.Runs = append(report.Runs, status)
+	}
+
+	for _, status = range report.Runs {
+		status.State = status.classify(report.Time)
+	}
+	return report, toRemove
+}
+
+// recorded fills the timesteps of a run without files in folder from
+// the last round with an output in the state. The units come from the
+// output moved to Rounds by cac, from the name if it is gone.
+func (status *RunStatus) recorded(runState *RunState, folder string, endOfSim float64, nbody bool) {
+	var (
+		units Units
+		id    RunID
+		err   error
+	)
+	for idx := len(runState.Rounds) - 1; idx >= 0; idx-- {
+		round := runState.Rounds[idx]
+		if round.Out == "" || round.EndTimestep == "" {
+			continue
+		}
+		status.LastTimestep, _ = strconv.ParseFloat(round.EndTimestep, 64)
+		if _, err = os.Stat(filepath.Join(folder, "Rounds", round.Out)); err == nil {
+			units, err = FindUnits(filepath.Join(folder, "Rounds", round.Out))
+		} else if id, err = ParseRunID(round.Out); err == nil {
+			units, err = ApproxUnits(id)
+		}
+		if err != nil {
+			log.Println("Can't find the units of ", round.Out, ": ", err)
+			return
+		}
+		status.StopTimestep = units.StopTimestep(endOfSim, nbody)
+		status.StopMyr = units.Myr(float64(status.StopTimestep))
+		status.LastMyr = units.Myr(status.LastTimestep)
+		status.Done = math.Min(100, 100*status.LastMyr/status.StopMyr)
+		return
 	}
-	fmt.Println()
-	return toRemove
 }
 
-// printf "\n"; pwd; printf "\n"; for (( c=0; c<=9; c++ )); do printf "$c "; ls -lah out-*-run0$c-rnd0* | awk '{print $5"\t"$9}' | tail -n 1; prStintf "  "; ls -lah err-*-run0$c-rnd0* | awk '{print $5"\t"$9}' | tail -n 1; printf "  "; cat $(ls err-*-run0$c-rnd0* | tail -n 1) | grep "Time = " | tail -n 1; done
+// classify tells the State of the run at now. The queue state is used if
+// known, the last write of the STDOUT otherwise.
+func (status *RunStatus) classify(now time.Time) string {
+	switch {
+	case status.Complete:
+		return StateComplete
+	case len(status.Flags) > 0 || status.Error != "":
+		return StateBroken
+	}
+	switch strings.ToLower(status.Queue) {
+	case "r", "running", "e", "exiting", "completing", "configuring":
+		return StateRunning
+	case "q", "queued", "pending", "h", "held", "w", "waiting", "t", "transit":
+		return StatePending
+	}
+	if !status.Updated.IsZero() && now.Sub(status.Updated) < StatusActiveWindow {
+		return StateRunning
+	}
+	return StatePending
+}
+
+// statusJob is a row of GatherStatus waiting for its progress.
+type statusJob struct {
+	status  *RunStatus
+	started time.Time
+}
+
+// progress fills the timesteps, the percent done and the ETA from the
+// snapshots of outName. The rate is the simulated time of the round over
+// the wall time from started to modTime, the last write of outName.
+func (status *RunStatus) progress(outName string, started, modTime time.Time, endOfSim float64, nbody bool) {
+	var (
+		units         Units
+		firstTimestep float64
+		err           error
+	)
+	if units, firstTimestep, status.LastTimestep, err = SnapshotProgress(outName); err != nil {
+		log.Println("Can't find the units of ", outName, ": ", err)
+		status.Error = err.Error()
+		return
+	}
+	status.StopTimestep = units.StopTimestep(endOfSim, nbody)
+	status.StopMyr = units.Myr(float64(status.StopTimestep))
+	if status.LastTimestep < 0 {
+		return
+	}
+	status.LastMyr = units.Myr(status.LastTimestep)
+	status.Done = 100 * status.LastMyr / status.StopMyr
+	if status.LastTimestep >= float64(status.StopTimestep) {
+		status.Done = 100
+		status.Complete = true
+		return
+	}
+
+	if started.IsZero() || !modTime.After(started) || status.LastTimestep <= firstTimestep {
+		return
+	}
+	status.Rate = units.Myr(status.LastTimestep-firstTimestep) / modTime.Sub(started).Hours()
+	eta := modTime.Add(time.Duration((status.StopMyr - status.LastMyr) / status.Rate * float64(time.Hour)))
+	status.ETA = &eta
+}
+
+// roundICsTime returns when the ICs of round were written, zero if
+// they are not among icsNames.
+func roundICsTime(icsNames []string, round int) time.Time {
+	for _, icsName := range icsNames {
+		if id, err := ParseRunID(icsName); err != nil || id.Round != round {
+			continue
+		}
+		if info, err := os.Stat(icsName); err == nil {
+			return info.ModTime()
+		}
+	}
+	return time.Time{}
+}
+
+// queue asks the scheduler the state of jobID.
+func (status *RunStatus) queue(scheduler Scheduler, jobID string) {
+	var err error
+	status.JobID = jobID
+	if status.Queue, err = scheduler.Status(jobID); err != nil {
+		log.Println("Can't get the state of job ", jobID, ": ", err)
+		status.Queue = "unknown"
+	}
+	status.Queue = strings.TrimSpace(status.Queue)
+}
+
+// Print writes the report as a table, as JSON or as CSV.
+func (report *StatusReport) Print(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		content, err := json.MarshalIndent(report, "", "\t")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(content))
+		return err
+	case "csv":
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"run", "round", "last_timestep", "stop_timestep", "last_myr", "stop_myr", "done",
+			"rate_myr_per_hour", "eta", "out", "out_size", "err", "err_size", "job_id", "queue", "complete", "flags", "state", "updated", "error"})
+		for _, status := range report.Runs {
+			eta := ""
+			if status.ETA != nil {
+				eta = formatTime(*status.ETA)
+			}
+			writer.Write([]string{
+				status.Run, strconv.Itoa(status.Round),
+				formatFloat(status.LastTimestep), strconv.FormatInt(status.StopTimestep, 10),
+				formatFloat(status.LastMyr), formatFloat(status.StopMyr), formatFloat(status.Done),
+				formatFloat(status.Rate), eta,
+				status.Out, strconv.FormatInt(status.OutSize, 10), status.Err, strconv.FormatInt(status.ErrSize, 10),
+				status.JobID, status.Queue, strconv.FormatBool(status.Complete), strings.Join(status.Flags, " "),
+				status.State, formatTime(status.Updated), status.Error,
+			})
+		}
+		writer.Flush()
+		return writer.Error()
+	case "table", "":
+		tab := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tab, "Status at %v, end of the simulations at %v\n", report.Time.Format("2006-01-02 15:04"), report.EndOfSim)
+		fmt.Fprintln(tab, "RUN\tRND\tSTATE\tTIMESTEP\tMYR\tDONE\tRATE\tETA\tOUT\tERR\tJOB\tFLAGS")
+		for _, status := range report.Runs {
+			fmt.Fprintf(tab, "%v\t%02d\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+				status.Run, status.Round, status.State, status.tableTimesteps(), status.tableMyr(), status.tableDone(),
+				status.tableRate(), status.tableETA(), tableSize(status.Out, status.OutSize), tableSize(status.Err, status.ErrSize),
+				strings.TrimSpace(status.JobID+" "+status.Queue), status.tableFlags())
+		}
+		return tab.Flush()
+	default:
+		return fmt.Errorf("unknown status format %v, use table, json or csv", format)
+	}
+}
+
+func (status *RunStatus) tableTimesteps() string {
+	if status.LastTimestep < 0 {
+		return "-"
+	}
+	if status.StopTimestep == 0 {
+		return formatFloat(status.LastTimestep)
+	}
+	return fmt.Sprintf("%v/%v", formatFloat(status.LastTimestep), status.StopTimestep)
+}
+
+func (status *RunStatus) tableMyr() string {
+	if status.StopMyr == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%2.2f/%2.2f", status.LastMyr, status.StopMyr)
+}
+
+func (status *RunStatus) tableDone() string {
+	if status.StopMyr == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%2.1f%%", status.Done)
+}
+
+func (status *RunStatus) tableRate() string {
+	if status.Rate == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%2.3f Myr/h", status.Rate)
+}
+
+func (status *RunStatus) tableETA() string {
+	if status.ETA == nil || status.Complete {
+		return "-"
+	}
+	return status.ETA.Format("2006-01-02 15:04")
+}
+
+func (status *RunStatus) tableFlags() string {
+	if status.Error != "" {
+		return strings.Join(append(append([]string{}, status.Flags...), "error"), ",")
+	}
+	return strings.Join(status.Flags, ",")
+}
+
+// tableSize writes a file size with SizeUnit, "-" if there is no file.
+func tableSize(fileName string, size int64) string {
+	if fileName == "" {
+		return "-"
+	}
+	value, unit := SizeUnit(size)
+	return fmt.Sprintf("%2.2f %v", value, unit)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// formatTime writes t for the CSV, empty if zero.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
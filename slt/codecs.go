@@ -0,0 +1,313 @@
+package slt
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Codec describes a compression format that can be used for STDOUTs,
+// STDERRs and ICs. Formats are detected from the magic bytes when reading
+// and from the file extension when writing.
+type Codec struct {
+	// Name of the format, i.e. "gzip".
+	Name string
+	// Ext is the file extension, dot included, i.e. ".gz".
+	Ext string
+	// Magic are the first bytes of a stream in this format.
+	Magic []byte
+	// NewReader wraps r with a decompressor.
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+	// NewWriter wraps w with a compressor, nil if the format is read only.
+	NewWriter func(w io.Writer) (io.WriteCloser, error)
+}
+
+// codecs are the known formats, checked in order.
+var codecs = []*Codec{
+	{
+		Name:  "gzip",
+		Ext:   ".gz",
+		Magic: []byte{0x1f, 0x8b},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriter(w), nil
+		},
+	},
+	{
+		Name:  "bzip2",
+		Ext:   ".bz2",
+		Magic: []byte("BZh"),
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bzip2.NewReader(r)), nil
+		},
+		// The standard library can only decompress bzip2
+		NewWriter: ExternalCodec("bzip2", ".bz2", nil, "bzip2").NewWriter,
+	},
+	ExternalCodec("xz", ".xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, "xz"),
+	ExternalCodec("zstd", ".zst", []byte{0x28, 0xb5, 0x2f, 0xfd}, "zstd"),
+}
+
+// RegisterCodec adds a compression format. Formats registered later
+// take precedence over the built-in ones.
+func RegisterCodec(codec *Codec) {
+	codecs = append([]*Codec{codec}, codecs...)
+}
+
+// CodecByExt returns the codec matching the extension of fileName,
+// nil for uncompressed files.
+func CodecByExt(fileName string) *Codec {
+	for _, codec := range codecs {
+		if strings.HasSuffix(fileName, codec.Ext) {
+			return codec
+		}
+	}
+	return nil
+}
+
+// CodecByName returns the codec with the given name or extension
+// ("gzip", "gz", ".gz"), nil if unknown.
+func CodecByName(name string) *Codec {
+	for _, codec := range codecs {
+		if name == codec.Name || name == codec.Ext || "."+name == codec.Ext {
+			return codec
+		}
+	}
+	return nil
+}
+
+// DetectCodec returns the codec whose magic bytes start header,
+// nil for uncompressed data.
+func DetectCodec(header []byte) *Codec {
+	for _, codec := range codecs {
+		if len(codec.Magic) > 0 && bytes.HasPrefix(header, codec.Magic) {
+			return codec
+		}
+	}
+	return nil
+}
+
+// TrimCodecExt removes the compression extension from fileName, if any.
+func TrimCodecExt(fileName string) string {
+	if codec := CodecByExt(fileName); codec != nil {
+		return strings.TrimSuffix(fileName, codec.Ext)
+	}
+	return fileName
+}
+
+// ExternalCodec returns a codec that pipes the data through an external
+// program supporting the usual "-c" (compress to STDOUT) and "-dc"
+// (decompress to STDOUT) flags, like xz, zstd or bzip2.
+func ExternalCodec(name, ext string, magic []byte, program string) *Codec {
+	return &Codec{
+		Name:  name,
+		Ext:   ext,
+		Magic: magic,
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			var (
+				cmd    = exec.Command(program, "-dc")
+				stdout io.ReadCloser
+				err    error
+			)
+			cmd.Stdin = r
+			cmd.Stderr = os.Stderr
+			if stdout, err = cmd.StdoutPipe(); err != nil {
+				return nil, err
+			}
+			if err = cmd.Start(); err != nil {
+				return nil, fmt.Errorf("can't start %v to read %v data: %v", program, name, err)
+			}
+			return &externalReader{ReadCloser: stdout, cmd: cmd, program: program}, nil
+		},
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+			var (
+				cmd   = exec.Command(program, "-c")
+				stdin io.WriteCloser
+				err   error
+			)
+			cmd.Stdout = w
+			cmd.Stderr = os.Stderr
+			if stdin, err = cmd.StdinPipe(); err != nil {
+				return nil, err
+			}
+			if err = cmd.Start(); err != nil {
+				return nil, fmt.Errorf("can't start %v to write %v data: %v", program, name, err)
+			}
+			return &externalWriter{WriteCloser: stdin, cmd: cmd}, nil
+		},
+	}
+}
+
+type externalReader struct {
+	io.ReadCloser
+	cmd     *exec.Cmd
+	program string
+	// done is true when the program has been waited for, err is how it ended.
+	done bool
+	err  error
+}
+
+// Read returns the error of the external program at the end of its
+// output, so that truncated or corrupted data don't look like a clean EOF.
+func (r *externalReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err == io.EOF && !r.done {
+		r.done = true
+		if waitErr := r.cmd.Wait(); waitErr != nil {
+			r.err = fmt.Errorf("%v: %v", r.program, waitErr)
+		}
+	}
+	if err == io.EOF && r.err != nil {
+		return n, r.err
+	}
+	return n, err
+}
+
+// Close stops the external program if the reader has not been drained,
+// otherwise it returns how the program ended.
+func (r *externalReader) Close() error {
+	if r.done {
+		return r.err
+	}
+	r.done = true
+	r.ReadCloser.Close()
+	r.cmd.Process.Kill()
+	// Killed on purpose, its exit status doesn't matter
+	r.cmd.Wait()
+	return nil
+}
+
+type externalWriter struct {
+	io.WriteCloser
+	cmd *exec.Cmd
+}
+
+// Close sends EOF to the external program and waits for it to finish.
+func (w *externalWriter) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		return err
+	}
+	return w.cmd.Wait()
+}
+
+// StdReader is a buffered reader over a file that can be compressed
+// in any of the known formats.
+type StdReader struct {
+	*bufio.Reader
+	// Codec is the detected format, nil for plain text.
+	Codec *Codec
+	file  *os.File
+	dec   io.ReadCloser
+}
+
+// OpenStd opens fileName detecting the compression from the magic bytes.
+func OpenStd(fileName string) (*StdReader, error) {
+	var (
+		reader = new(StdReader)
+		header []byte
+		err    error
+	)
+	if reader.file, err = os.Open(fileName); err != nil {
+		return nil, err
+	}
+	reader.Reader = bufio.NewReader(reader.file)
+	// Peek returns what it can with an error on short files, it is fine
+	header, _ = reader.Reader.Peek(8)
+	if reader.Codec = DetectCodec(header); reader.Codec == nil {
+		return reader, nil
+	}
+	if reader.dec, err = reader.Codec.NewReader(reader.Reader); err != nil {
+		reader.file.Close()
+		return nil, fmt.Errorf("can't open %v as %v: %v", fileName, reader.Codec.Name, err)
+	}
+	reader.Reader = bufio.NewReader(reader.dec)
+	return reader, nil
+}
+
+// Close closes the decompressor and the file. The error of the
+// decompressor comes first, it tells whether the data were fine.
+func (r *StdReader) Close() error {
+	var err error
+	if r.dec != nil {
+		err = r.dec.Close()
+	}
+	if fileErr := r.file.Close(); err == nil {
+		err = fileErr
+	}
+	return err
+}
+
+// StdWriter is a buffered writer that compresses the data accordingly
+// to the extension of the file name.
+type StdWriter struct {
+	*bufio.Writer
+	// Codec is the format used, nil for plain text.
+	Codec *Codec
+	file  *os.File
+	enc   io.WriteCloser
+}
+
+// CreateStd creates fileName choosing the compression from its extension.
+func CreateStd(fileName string) (*StdWriter, error) {
+	var (
+		writer = &StdWriter{Codec: CodecByExt(fileName)}
+		err    error
+	)
+	if writer.Codec != nil && writer.Codec.NewWriter == nil {
+		return nil, fmt.Errorf("can't write %v files, %v", writer.Codec.Name, fileName)
+	}
+	if writer.file, err = os.Create(fileName); err != nil {
+		return nil, err
+	}
+	if writer.Codec == nil {
+		writer.Writer = bufio.NewWriter(writer.file)
+		return writer, nil
+	}
+	if writer.enc, err = writer.Codec.NewWriter(writer.file); err != nil {
+		writer.file.Close()
+		return nil, fmt.Errorf("can't create %v as %v: %v", fileName, writer.Codec.Name, err)
+	}
+	writer.Writer = bufio.NewWriter(writer.enc)
+	return writer, nil
+}
+
+// Close flushes the buffer, closes the compressor and the file.
+func (w *StdWriter) Close() error {
+	var err error
+	if err = w.Writer.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	if w.enc != nil {
+		if err = w.enc.Close(); err != nil {
+			w.file.Close()
+			return err
+		}
+	}
+	return w.file.Close()
+}
+
+// CompressedName appends the extension of the codec named compress
+// (see CodecByName) to fileName, if not already there.
+// An empty compress leaves the name untouched.
+func CompressedName(fileName, compress string) (string, error) {
+	var codec *Codec
+	if compress == "" {
+		return fileName, nil
+	}
+	if codec = CodecByName(compress); codec == nil {
+		return "", fmt.Errorf("unknown compression format %v", compress)
+	}
+	if strings.HasSuffix(fileName, codec.Ext) {
+		return fileName, nil
+	}
+	return TrimCodecExt(fileName) + codec.Ext, nil
+}
@@ -0,0 +1,195 @@
+package slt
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func codecName(codec *Codec) string {
+	if codec == nil {
+		return ""
+	}
+	return codec.Name
+}
+
+func TestCodecLookup(t *testing.T) {
+	var tests = []struct {
+		fileName, name, trimmed string
+	}{
+		{"out-x.txt", "", "out-x.txt"},
+		{"out-x.txt.gz", "gzip", "out-x.txt"},
+		{"out-x.txt.bz2", "bzip2", "out-x.txt"},
+		{"out-x.txt.xz", "xz", "out-x.txt"},
+		{"out-x.txt.zst", "zstd", "out-x.txt"},
+		{"out-x.gz.txt", "", "out-x.gz.txt"},
+	}
+	for _, test := range tests {
+		if name := codecName(CodecByExt(test.fileName)); name != test.name {
+			t.Errorf("CodecByExt(%v) = %q, want %q", test.fileName, name, test.name)
+		}
+		if trimmed := TrimCodecExt(test.fileName); trimmed != test.trimmed {
+			t.Errorf("TrimCodecExt(%v) = %v, want %v", test.fileName, trimmed, test.trimmed)
+		}
+	}
+	for _, name := range []string{"gzip", "gz", ".gz"} {
+		if codecName(CodecByName(name)) != "gzip" {
+			t.Errorf("CodecByName(%v) is not gzip", name)
+		}
+	}
+	if codec := CodecByName("rar"); codec != nil {
+		t.Errorf("CodecByName(rar) = %v", codec.Name)
+	}
+}
+
+func TestDetectCodec(t *testing.T) {
+	var tests = []struct {
+		header []byte
+		name   string
+	}{
+		{[]byte{0x1f, 0x8b, 0x08}, "gzip"},
+		{[]byte("BZh91AY"), "bzip2"},
+		{[]byte{0xfd, '7', 'z', 'X', 'Z', 0x00, 0x00}, "xz"},
+		{[]byte{0x28, 0xb5, 0x2f, 0xfd, 0x00}, "zstd"},
+		{[]byte("(Particle"), ""},
+		{[]byte{0x1f}, ""},
+		{nil, ""},
+	}
+	for _, test := range tests {
+		if name := codecName(DetectCodec(test.header)); name != test.name {
+			t.Errorf("DetectCodec(%q) = %q, want %q", test.header, name, test.name)
+		}
+	}
+}
+
+func TestCompressedName(t *testing.T) {
+	var tests = []struct {
+		fileName, compress, want string
+		fails                    bool
+	}{
+		{"out-x.txt", "", "out-x.txt", false},
+		{"out-x.txt", "gzip", "out-x.txt.gz", false},
+		{"out-x.txt.gz", "gz", "out-x.txt.gz", false},
+		{"out-x.txt.bz2", "xz", "out-x.txt.xz", false},
+		{"out-x.txt", "rar", "", true},
+	}
+	for _, test := range tests {
+		name, err := CompressedName(test.fileName, test.compress)
+		if (err != nil) != test.fails || name != test.want {
+			t.Errorf("CompressedName(%v, %v) = %v, %v, want %v", test.fileName, test.compress, name, err, test.want)
+		}
+	}
+}
+
+func TestStdRoundTrip(t *testing.T) {
+	var dir = t.TempDir()
+	for _, fileName := range []string{"out-x.txt", "out-x.txt.gz"} {
+		var (
+			path   = filepath.Join(dir, fileName)
+			writer *StdWriter
+			reader *StdReader
+			data   []byte
+			err    error
+		)
+		if writer, err = CreateStd(path); err != nil {
+			t.Fatal(err)
+		}
+		writer.WriteString(outData(0, 2, false))
+		if err = writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if reader, err = OpenStd(path); err != nil {
+			t.Fatal(err)
+		}
+		if codecName(reader.Codec) != codecName(CodecByExt(fileName)) {
+			t.Errorf("%v read as %q", fileName, codecName(reader.Codec))
+		}
+		data, err = ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil || string(data) != outData(0, 2, false) {
+			t.Errorf("%v: read back %v bytes, error %v", fileName, len(data), err)
+		}
+	}
+}
+
+// fakeCodec returns an external codec whose program copies its input
+// when decompressing and fails if it contains "corrupt", like xz on a
+// truncated stream after writing what it could decompress.
+func fakeCodec(t *testing.T) *Codec {
+	var (
+		dir     = t.TempDir()
+		program = filepath.Join(dir, "fakez")
+		data    = filepath.Join(dir, "data")
+		script  = "#!/bin/sh\ncat > " + data + "\ncat " + data + "\nif grep -q corrupt " + data + "; then exit 1; fi\n"
+	)
+	if err := ioutil.WriteFile(program, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return ExternalCodec("fakez", ".fz", []byte("FZ"), program)
+}
+
+func TestExternalReaderErrors(t *testing.T) {
+	var (
+		codec = fakeCodec(t)
+		tests = []struct {
+			name  string
+			input string
+			fails bool
+		}{
+			{"clean", outData(0, 2, false), false},
+			{"corrupt", outData(0, 1, false) + "corrupt\n", true},
+		}
+	)
+	for _, test := range tests {
+		reader, err := codec.NewReader(strings.NewReader(test.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(reader)
+		if (err != nil) != test.fails || string(data) != test.input {
+			t.Errorf("%v: read %v bytes, error %v", test.name, len(data), err)
+		}
+		if err = reader.Close(); (err != nil) != test.fails {
+			t.Errorf("%v: close error %v", test.name, err)
+		}
+	}
+
+	// Closing before the end stops the program without errors
+	reader, err := codec.NewReader(strings.NewReader(outData(0, 100, false)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = reader.Read(make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if err = reader.Close(); err != nil {
+		t.Errorf("early close error %v", err)
+	}
+}
+
+func TestStdReaderCorrupted(t *testing.T) {
+	var (
+		path    = filepath.Join(t.TempDir(), "out-x.txt.fz")
+		scanner *SnapshotScanner
+		reader  *StdReader
+		err     error
+	)
+	RegisterCodec(fakeCodec(t))
+	defer func() { codecs = codecs[1:] }()
+	if err = ioutil.WriteFile(path, []byte("FZ\n"+outData(0, 2, false)+"corrupt\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if reader, err = OpenStd(path); err != nil {
+		t.Fatal(err)
+	}
+	scanner = NewOutSnapshotScanner(reader)
+	for scanner.Scan() {
+	}
+	if scanner.Err() == nil {
+		t.Error("corrupted data read as a clean EOF")
+	}
+	if err = reader.Close(); err == nil {
+		t.Error("the error of the decompressor is lost by Close")
+	}
+}
@@ -3,10 +3,8 @@ package slt
 import (
 	"fmt"
 	"log"
-	"strconv"
 
 	"github.com/spf13/cobra"
-	"github.com/brunetto/goutils"
 )
 
 var (
@@ -31,6 +29,8 @@ var (
 	intTime string
 	endOfSimMyrString string = "110"
 	selectedSnapshot string
+	compress string // compression format for new ICs and stiched outputs, empty for plain text
+	cancelJobs bool
 )
 
 
@@ -97,7 +97,12 @@ var CreateICsCmd = &cobra.Command{
 	Use like:
 	sltools createICs -c conf21.json -v -C
 	sltools createICs -v -C -A # to create folders and ICs for all the config files.
-	I suggest not to use the -C flag and to create manually the ICs from the autogenerated bash scripts with 
+	sltools createICs -c conf21.json -C -j 4 # to create 4 ICs at a time.
+	With -C the programs of the ICsPipeline of the config (makeking, makemass, ... 
+	by default) are run concurrently and a failing stage is reported with its exit 
+	code and STDERR, the partial ICs file is removed. The ICs are then checked 
+	as by ics validate. Without -C you can create 
+	the ICs from the autogenerated bash scripts with 
 	for $script in $(ls create*); do bash $script; done`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if All {
@@ -110,6 +115,136 @@ var CreateICsCmd = &cobra.Command{
 }
 
 
+// ICsCmd groups the commands on the initial conditions files.
+var ICsCmd = &cobra.Command{
+	Use:   "ics",
+	Short: "Work on the ICs files",
+	Long:  `Choose a sub-command or type sltools help ics for help.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("Choose a sub-command or type sltools help ics for help.")
+	},
+}
+
+// icsValidateCmd checks ICs files before they go to the queue.
+var icsValidateCmd = &cobra.Command{
+	Use:   "validate [ICs files]",
+	Short: "Check ICs files before submitting them",
+	Long: `Parse the ICs and check that they are complete, without NaN or
+	duplicate ids and with the metallicity of the cluster. The ICs of the
+	first round (rnd00) must also have Ncm*(1+Fpb) stars, a binary fraction
+	Fpb and the scaling of scale -R 1 -M 1.
+	The cluster parameters come from the config file if given, from the
+	names otherwise. Without files all the ICs in the folder are checked.
+	createICs -C, out2ics and cac run the same checks on the ICs they write.
+	Use like:
+	sltools ics validate ics-cineca-comb16-...-run06-rnd00.txt
+	sltools ics validate -c conf16.json`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if inFileName != "" {
+			args = append(args, inFileName)
+		}
+		if failed := ValidateICsFiles(args, ConfName); failed > 0 {
+			log.Fatalf("%v ICs failed the validation", failed)
+		}
+	},
+}
+
+var campaignName string
+
+// CampaignCmd groups the commands working on a parameter sweep.
+var CampaignCmd = &cobra.Command{
+	Use:   "campaign",
+	Short: "Manage a campaign of simulations described by a grid of parameters",
+	Long: `A campaign file lists the values to explore for Ncm, Fpb, W, Z, Rv and Tf
+	and the settings common to all the combinations (see CampaignStruct).
+	Choose a sub-command or type sltools help campaign for help.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("Choose a sub-command or type sltools help campaign for help.")
+	},
+}
+
+// campaignCreateCmd expands a campaign into config files, folders and ICs scripts.
+var campaignCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create config files, folders and ICs scripts for all the combinations",
+	Long: `Expand the Cartesian product of the campaign parameters (or the subset
+	selected with the Only field) in one confNN.json for each combination,
+	with automatically assigned comb numbers. The mapping is recorded in the
+	campaign manifest, then the folders and the ICs scripts are created 
+	as with createICs. Running it again after adding values to the campaign only 
+	creates the new combinations.
+	Use like:
+	sltools campaign create -p campaign.json [-C]`,
+	Run: func(cmd *cobra.Command, args []string) {
+		CreateCampaign(campaignName, RunICC)
+	},
+}
+
+// campaignStatusCmd aggregates the status of the combination folders.
+var campaignStatusCmd = &cobra.Command{
+	Use:   "status [root]",
+	Short: "Summarize the status of all the combination folders under root",
+	Long: `Find the folders under root (the current one if not given) with files
+	named after the naming scheme (-c), skipping the Rounds folders, and
+	run checkStatus in each of them. For each combination print how many runs
+	are complete, running, broken and pending, the simulated Myr, the mean
+	percent done and the median rate, and mark with ! the outliers: broken
+	runs, combinations behind the others or slower than the others with the
+	same Ncm and runs behind the others of their combination.
+	Use like:
+	sltools campaign status [root] [--format table|json|csv]`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var root = "."
+		if len(args) > 0 {
+			root = args[0]
+		}
+		CampaignStatusReport(root)
+	},
+}
+
+// MachinesCmd groups the commands on the machine profiles.
+var MachinesCmd = &cobra.Command{
+	Use:   "machines",
+	Short: "List and show the machine profiles",
+	Long: `Machine profiles tell queue, walltime, resources, modules and kira binaries
+	of each cluster. They are read from the file given by --machines, from 
+	machines.json in this folder or from ~/.sltools/machines.json and 
+	default to the built-in ones (see MachineProfile).`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("Choose a sub-command or type sltools help machines for help.")
+	},
+}
+
+// machinesListCmd lists the machine profiles.
+var machinesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the machine profiles",
+	Run: func(cmd *cobra.Command, args []string) {
+		ListMachines()
+	},
+}
+
+// machinesShowCmd prints a machine profile.
+var machinesShowCmd = &cobra.Command{
+	Use:   "show [machine name]",
+	Short: "Show a machine profile, the one of this host if no name is given",
+	Run: func(cmd *cobra.Command, args []string) {
+		var (
+			profile *MachineProfile
+			err     error
+		)
+		if len(args) == 0 {
+			profile, err = DetectMachine()
+		} else {
+			profile, err = GetMachine(args[0])
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		profile.Print()
+	},
+}
+
 var force bool = false
 // Out2ICsCmd creates new ICs from STDOUT to restart the simulation
 var Out2ICsCmd = &cobra.Command{
@@ -117,7 +252,8 @@ var Out2ICsCmd = &cobra.Command{
 	Short: "Prepare the new ICs from the last STDOUT",
 	Long: `StarLab can restart a simulation from the last complete output.
 	The out2ics command prepare the new ICs parsing the last STDOUT and writing
-	the last complete snapshot to the new input file.
+	the last complete snapshot to the new input file. Uncompressed STDOUTs are
+	read backward from the end, compressed ones from the beginning.
 	Use like:
 	sltools out2ics -i out-cineca-comb16-NCM10000-fPB005-W5-Z010-run06-rnd00.txt`,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -128,7 +264,8 @@ var Out2ICsCmd = &cobra.Command{
 		if force {
 			log.Println("Force to run even if end-of-simulation detected")
 		}
-		go Out2ICs(inFileNameChan, cssInfo)
+		LoadNaming(ConfName)
+		go Out2ICs(inFileNameChan, cssInfo, nil)
 		inFileNameChan <- inFileName
 		close(inFileNameChan)
 		<-cssInfo
@@ -166,10 +303,11 @@ var CreateStartScriptsCmd = &cobra.Command{
 			for _ = range pbsLaunchChannel {
 			}
 		} (pbsLaunchChannel)
-		go CreateStartScripts(cssInfo, machine, pbsLaunchChannel, done)
+		go CreateStartScripts(cssInfo, machine, pbsLaunchChannel, done, nil)
 		
 		if All {
-			runs, runMap, mapErr := FindLastRound("*-comb*-NCM*-fPB*-W*-Z*-run*-rnd*.txt")
+			LoadNaming(ConfName)
+			runs, runMap, mapErr := FindLastRound(".txt")
 			log.Println("Selected to create start scripts for all the runs in the folder")
 			log.Println("Found: ")
 			for _, run := range runs {
@@ -246,6 +384,7 @@ var StichOutputCmd = &cobra.Command{
 	sltools stichOutput -c conf19.json -i out-cineca-comb19-NCM10000-fPB005-W9-Z010-run09-rnd00.txt
 	sltools stichOutput -c conf19.json -A # to stich all the outputs in the folder`,
 	Run: func(cmd *cobra.Command, args []string) {
+		LoadNaming(ConfName)
 		if All {
 			log.Println("Stich all!")
 			StichThemAll(inFileName)
@@ -268,18 +407,29 @@ var CacCmd = &cobra.Command{
 // ***
 var CheckEndCmd = &cobra.Command{
 	Use:   "checkEnd",
-	Short: "Check the number of timesteps necessary to reach a given time in Myr. Need the files to have standard names.",
+	Short: "Check the number of timesteps necessary to reach a given time in Myr, using the units in the snapshot.",
 	Long: ``,
 	Run: func(cmd *cobra.Command, args []string) {
-		var endOfSimMyr float64 = 100
+		var (
+			endOfSim float64 = 100
+			nbody bool
+			units Units
+		)
 		if inFileName == "" || endOfSimMyrString == "" {
 		log.Fatal("Provide a STDOUT file and a time in Myr to try to find the final timestep")
 	} else {
-		if endOfSimMyr, err = strconv.ParseFloat(endOfSimMyrString, 64); err != nil {
+		if endOfSim, nbody, err = ParseSimTime(endOfSimMyrString); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if nbody {
+		// CheckEnd wants Myr
+		if units, err = FindUnits(inFileName); err != nil {
 			log.Fatal(err)
 		}
+		endOfSim = units.Myr(endOfSim)
 	}
-	CheckEnd (inFileName, endOfSimMyr)
+	CheckEnd (inFileName, endOfSim)
 	},
 }
 
@@ -301,7 +451,12 @@ var CheckSnapshotCmd = &cobra.Command{
 var CheckStatusCmd = &cobra.Command{
 	Use:   "checkStatus",
 	Short: "Check the status of a folder of simulations.",
-	Long: ``,
+	Long: `Print a row for each run in the folder: last round, last complete
+timestep and percent of the end of the simulation (-e), rate in Myr per
+hour and ETA from the file timestamps, size of the last STDOUT and STDERR,
+job ID and queue state if recorded and the flags of the outputs cac would
+remove (empty-out, huge-err).
+Use --format json or csv to feed the report to other tools.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		CheckStatus()
 	},
@@ -374,15 +529,65 @@ var KiraWrapCmd = &cobra.Command{
 	tidal field provided that you have that version of kira, named kiraTF in your
 	~/bin/ folder. Run with 
 	
-	kiraWrap -f.`,
+	kiraWrap -f.
+	
+	With --supervise a stalled kira is killed, STDOUT and STDERR are cut 
+	back --cut-back snapshots and kira is relaunched from there with the 
+	same seed, at most --max-restarts times. The restarts are logged in the STDERR.
+	
+	kira is considered stalled when the STDERR is too big (--max-stderr), when 
+	the simulated time doesn't advance (--stall-after) or when the output grows 
+	too fast (--max-growth). The limits can also be set in the KiraLimits 
+	field of the configuration file, the flags win.
+	
+	SIGTERM, SIGINT and SIGUSR1 are forwarded to kira and kira is stopped 
+	--walltime-margin minutes before the walltime (--walltime, or from the 
+	scheduler environment). At the end kiraWrap writes the exit-*.json record 
+	with the reason and the last complete timestep, used by out2ics.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if icsFileName == "" || intTime == "" {
 			log.Fatal("Provide an ICs file and the integration time.")
 		}
+		LoadKiraWrapConf(cmd)
 		KiraWrap(icsFileName, intTime, randomNumber, noGPU)
 	},
 }
 
+// AddKiraWrapFlags adds the kiraWrap flags to the kiraWrap subcommand and
+// to the kiraWrap binary, so that the scripts can call either of them.
+func AddKiraWrapFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().BoolVarP(&noGPU, "no-GPU", "n", false, "Run without GPU support if kira-no-GPU installed in $HOME/bin/.")
+	cmd.PersistentFlags().BoolVarP(&tf, "tf", "f", false, "Run TF version of kira (debug strings).")
+	cmd.PersistentFlags().BoolVarP(&as, "as", "a", false, "Run Allen-Santillan version of kira (debug strings).")
+	cmd.PersistentFlags().BoolVarP(&noBinaries, "no-binaries", "b", false, "Switch off binary evolution.")
+	cmd.PersistentFlags().StringVarP(&icsFileName, "ics", "i", "", "ICs file to start with.")
+	cmd.PersistentFlags().StringVarP(&intTime, "time", "t", "", "Number of timestep to integrate before stop the simulation.")
+	cmd.PersistentFlags().StringVarP(&randomNumber, "random", "s", "", "Random number.")
+	cmd.PersistentFlags().BoolVar(&KiraSupervise, "supervise", false, "Restart kira from the last snapshots when it stalls.")
+	cmd.PersistentFlags().IntVar(&KiraMaxRestarts, "max-restarts", 3, "Maximum number of restarts in supervisor mode.")
+	cmd.PersistentFlags().IntVar(&KiraCutBack, "cut-back", 2, "Snapshots to drop before restarting in supervisor mode.")
+	cmd.PersistentFlags().StringVar(&KiraWalltime, "walltime", "", "Walltime of the job (HH:MM:SS or DD:HH:MM:SS), read from the scheduler if empty.")
+	cmd.PersistentFlags().Float64Var(&KiraWalltimeMargin, "walltime-margin", KiraWalltimeMargin, "Minutes before the walltime to stop kira.")
+	cmd.PersistentFlags().Float64Var(&Limits.MaxStderrGB, "max-stderr", Limits.MaxStderrGB, "Kill kira as stalled when STDERR is larger than this (GB).")
+	cmd.PersistentFlags().Float64Var(&Limits.MinDiskGB, "min-disk", Limits.MinDiskGB, "Kill kira when the free disk space is less than this (GB).")
+	cmd.PersistentFlags().Float64Var(&Limits.MaxMemPerCent, "max-mem", Limits.MaxMemPerCent, "Kill kira when the used memory is more than this (%).")
+	cmd.PersistentFlags().Float64Var(&Limits.StallMinutes, "stall-after", Limits.StallMinutes, "Kill kira as stalled when the simulated time doesn't advance for this long (minutes, 0 to disable).")
+	cmd.PersistentFlags().Float64Var(&Limits.MaxGrowthMBPerMin, "max-growth", Limits.MaxGrowthMBPerMin, "Kill kira as stalled when the output grows faster than this (MB/min, 0 to disable).")
+	cmd.PersistentFlags().Float64Var(&Limits.PollMinutes, "poll", Limits.PollMinutes, "Minutes between the checks on kira.")
+}
+
+// LoadKiraWrapConf reads the limits from the configuration file,
+// the flags of cmd win.
+func LoadKiraWrapConf(cmd *cobra.Command) {
+	changed := func(name string) bool {
+		flag := cmd.Flags().Lookup(name)
+		return flag != nil && flag.Changed
+	}
+	if err := Limits.LoadConf(ConfName, changed); err != nil {
+		log.Fatal("Kira limits: ", err)
+	}
+}
+
 // ***
 var PbsLaunchCmd = &cobra.Command{
 	Use:   "pbsLaunch",
@@ -395,6 +600,19 @@ var PbsLaunchCmd = &cobra.Command{
 	},
 }
 
+// ***
+// JobsCmd shows or cancels the jobs recorded in the state.
+var JobsCmd = &cobra.Command{
+	Use:   "jobs",
+	Short: "Show the scheduler status of the last submitted round of each run",
+	Long: `Ask the scheduler (PBS, Slurm, local) the status of the jobs
+	recorded in the state of this folder. With --cancel the jobs are
+	also removed from the queue.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		JobStatus(cancelJobs)
+	},
+}
+
 // ***
 var ReLaunchCmd = &cobra.Command{
 	Use:   "relaunch",
@@ -403,20 +621,8 @@ var ReLaunchCmd = &cobra.Command{
 	If all the runs are finished, it writes a "complete" file.`,
 	Long: ``,
 	Run: func(cmd *cobra.Command, args []string) {
-		// Clean folder
-		SimClean()
-		
-		if !goutils.Exists("complete") {
-			// Check and continue
-			CAC()
-			
-			// Submit: already included in CAC
-	// 		if err := slt.PbsLaunch(); err != nil {
-	// 			log.Fatal(err)
-	// 		}
-		} else {
-			log.Println("'complete' file found, assume simulations are complete.")
-		}
+		// Clean folder, check and continue
+		Relaunch()
 	},
 }
 
@@ -459,6 +665,47 @@ var stdErrRestartCmd = &cobra.Command {
 	},	
 }	
 
+// ***
+var SnapCmd = &cobra.Command{
+	Use:   "snap",
+	Short: "Read single snapshots of a STDOUT or timesteps of a STDERR",
+	Long: `The snapshots of a STDOUT, or the timesteps of a STDERR, are indexed
+	with their position in the file, so that they can be read without reading
+	the file from the start. The index is saved in the .index folder next to
+	the file and rebuilt when the file changes. cutsim, restartFromHere,
+	comorbit and stichOutput use it too.
+	Use like:
+	sltools snap index -i out-cineca-comb16-NCM10000-fPB005-W5-Z010-run06-rnd00.txt
+	sltools snap get -i out-cineca-comb16-NCM10000-fPB005-W5-Z010-run06-rnd00.txt -t 350`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("Choose a sub-command or type snap help for help.")
+	},
+}
+
+var snapIndexCmd = &cobra.Command{
+	Use:   "index",
+	Short: "Build the index if needed and print it",
+	Long:  ``,
+	Run: func(cmd *cobra.Command, args []string) {
+		if inFileName == "" {
+			log.Fatal("Provide a STDOUT or a STDERR to index")
+		}
+		SnapIndexReport(inFileName)
+	},
+}
+
+var snapGetCmd = &cobra.Command{
+	Use:   "get",
+	Short: "Print a snapshot as it is in the file",
+	Long:  ``,
+	Run: func(cmd *cobra.Command, args []string) {
+		if inFileName == "" || selectedSnapshot == "" {
+			log.Fatal("Provide a STDOUT or a STDERR and the timestep to print")
+		}
+		SnapGet(inFileName, selectedSnapshot)
+	},
+}
+
 // ***
 var SimCleanCmd = &cobra.Command{
 	Use:   "simClean",
@@ -508,17 +755,11 @@ func InitCommands() {
 	CheckSnapshotCmd.Flags().StringVarP(&inFileName, "inFile", "i", "", "STDOUT to check")
 	ComOrbitCmd.Flags().StringVarP(&inFileName, "inFile", "i", "", "STDOUT from which to extract the center of mass coordinates for the orbit")
 	CheckEndCmd.Flags().StringVarP(&inFileName, "inFile", "i", "", "STDOUT from which to try to find the final timestep")
-	SlToolsCmd.PersistentFlags().StringVarP(&endOfSimMyrString, "endOfSimMyr", "e", "", "Time in Myr to try to find the final timestep")
+	SlToolsCmd.PersistentFlags().StringVarP(&endOfSimMyrString, "endOfSimMyr", "e", "110", "End of the simulation in Myr (110 or 110Myr) or in N-body units (440nb)")
 	CutSimCmd.PersistentFlags().StringVarP(&inFileName, "inFile", "i", "", "Name of the input file")
 	CutSimCmd.PersistentFlags().StringVarP(&selectedSnapshot, "cutTime", "t", "", "At which timestep stop")
 	
-	KiraWrapCmd.PersistentFlags().BoolVarP(&noGPU, "no-GPU", "n", false, "Run without GPU support if kira-no-GPU installed in $HOME/bin/.")
-	KiraWrapCmd.PersistentFlags().BoolVarP(&tf, "tf", "f", false, "Run TF version of kira (debug strings).")
-	KiraWrapCmd.PersistentFlags().BoolVarP(&as, "as", "a", false, "Run Allen-Santillan version of kira (debug strings).")
-	KiraWrapCmd.PersistentFlags().BoolVarP(&noBinaries, "no-binaries", "b", false, "Switch off binary evolution.")
-	KiraWrapCmd.PersistentFlags().StringVarP(&icsFileName, "ics", "i", "", "ICs file to start with.")
-	KiraWrapCmd.PersistentFlags().StringVarP(&intTime, "time", "t", "", "Number of timestep to integrate before stop the simulation.")
-	KiraWrapCmd.PersistentFlags().StringVarP(&randomNumber, "random", "s", "", "Random number.")
+	AddKiraWrapFlags(KiraWrapCmd)
 	
 	ReLaunchCmd.PersistentFlags().BoolVarP(&as, "as", "a", false, "Run Allen-Santillan version of kira (debug strings).")
 	CreateStartScriptsCmd.PersistentFlags().BoolVarP(&as, "as", "a", false, "Run Allen-Santillan version of kira (debug strings).")
@@ -528,24 +769,52 @@ func InitCommands() {
 	
 	RestartFromHereCmd.PersistentFlags().StringVarP(&inFileName, "inFile", "i", "", "Name of the input file")
 	RestartFromHereCmd.PersistentFlags().StringVarP(&selectedSnapshot, "cutTime", "t", "", "At which timestep stop")
+	RestartFromHereCmd.PersistentFlags().StringVarP(&compress, "compress", "z", "", "Write the new ICs compressed (gzip, bzip2, xz, zstd)")
 	
 	SlToolsCmd.PersistentFlags().BoolVarP(&Verb, "verb", "v", false, "Verbose and persistent output")
 	SlToolsCmd.PersistentFlags().BoolVarP(&Debug, "debug", "d", false, "Debug output")
 	SlToolsCmd.PersistentFlags().StringVarP(&ConfName, "confName", "c", "", "Name of the JSON config file")
 	SlToolsCmd.PersistentFlags().BoolVarP(&All, "all", "A", false, "Run command on all the relevant files in the local folder")
+	SlToolsCmd.PersistentFlags().StringVarP(&SchedulerName, "scheduler", "", "", "Batch system: pbs, slurm, local or fake (default from the config file or pbs)")
+	SlToolsCmd.PersistentFlags().BoolVarP(&DryRun, "dry-run", "", false, "Print the plan of cac, simClean, relaunch, pbsLaunch and autosim without changing anything")
+	SlToolsCmd.PersistentFlags().StringVarP(&PlanFormat, "plan-format", "", "table", "Format of the --dry-run plan: table or json")
+	SlToolsCmd.PersistentFlags().IntVarP(&Jobs, "jobs", "j", 1, "Number of runs to work at the same time (createICs, campaign create, out2ics, cac, continue, stichOutput -A, checkStatus)")
+
+	SlToolsCmd.PersistentFlags().StringVarP(&MachinesFile, "machines", "", "", "JSON file with the machine profiles")
+
+	SlToolsCmd.AddCommand(MachinesCmd)
+	MachinesCmd.AddCommand(machinesListCmd)
+	MachinesCmd.AddCommand(machinesShowCmd)
+
+	SlToolsCmd.AddCommand(JobsCmd)
+	CheckStatusCmd.Flags().StringVarP(&StatusFormat, "format", "", "table", "Format of the report: table, json or csv")
+	JobsCmd.Flags().BoolVarP(&cancelJobs, "cancel", "", false, "Cancel the jobs")
 
 	SlToolsCmd.AddCommand(ReadConfCmd)
 
 	SlToolsCmd.AddCommand(CreateICsCmd)
 	CreateICsCmd.Flags().BoolVarP(&RunICC, "runIcc", "C", false, "Run the creation of the ICs instad of only create scripts")
 
+	SlToolsCmd.AddCommand(ICsCmd)
+	ICsCmd.AddCommand(icsValidateCmd)
+	icsValidateCmd.Flags().StringVarP(&inFileName, "inFile", "i", "", "ICs file to check")
+
+	SlToolsCmd.AddCommand(CampaignCmd)
+	CampaignCmd.PersistentFlags().StringVarP(&campaignName, "campaign", "p", "campaign.json", "Name of the JSON campaign file")
+	CampaignCmd.AddCommand(campaignCreateCmd)
+	campaignCreateCmd.Flags().BoolVarP(&RunICC, "runIcc", "C", false, "Run the creation of the ICs instad of only create scripts")
+	CampaignCmd.AddCommand(campaignStatusCmd)
+	campaignStatusCmd.Flags().StringVarP(&StatusFormat, "format", "", "table", "Format of the report: table, json or csv")
+
 	SlToolsCmd.AddCommand(ContinueCmd)
 	ContinueCmd.Flags().StringVarP(&inFileName, "stdOut", "o", "", "Last STDOUT to be used as input")
 	ContinueCmd.Flags().StringVarP(&machine, "machine", "m", "", "Machine where to run")
+	ContinueCmd.Flags().StringVarP(&compress, "compress", "z", "", "Write the new ICs compressed (gzip, bzip2, xz, zstd)")
 
 	SlToolsCmd.AddCommand(Out2ICsCmd)
 	Out2ICsCmd.Flags().StringVarP(&inFileName, "inFile", "i", "", "Last STDOUT to be used as input")
 	Out2ICsCmd.Flags().BoolVarP(&force, "force", "f", false, "Disable end-of-simulaiton check")
+	Out2ICsCmd.Flags().StringVarP(&compress, "compress", "z", "", "Write the new ICs compressed (gzip, bzip2, xz, zstd)")
 
 	SlToolsCmd.AddCommand(CreateStartScriptsCmd)
 	CreateStartScriptsCmd.Flags().StringVarP(&icsName, "icsName", "i", "", "ICs file name")
@@ -557,4 +826,11 @@ func InitCommands() {
 	StichOutputCmd.Flags().StringVarP(&inFileName, "inFile", "i", "", "STDOUT or STDERR name to find what to stich")
 	StichOutputCmd.Flags().BoolVarP(&OnlyOut, "onlyOut", "O", false, "Only stich STDOUTs")
 	StichOutputCmd.Flags().BoolVarP(&OnlyErr, "onlyErr", "E", false, "Only stich STDERRs")
+	StichOutputCmd.Flags().StringVarP(&compress, "compress", "z", "", "Write the stiched files compressed (gzip, bzip2, xz, zstd)")
+
+	SlToolsCmd.AddCommand(SnapCmd)
+	SnapCmd.AddCommand(snapIndexCmd)
+	SnapCmd.AddCommand(snapGetCmd)
+	SnapCmd.PersistentFlags().StringVarP(&inFileName, "inFile", "i", "", "STDOUT or STDERR to read")
+	snapGetCmd.Flags().StringVarP(&selectedSnapshot, "timestep", "t", "", "Timestep to print")
 }
@@ -1,13 +1,10 @@
 package slt
 
 import (
-	"bufio"
-	"compress/gzip"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
 	"time"
 	
@@ -20,66 +17,40 @@ func ComOrbit (inFileName string) () {
 	var (
 		err error
 		outFileName string
-		inFile, outFile *os.File
-		ext string
-		nReader *bufio.Reader
-		fZip *gzip.Reader
-		snap *DumbSnapshot = new(DumbSnapshot)
+		file *SnapFile
+		outFile *os.File
+		baseName string
+		entry *SnapEntry
+		dynamics *Section
 		line string
-		idx int
-		coordReg = regexp.MustCompile(`r  =  (\S+\s+\S+\s+\S+)`)
-		coordRes []string
+		coord string
+		ok bool
 		coords []string = []string{}
 	)
 	
-	ext = filepath.Ext(inFileName)
+	// Remove the extensions, also in case we are reading a compressed file
+	baseName = TrimCodecExt(inFileName)
+	outFileName = "coords-"+strings.TrimSuffix(baseName, filepath.Ext(baseName))+".txt"
 	
-	// Remove gz in case we are reading a zipped file
-	outFileName = "coords-"+strings.Trim(inFileName, ext)+".txt"
-	
-	if inFile, err = os.Open(inFileName); err != nil {
+	// With the index only the root particle of each snapshot is read
+	if file, err = OpenSnapFile(inFileName, "out"); err != nil {
 		log.Fatal(err)
 	}
-	defer inFile.Close()
+	defer file.Close()
 
-	switch ext {
-	case ".txt":
-		{
-			nReader = bufio.NewReader(inFile)
-		}
-	case ".gz":
-		{
-			fZip, err = gzip.NewReader(inFile)
-			if err != nil {
-				log.Fatal("Can't open %s: error: %s\n", inFile, err)
-			}
-			nReader = bufio.NewReader(fZip)
-		}
-	default:
-		{
-			log.Println("Unrecognized file type", inFileName)
-			log.Fatal("with extension ", ext)
-		}
-	}
-	
-	for {
-		if snap, err = ReadOutSnapshot(nReader); err != nil {
+	for _, entry = range file.Entries {
+		if !entry.Integrity {
 			break
 		}
-		// Loop over the snap lines
-		for idx, line = range snap.Lines {
-			// Assuming root node is the first of the snap
-			if !strings.Contains(line, "(Dynamics") {continue}
-			// Assuming coords are 3 lines after Dynamics section beginning
-			if coordRes = coordReg.FindStringSubmatch(snap.Lines[idx+3]); coordRes == nil {
-				log.Fatalf("Can't find coordinates in %v: %v\n", snap.Lines[idx+3], coordRes)
-			}
-// 			fmt.Println(coordRes[1])
-			break
+		if dynamics, err = file.RootSection(entry, "Dynamics"); err != nil {
+			log.Fatalf("Can't read snapshot %v: %v\n", entry.Timestep, err)
+		}
+		// The root position is the cluster center of mass
+		if coord, ok = dynamics.Get("r"); !ok {
+			log.Fatalf("Can't find root coordinates in snapshot %v\n", entry.Timestep)
 		}
 		// For each snap we have one coord set for the COM
-		coords = append(coords, coordRes[1])	
-		coordRes = []string{}
+		coords = append(coords, coord)
 	}
 	
 	if outFile, err = os.Create(outFileName); err != nil {
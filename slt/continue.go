@@ -2,6 +2,7 @@ package slt
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"strings"
 	"time"
@@ -22,15 +23,16 @@ func Continue(inFileName, machine string) {
 
 	var (
 		nProcs int = 1
-		inFileNameChan = make(chan string, 1)
+		inFileNames = []string{}
+		infos []map[string]string
 		cssInfo = make(chan map[string]string, 1)
 		pbsLaunchChannel = make(chan string, 100)
 		done = make(chan struct{})
+		errs []error
 	)
 	
 	for idx:=0; idx<nProcs; idx++ {
-		go Out2ICs(inFileNameChan, cssInfo)
-		go CreateStartScripts(cssInfo, machine, pbsLaunchChannel, done)
+		go CreateStartScripts(cssInfo, machine, pbsLaunchChannel, done, nil)
 		// Consumes pbs file names
 		go func (pbsLaunchChannel chan string) {
 			for _ = range pbsLaunchChannel {
@@ -42,7 +44,8 @@ func Continue(inFileName, machine string) {
 	// and not only on a selected one 
 	if inFileName == "all" || inFileName == "*" || 
 		inFileName == "" || strings.Contains(inFileName, "*") {
-		runs, runMap, mapErr := FindLastRound("*-comb*-NCM*-fPB*-W*-Z*-run*-rnd*.txt")
+		LoadNaming(ConfName)
+		runs, runMap, mapErr := FindLastRound(".txt")
 		log.Println("Selected to continue round for all the runs in the folder")
 		log.Println("Found: ")
 		for _, run := range runs {
@@ -50,27 +53,38 @@ func Continue(inFileName, machine string) {
 				continue
 			}
 			fmt.Printf("%v\n", runMap[run]["out"][len(runMap[run]["out"])-1])
+			// The last round of each run
+			inFileNames = append(inFileNames, runMap[run]["out"][len(runMap[run]["out"])-1])
 		}
 		fmt.Println()
-		// Fill the channel with the last round of each run
-		for _, run := range runs {
-			if mapErr != nil && (len(runMap[run]["err"]) == 0 || len(runMap[run]["out"]) == 0) {
-				continue
-			}
-			inFileNameChan <- runMap[run]["out"][len(runMap[run]["out"])-1]
-		}
 	} else {
 		// Only continue the selected file
-		inFileNameChan <- inFileName
+		inFileNames = append(inFileNames, inFileName)
 	}
 	
+	// Read the STDOUTs Jobs at a time, the scripts are written in order
+	fmt.Printf("\tSimulation stop set to (slightly more than) %v and calculated from the snapshot units\n", endOfSimMyrString)
+	infos = make([]map[string]string, len(inFileNames))
+	errs = ForEachRun(len(inFileNames), func(idx int, out io.Writer) (err error) {
+		infos[idx], err = Out2ICsFile(inFileNames[idx], nil, out, false)
+		return err
+	}, func(idx int, err error) {
+		if err != nil {
+			log.Println("Can't continue ", inFileNames[idx], ": ", err)
+			return
+		}
+		cssInfo <- infos[idx]
+	})
+	
 	// Close the channel, if you forget it, goroutines 
 	// will wait forever
-	close(inFileNameChan)
+	close(cssInfo)
 	
 	// Wait the CreateStartScripts goroutines to finish
 	for idx:=0; idx<nProcs; idx++ {
 		<-done // wait the goroutine to finish
 	}
+	if err := JobsError(errs); err != nil {
+		log.Fatal(err)
+	}
 }
-
@@ -3,12 +3,12 @@ package slt
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
-	"strconv"
+	"text/template"
 	"time"
 
 	"github.com/brunetto/goutils/debug"
@@ -38,7 +38,8 @@ func CreateICsWrap(confName string, runICC bool) {
 	}
 
 	var (
-		nProcs int = 1
+		// The combinations are worked Jobs at a time, their ICs too
+		nProcs    = workerCount()
 		err       error
 		confFiles []string
 		combs     StringSet
@@ -100,16 +101,15 @@ func CreateICs(confChan chan *ConfigStruct, doneParent chan struct{}) {
 	var (
 		err              error
 		folderName       string        // will contain the realizations of this combination
-		icsBaseCmd       string        // common base command to create the ics
+		folderDir        string        // absolute path of folderName
+		icsTemplate      *template.Template // ICs creation script template
+		icsTemplateName  string        // ICs template file, empty for the built-in one
 		icsCmd           string        // complete ICs creation command (contains the output file name)
 		outIcsName       string        // final ICs name
 		outIcsScriptName string        // name for the ICs creation script
 		icsScriptFile    *os.File      // file obj for the ICs script
 		icsScriptWriter  *bufio.Writer // writer for the ICs script
-		outIcsFile       *os.File      // new ICs file
-		nIcsWriter       *bufio.Writer // writer for the ICs file
-		outIcsFileLog    *os.File      // new ICs file creation log
-		nIcsWriterLog    *bufio.Writer // new ICs file creation log writer
+		stages           []PipelineStage // ICs pipeline of the configuration, rendered
 		written          int           // written bytes
 	)
 		
@@ -122,31 +122,14 @@ func CreateICs(confChan chan *ConfigStruct, doneParent chan struct{}) {
 			log.Fatal("I need to know where binaries for ICs are, no folder found in conf struct")
 		}
 		
-		// FIXME: potebbe essere interessante usare http://godoc.org/labix.org/v2/pipe
-		// oppure https://github.com/natefinch/sh
-		// vedere anche http://play.golang.org/p/C608onvLWR
-
-		// ICs binaries with path
-		makeking := filepath.Join(/*os.Getenv("HOME"), "/bin/", */"makeking") //filepath.Join(conf.BinFolder, "makeking") 
-		makemass := filepath.Join(/*os.Getenv("HOME"), "/bin/",*/ "makemass")
-		makesecondary := filepath.Join(/*os.Getenv("HOME"), "/bin/",*/ "makesecondary")
-		add_star := filepath.Join(/*os.Getenv("HOME"), "/bin/",*/ "add_star")
-		scale := filepath.Join(/*os.Getenv("HOME"), "/bin/",*/ "scale")
-		makebinary := filepath.Join(/*os.Getenv("HOME"), "/bin/",*/ "makebinary")
-
-		// Base ICs script commands in a string, it misses the ICs filename
-		icsBaseCmd = "#!/bin/bash\n" +
-			"set -xeu\n" +
-			makeking + " -n " + conf.NcmStr() +
-			" -w " + conf.WStr() +
-			" -i -u \\\n" +
-			"| " + makemass + " -f 8  -l 0.1 -u 150 \\\n" +
-			"| " + makesecondary + " -f " + conf.FpbStr() +
-			" -q -l 0.1 \\\n" +
-			"| " + add_star + " -R " + conf.RvStr() + " -Z " + conf.ZStr() + " \\\n" +
-			"| " + scale + " -R 1 -M 1\\\n" +
-			"| " + makebinary + " -f 2 -o 1 -l 1 -u 107836.09 \\\n" +
-			"> " // Redirect output to the proper ICs file
+		// ICs script template, the built-in one or the one of the configuration
+		icsTemplateName = ""
+		if conf.Templates != nil {
+			icsTemplateName = conf.Templates.ICs
+		}
+		if icsTemplate, err = LoadTemplate("ICs", icsTemplateName, ICsTemplate); err != nil {
+			log.Fatal("Can't load the ICs template: ", err)
+		}
 
 		// Assemble folder name, create it and go into
 // 		folderName = "cineca-comb" + conf.CombStr() +
@@ -161,6 +144,9 @@ func CreateICs(confChan chan *ConfigStruct, doneParent chan struct{}) {
 		if err = os.Mkdir(folderName, 0700); err != nil {
 			log.Fatal("Can't create folder ", err)
 		}
+		if folderDir, err = filepath.Abs(folderName); err != nil {
+			log.Fatal("Can't find absolute path to ", folderName, ": ", err)
+		}
 
 		// Copy config file inside folder to be read and for backup
 		log.Println(fmt.Sprintf("Copy %v to %v", conf.FileName, filepath.Join(folderName, conf.FileName)))
@@ -180,14 +166,28 @@ func CreateICs(confChan chan *ConfigStruct, doneParent chan struct{}) {
 			* BASH SCRIPTS
 			*/
 			// Complete bash script with output file
-			// Basename suffix
-			runString := "-run" + LeftPad(strconv.Itoa(runIdx), "0", 2) + "-rnd00"
-			// ICs final name
-			outIcsName = "ics-" + conf.BaseName() + runString + ".txt\n"
-			// Add ICs final file name to ICs creation command
-			icsCmd = icsBaseCmd + outIcsName
+			// ICs final name, the same of createICsFile
+			id := conf.RunID(runIdx).WithPrefix("ics")
+			outIcsName = conf.RunFileName(id)
+			// Fill the ICs creation template, the pipeline of the
+			// configuration replaces the built-in one
+			data := ScriptData{
+				ID:       id,
+				Dir:      folderDir,
+				ICs:      outIcsName,
+				ICsSeeds: conf.RunSeeds(runIdx).ICsStrings(),
+				Conf:     conf,
+			}
+			if conf.ICsPipeline != nil && icsTemplateName == "" {
+				if stages, err = RenderStages(conf.ICsPipeline, data); err != nil {
+					log.Fatal("Can't write the ICs script: ", err)
+				}
+				icsCmd = PipelineScript(stages, outIcsName)
+			} else if icsCmd, err = RenderScript(icsTemplate, data); err != nil {
+				log.Fatal("Can't write the ICs script: ", err)
+			}
 			// ICs creation script name
-			outIcsScriptName = "create_IC-" + conf.BaseName() + runString + ".sh"
+			outIcsScriptName = conf.RunFileName(id.WithPrefix("create_IC").WithExt(".sh"))
 
 			log.Println("Write ", outIcsScriptName)
 			// Write the script file
@@ -216,140 +216,8 @@ func CreateICs(confChan chan *ConfigStruct, doneParent chan struct{}) {
 		
 		if conf.RunICC {
 			log.Println("Also create ICs files running makeking etc")
-			// Sometimes it crashes, untill I find why, I create the scripts
-			// and the run the binaries only if -C flag is activated
-			for runIdx := 0; runIdx < conf.Runs; runIdx++ {
-				/*
-				* ICs
-				*/
-
-				// Basename suffix
-				runString := "-run" + LeftPad(strconv.Itoa(runIdx), "0", 2) + "-rnd00"
-				// ICs final name
-				outIcsName = "ics-" + conf.BaseName() + runString + ".txt"
-				// Add ICs final file name to ICs creation command
-				icsCmd = icsBaseCmd + outIcsName
-				// ICs creation script name
-				outIcsScriptName = "create_IC-" + conf.BaseName() + runString + ".sh"
-
-				// REINIT PROCESSES BECAUSE EACH COMMAND IS A ONE-TIME CALL
-				// Creating commands and pipes
-				makekingCmd := exec.Command(makeking, "-n", conf.NcmStr(), "-w", conf.WStr(), "-i", "-u")
-				makemassCmd := exec.Command(makemass, "-f", "8", "-l", "0.1", "-u", "150")
-				makesecondaryCmd := exec.Command(makesecondary, "-f", conf.FpbStr(), "-q", "-l", "0.1")
-				add_starCmd := exec.Command(add_star, "-R", conf.RvStr(), "-Z", conf.ZStr())
-				scaleCmd := exec.Command(scale, "-R", "1", "-M", "1")
-				makebinaryCmd := exec.Command(makebinary, "-f", "2", "-o", "1", "-l", "1", "-u", "107836.09")
-
-				// makeking -> makemass
-				if makemassCmd.Stdin, err = makekingCmd.StdoutPipe(); err != nil {
-					log.Fatal("Create pipe to makemass: ", err)
-				}
-				// makemass -> makesecondary
-				if makesecondaryCmd.Stdin, err = makemassCmd.StdoutPipe(); err != nil {
-					log.Fatal("Create pipe to makesecondary: ", err)
-				}
-				// makesecondary -> add_star
-				if add_starCmd.Stdin, err = makesecondaryCmd.StdoutPipe(); err != nil {
-					log.Fatal("Create pipe to add_star: ", err)
-				}
-				// add_star -> scaleCmd
-				if scaleCmd.Stdin, err = add_starCmd.StdoutPipe(); err != nil {
-					log.Fatal("Create pipe to scale: ", err)
-				}
-				// scaleCmd -> makebinaryCmd
-				if makebinaryCmd.Stdin, err = scaleCmd.StdoutPipe(); err != nil {
-					log.Fatal("Create pipe to makebinary: ", err)
-				}
-
-				// Create ICs file and writer
-				if outIcsFile, err = os.Create(filepath.Join(folderName, outIcsName)); err != nil {
-					log.Fatal("Can't create ICs file: ", err)
-				}
-				defer outIcsFile.Close()
-				nIcsWriter = bufio.NewWriter(outIcsFile)
-				defer nIcsWriter.Flush()
-
-				// Create ICs log file and writer
-				if outIcsFileLog, err = os.Create(filepath.Join(folderName, "Create-" + outIcsName + ".log")); err != nil {
-					log.Fatal("Can't create log file: ", err)
-				}
-				defer outIcsFileLog.Close()
-				nIcsWriterLog = bufio.NewWriter(outIcsFileLog)
-				defer nIcsWriterLog.Flush()
-
-				makemassCmd.Stderr = nIcsWriterLog
-				makesecondaryCmd.Stderr = nIcsWriterLog
-				add_starCmd.Stderr = nIcsWriterLog
-				scaleCmd.Stderr = nIcsWriterLog
-				makebinaryCmd.Stderr = nIcsWriterLog
-
-				// Attach the file writer to the cmd stdout
-				makebinaryCmd.Stdout = nIcsWriter
-
-				log.Println("Starting the creation of ", outIcsName)
-				if err = makekingCmd.Start(); err != nil {
-					log.Fatal("Start makeking: ", err)
-				}
-				if err = makekingCmd.Wait(); err != nil {
-					log.Fatal("Wait makeking: ", err)
-				}
-				if err = makemassCmd.Start(); err != nil {
-					log.Fatal("Start makemass: ", err)
-				}
-				if err = makemassCmd.Wait(); err != nil {
-					log.Fatal("Wait makemass: ", err)
-				}
-
-				if err = makesecondaryCmd.Start(); err != nil {
-					log.Fatal("Start makesecondary: ", err)
-				}
-				if err = makesecondaryCmd.Wait(); err != nil {
-					log.Fatal("Wait makesecondary: ", err)
-				}
-
-				if err = add_starCmd.Start(); err != nil {
-					log.Fatal("Start add_star: ", err)
-				}
-				if err = add_starCmd.Wait(); err != nil {
-					log.Fatal("Wait add_Star: ", err)
-				}
-
-				if err = scaleCmd.Start(); err != nil {
-					log.Fatal("Start scale: ", err)
-				}
-				if err = scaleCmd.Wait(); err != nil {
-					log.Fatal("Wait scale: ", err)
-				}
-
-				if err = makebinaryCmd.Start(); err != nil {
-					log.Fatal("Start makebinary: ", err)
-				}
-				if err = makebinaryCmd.Wait(); err != nil {
-					log.Fatal("Wait makebinary: ", err)
-				}
-
-				nIcsWriter.Flush()
-				nIcsWriterLog.Flush()
-
-				/*
-					In case of problems, Dave Cheney suggest
-					(https://groups.google.com/d/msg/golang-nuts/pBa-6ywQE8c/V9JOsXMENrAJ)
-					to lock the log while writing
-
-					type W struct {
-						w io.Writer
-						sync.Mutex
-					}
-
-					func (w *W) Write(buf []byte) (int, error) {
-						w.Lock()
-						defer w.Unlock()
-						return w.w.Write(buf)
-					}
-				*/
-
-				log.Println("Wrote ", outIcsName)
+			if err = createICsFiles(conf, folderName, folderDir); err != nil {
+				log.Fatal(err)
 			}
 		} else {
 			fmt.Println()
@@ -369,3 +237,65 @@ func CreateICs(confChan chan *ConfigStruct, doneParent chan struct{}) {
 	}
 	doneParent <- struct{}{}
 }
+
+// createICsFiles runs the ICs pipeline for every run of conf, Jobs at a
+// time. Every run goes on when another one fails, the error lists the
+// failed ones.
+func createICsFiles(conf *ConfigStruct, folderName, folderDir string) error {
+	var pipeline = conf.ICsPipeline
+	if pipeline == nil {
+		pipeline = DefaultICsPipeline()
+	}
+	errs := ForEachRun(conf.Runs, func(runIdx int, out io.Writer) error {
+		return createICsFile(conf, pipeline, runIdx, folderName, folderDir)
+	}, func(runIdx int, err error) {
+		if err != nil {
+			log.Println(err)
+		}
+	})
+	if err := JobsError(errs); err != nil {
+		return fmt.Errorf("ICs: %v", err)
+	}
+	return nil
+}
+
+// createICsFile runs the ICs pipeline for a run and validates the ICs,
+// the STDERR of the stages goes to Create-<ICs name>.log.
+func createICsFile(conf *ConfigStruct, pipeline []PipelineStage, runIdx int, folderName, folderDir string) error {
+	var (
+		id         = conf.RunID(runIdx).WithPrefix("ics")
+		outIcsName = conf.RunFileName(id)
+		stages     []PipelineStage
+		logFile    *os.File
+		report     *ICsReport
+		err        error
+	)
+	if stages, err = RenderStages(pipeline, ScriptData{ID: id, Dir: folderDir, ICs: outIcsName, ICsSeeds: conf.RunSeeds(runIdx).ICsStrings(), Conf: conf}); err != nil {
+		return fmt.Errorf("%v: %v", outIcsName, err)
+	}
+	if logFile, err = os.Create(filepath.Join(folderName, "Create-"+outIcsName+".log")); err != nil {
+		return fmt.Errorf("can't create log file: %v", err)
+	}
+	defer logFile.Close()
+
+	// Many combinations can be created at the same time, each with
+	// Jobs runs: the pipelines of all of them share Jobs slots
+	if err = withJobSlot(func() error {
+		log.Println("Starting the creation of ", outIcsName)
+		if err = RunPipeline(stages, filepath.Join(folderName, outIcsName), logFile); err != nil {
+			return err
+		}
+		report, err = ValidateICs(filepath.Join(folderName, outIcsName), conf.ExpectedICs())
+		return err
+	}); err != nil {
+		return fmt.Errorf("%v: %v", outIcsName, err)
+	}
+	for _, warning := range report.Warnings {
+		log.Println(outIcsName, ": ", warning)
+	}
+	if err = report.Err(); err != nil {
+		return err
+	}
+	log.Println("Wrote and validated ", outIcsName)
+	return nil
+}
@@ -1,11 +1,12 @@
 package slt
 
 import (
-	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"text/template"
 	"time"
 
 	"github.com/brunetto/goutils"
@@ -13,7 +14,11 @@ import (
 )
 
 // CreateStartScripts create the start scripts (kira launch and PBS launch for the ICs).
-func CreateStartScripts(cssInfo chan map[string]string, machine string, pbsLaunchChannel chan string, done chan struct{}) {
+// Queue, modules and binaries come from the machine profile, the scripts are
+// rendered from the templates (see ScriptTemplates) and the job script name
+// keeps the PBS prefix whatever the scheduler.
+// The scripts are written through plan, right away if nil.
+func CreateStartScripts(cssInfo chan map[string]string, machine string, pbsLaunchChannel chan string, done chan struct{}, plan *Plan) {
 	if Debug {
 		defer debug.TimeMe(time.Now())
 	}
@@ -25,26 +30,56 @@ func CreateStartScripts(cssInfo chan map[string]string, machine string, pbsLaunc
 		stdOutFile     string   // STDOUT file for the next run
 		stdErrFile     string   // STDOUT file for the next run
 		shortName      string   // id for the job
-		queue          string   // name of the queue on wich we will run
-		comb, run, rnd string   //combination, run and round number
-		baseName       string   // common part of the name without the extension
-		walltime       string   // max time we can run on the queue
+		id             RunID    // combination, run and round number
 		kiraString     string   // string to launch kira
-		pbsString      string   // string to submit the job to PBS
-		kiraFile       *os.File // where to save kiraString
-		pbsFile        *os.File // where to save PBS string
+		pbsString      string   // job script for the scheduler
 		kiraOutName    string   // kira file name
 		pbsOutName     string   // PBS file name
 		home           string   // path to home on the cluster
-		kiraBinPath    string   // path to kira binaries
-		modules        string   // modules we need to load
-		regRes         map[string]string
-		randomString   string
+		randomSeed     string
 		timeTest       int
-		project        string
-		tidalString string = ""
+		profile        *MachineProfile
+		scheduler      Scheduler
+		templates      ScriptTemplates
+		kiraTemplate   *template.Template
+		jobTemplate    *template.Template
+		data           ScriptData
+		kira           *KiraProfile
+		kiraBinary     string
+		confFile       string
 	)
 
+	if profile, err = GetMachine(machine); err != nil {
+		log.Fatal(err)
+	}
+	scheduler = profile.GetScheduler()
+	// The configuration overrides the machine
+	templates = profile.Templates.Merge(loadConfTemplates(ConfName))
+	if kiraTemplate, err = LoadTemplate("kiraLaunch", templates.KiraLaunch, KiraLaunchTemplate); err != nil {
+		log.Fatal("Can't load the kiraLaunch template: ", err)
+	}
+	if jobTemplate, err = LoadTemplate("job", templates.Job, scheduler.Template()); err != nil {
+		log.Fatal("Can't load the job template: ", err)
+	}
+
+	if kira, err = LoadKiraProfile(ConfName); err != nil {
+		log.Fatal("Kira profile: ", err)
+	}
+	if as {
+		kira.Tidal = "as"
+	}
+	// kira is run directly if the machine says so or the kira profile
+	// gives the binary, the profile wins
+	kiraBinary = profile.KiraPath()
+	if kira.Binary != "" {
+		kiraBinary = kira.BinaryPath()
+	}
+	if ConfName != "" {
+		if confFile, err = filepath.Abs(ConfName); err != nil {
+			log.Fatal("Can't find the configuration file: ", err)
+		}
+	}
+
 	if home = os.Getenv("HOME"); home == "" {
 		log.Fatal("Can't get $HOME variable and locate your home")
 	}
@@ -74,27 +109,23 @@ func CreateStartScripts(cssInfo chan map[string]string, machine string, pbsLaunc
 			continue
 		}
 
-		if regRes, err = Reg(infoMap["newICsFileName"]); err != nil {
+		if id, err = ParseRunID(infoMap["newICsFileName"]); err != nil {
 			log.Fatal(err)
 		}
-		if regRes["prefix"] != "ics" {
-			log.Fatalf("Please specify an ICs file, found %v prefix", regRes["prefix"])
+		if id.Prefix != "ics" {
+			log.Fatalf("Please specify an ICs file, found %v prefix", id.Prefix)
 		}
 
 		if infoMap["randomSeed"] == "0" {
-			randomString = ""
-		} else if infoMap["randomSeed"] == "" {
-			randomString = ""
+			randomSeed = ""
 		} else {
-			randomString = "-s " + infoMap["randomSeed"]
+			randomSeed = infoMap["randomSeed"]
+		}
+		if randomSeed == "" {
+			randomSeed = FirstKiraSeed(ConfName, id)
 		}
 
-		baseName = regRes["baseName"]
-		comb = regRes["comb"]
-		run = regRes["run"]
-		rnd = regRes["rnd"]
-
-		shortName = "r" + comb + "-" + run + "-" + rnd
+		shortName = id.ShortName()
 
 		if currentDir, err = os.Getwd(); err != nil {
 			log.Fatal("Can't find current working folder!!")
@@ -103,128 +134,51 @@ func CreateStartScripts(cssInfo chan map[string]string, machine string, pbsLaunc
 			log.Fatal("Can't find absolute path to current working folder!!")
 		}
 
-		stdOutFile = "out-" + baseName + "-run" + run + "-rnd" + rnd + ".txt"
-		stdErrFile = "err-" + baseName + "-run" + run + "-rnd" + rnd + ".txt"
-		kiraOutName = "kiraLaunch-" + baseName + "-run" + run + "-rnd" + rnd + ".sh"
-		pbsOutName = "PBS-" + baseName + "-run" + run + "-rnd" + rnd + ".sh"
-
-		if as {
-				tidalString = " -a "
-		}
-		
-		if machine == "eurora" {
-			modules = "module purge\n" +
-				"module load profile/advanced\n" +
-				"module load gnu/4.6.3\n" +
-				"module load boost/1.53.0--gnu--4.6.3\n" +
-				"module load cuda\n\n" +
-				"# # # LD_LIBRARY_PATH=$LD_LIBRARY_PATH:" +
-				"/cineca/prod/compilers/cuda/5.0.35/none/lib64:" +
-				"/cineca/prod/libraries/boost/1.53.0/gnu--4.6.3/lib\n" +
-				"# # # LD_LIBRARY_PATH=$LD_LIBRARY_PATH:/eurora/home/userexternal/mmapelli/\n\n" +
-				"LD_LIBRARY_PATH=/cineca/prod/compilers/cuda/5.0.35/none/lib64:/cineca/prod/libraries/boost/1.53.0/gnu--4.6.3/lib\n" +
-				"export LD_LIBRARY_PATH\n"
-			queue = "parallel"
-			walltime = "4:00:00"
-			project = "IscrC_SCmerge"			
-			kiraString = "#echo $PWD\n" +
-				"#echo $LD_LIBRARY_PATH\n" +
-				"#echo $HOSTNAME\n" +
-				"#date\n" +
-				filepath.Join(home, "bin", "kiraWrap") + tidalString + " -i " +
-				filepath.Join(currentDir, infoMap["newICsFileName"]) + " -t " +
-				infoMap["remainingTime"] + " " +
-				randomString + "\n"
-			pbsString = "#!/bin/bash\n" +
-				"#PBS -N r" + shortName + "\n" +
-				"#PBS -A " + project + "\n" +
-				"#PBS -q " + queue + "\n" +
-				"#PBS -l walltime=" + walltime + "\n" +
-				"#PBS -l select=1:ncpus=1:ngpus=2\n\n" +
-				modules +
-				"sh " + filepath.Join(currentDir, kiraOutName) + "\n"
-			// 			kiraBinPath + " -t " + infoMap["remainingTime"] + " -d 1 -D 1 -b 1 -f 0 \\\n" +
-			// 			" -n 10 -e 0.000 -B " + randomString + " \\\n" +
-			// 			"<  " + filepath.Join(currentDir, icsName) + " \\\n" +
-			// 			">  " + filepath.Join(currentDir, stdOutFile) + " \\\n" +
-			// 			"2> " + filepath.Join(currentDir, stdErrFile) + " \n"
-		} else if machine == "g2swin" {
-			modules = "module purge\n" +
-				// 					"module load profile/advanced\n" +
-				"module load gcc/4.6.4\n" +
-				"module load boost/x86_64/gnu/1.51.0-gcc4.6\n" +
-				"module load cuda/4.0\n\n" //+
-				// 					"LD_LIBRARY_PATH=/cineca/prod/compilers/cuda/5.0.35/none/lib64:/cineca/prod/libraries/boost/1.53.0/gnu--4.6.3/lib\n" +
-				// 					"export LD_LIBRARY_PATH\n"
-			queue = "gstar"
-			walltime = "07:00:00:00"
-			project = "p003_swin"
-
-			kiraString = "#echo $PWD\n" +
-				"#echo $LD_LIBRARY_PATH\n" +
-				"#echo $HOSTNAME\n" +
-				"#date\n" +
-				filepath.Join(home, "bin", "kiraWrap") + tidalString + " -i " +
-				filepath.Join(currentDir, infoMap["newICsFileName"]) + " -t " +
-				infoMap["remainingTime"] + " " +
-				randomString + "\n"
-			pbsString = "#!/bin/bash\n" +
-				"#PBS -N r" + shortName + "\n" +
-				"#PBS -A " + project + "\n" +
-				"#PBS -q " + queue + "\n" +
-				"#PBS -l walltime=" + walltime + "\n" +
-				"#PBS -l nodes=1:ppn=1:gpus=2\n\n" +
-				modules +
-				"sh " + filepath.Join(currentDir, kiraOutName) + "\n"
-		} else if machine == "plx" {
-			modules = "module purge\n" +
-				"module load gnu/4.1.2\n" +
-				"module load profile/advanced\n" +
-				"module load boost/1.41.0--intel--11.1--binary\n" +
-				"module load cuda/4.0\n\n" +
-				"LD_LIBRARY_PATH=/cineca/prod/compilers/cuda/4.0/none/lib64:" +
-				"/cineca/prod/compilers/cuda/4.0/none/lib:/cineca/prod/" +
-				"libraries/boost/1.41.0/intel--11.1--binary/lib:/cineca/" +
-				"prod/compilers/intel/11.1/binary/lib/intel64\n" +
-				"export LD_LIBRARY_PATH\n\n"
-			queue = "longpar"
-			walltime = "24:00:00"
-			project = "IscrC_SCmerge"
-
-			home = "/plx/userexternal/bziosi00"
-			kiraBinPath = filepath.Join(home, "slpack", "starlab", "usr", "bin", "kira")
-			kiraString = "echo $PWD\n" +
-				"echo $LD_LIBRARY_PATH\n" +
-				"echo $HOSTNAME\n" +
-				"date\n" +
-				kiraBinPath + " -t " + infoMap["remainingTime"] + " -d 1 -D 1 -b 1 -f 0 \\\n" +
-				" -n 10 -e 0.000 -B " + randomString + " \\\n" +
-				"<  " + filepath.Join(currentDir, infoMap["newICsFileName"]) + " \\\n" +
-				">  " + filepath.Join(currentDir, stdOutFile) + " \\\n" +
-				"2> " + filepath.Join(currentDir, stdErrFile) + " \n"
-			pbsString = "#!/bin/bash\n" +
-				"#PBS -N r" + shortName + "\n" +
-				"#PBS -A " + project + "\n" +
-				"#PBS -q " + queue + "\n" +
-				"#PBS -l walltime=" + walltime + "\n" +
-				"#PBS -l select=1:ncpus=1:ngpus=2\n\n" +
-				modules +
-				"sh " + filepath.Join(currentDir, kiraOutName) + "\n"
-		} else {
-			log.Fatal("Uknown machine name ", machine)
+		stdOutFile = id.WithPrefix("out").WithExt(".txt").String()
+		stdErrFile = id.WithPrefix("err").WithExt(".txt").String()
+		kiraOutName = id.WithPrefix("kiraLaunch").WithExt(".sh").String()
+		pbsOutName = id.WithPrefix("PBS").WithExt(".sh").String()
+
+		data = ScriptData{
+			JobSpec: JobSpec{
+				Name:      "r" + shortName,
+				Project:   profile.Account,
+				Queue:     profile.Queue,
+				Walltime:  profile.Walltime,
+				Resources: profile.Resources,
+				CPUs:      profile.CPUs,
+				GPUs:      profile.GPUs,
+				Setup:     profile.Setup(),
+				Command:   "sh " + filepath.Join(currentDir, kiraOutName),
+			},
+			ID:            id,
+			Dir:           currentDir,
+			ICs:           filepath.Join(currentDir, infoMap["newICsFileName"]),
+			Out:           filepath.Join(currentDir, stdOutFile),
+			Err:           filepath.Join(currentDir, stdErrFile),
+			KiraLaunch:    filepath.Join(currentDir, kiraOutName),
+			RemainingTime: infoMap["remainingTime"],
+			Seed:          randomSeed,
+			Tidal:         as,
+			KiraWrap:      profile.KiraWrapPath(),
+			Kira:          kiraBinary,
+			KiraArgs:      strings.Join(kira.Args(infoMap["remainingTime"], randomSeed), " "),
+			ConfFile:      confFile,
+			Machine:       profile,
+		}
+		if kiraString, err = RenderScript(kiraTemplate, data); err != nil {
+			log.Fatal("Can't write the kiraLaunch script: ", err)
+		}
+		if pbsString, err = RenderScript(jobTemplate, data); err != nil {
+			log.Fatal("Can't write the job script: ", err)
 		}
 
-		if kiraFile, err = os.Create(kiraOutName); err != nil {
+		if err = plan.WriteFile(kiraOutName, kiraString, "kiraLaunch of "+infoMap["newICsFileName"]); err != nil {
 			log.Fatal(err)
 		}
-		defer kiraFile.Close()
-		fmt.Fprint(kiraFile, kiraString)
-
-		if pbsFile, err = os.Create(pbsOutName); err != nil {
+		if err = plan.WriteFile(pbsOutName, pbsString, scheduler.Name()+" job of "+infoMap["newICsFileName"]); err != nil {
 			log.Fatal(err)
 		}
-		defer pbsFile.Close()
-		fmt.Fprint(pbsFile, pbsString)
 		pbsLaunchChannel <- pbsOutName
 	}
 	// 	close(pbsLaunchChannel)
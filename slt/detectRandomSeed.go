@@ -1,11 +1,8 @@
 package slt
 
 import (
-	"bufio"
-	"compress/gzip"
+	"errors"
 	"log"
-	"os"
-	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
@@ -15,60 +12,48 @@ import (
 	"github.com/brunetto/goutils/readfile"
 )
 
+var regRandomSeed = regexp.MustCompile(`initial random seed\s*=\s*(\d+)`)
+
 // DetectRandomSeed read the initial random seed form the STDERR.
 func DetectRandomSeed(inFileName string) (randomSeed string) {
 	if Debug {
 		defer debug.TimeMe(time.Now())
 	}
 	var (
-		line          string
-		regRandomSeed = regexp.MustCompile(`initial random seed\s*=\s*(\d+)`)
-		resRandomSeed []string
-		inFile        *os.File
-		err           error
-		nReader       *bufio.Reader
-		stdErrName    string
-		fZip                           *gzip.Reader
-		ext      string = filepath.Ext(inFileName)
+		err        error
+		stdErrName string
 	)
 
 	stdErrName = "err" + strings.TrimPrefix(inFileName, "out")
 
-	// Open file & create reader
-	if inFile, err = os.Open(stdErrName); err != nil {
+	if randomSeed, err = ReadRandomSeed(stdErrName); err != nil {
 		log.Fatal(err)
 	}
-	defer inFile.Close()
+	return randomSeed
+}
 
-	switch ext {
-	case ".txt":
-		{
-			nReader = bufio.NewReader(inFile)
-		}
-	case ".gz":
-		{
-			fZip, err = gzip.NewReader(inFile)
-			if err != nil {
-				log.Fatalf("Can't open %s: error: %s\n", inFile, err)
-			}
-			nReader = bufio.NewReader(fZip)
-		}
-	default:
-		{
-			log.Println("Unrecognized file type", inFileName+".bck")
-			log.Fatal("with extension ", ext)
-		}
+// ReadRandomSeed reads the initial random seed from the STDERR stdErrName.
+func ReadRandomSeed(stdErrName string) (string, error) {
+	var (
+		line          string
+		resRandomSeed []string
+		inFile        *StdReader
+		err           error
+	)
+
+	// Open file & create reader, compressed or not
+	if inFile, err = OpenStd(stdErrName); err != nil {
+		return "", err
 	}
+	defer inFile.Close()
 	
 	for {
-		if line, err = readfile.Readln(nReader); err != nil {
-			log.Fatal("STDERR interrupted before the random seed was found!!!")
+		if line, err = readfile.Readln(inFile.Reader); err != nil {
+			return "", errors.New("STDERR interrupted before the random seed was found!!!")
 		}
 		// Search for timestep number
 		if resRandomSeed = regRandomSeed.FindStringSubmatch(line); resRandomSeed != nil {
-			randomSeed = resRandomSeed[1]
-			break
+			return resRandomSeed[1], nil
 		}
 	}
-	return randomSeed
 }
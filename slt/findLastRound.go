@@ -3,7 +3,6 @@ package slt
 import (
 	"errors"
 	"log"
-	"path/filepath"
 	"sort"
 	"time"
 	
@@ -12,8 +11,16 @@ import (
 
 
 // FindLastRound gives you the last round ics, err and out 
-// for each run in a folder 
-func FindLastRound (globName string) (keys []string, runMap map[string]map[string][]string, err error) {
+// for each run in the current folder.
+// Files are searched accordingly to the naming scheme and the extension
+// (that can be a pattern, like ".*").
+func FindLastRound (ext string) (keys []string, runMap map[string]map[string][]string, err error) {
+	return FindLastRoundIn(".", ext)
+}
+
+// FindLastRoundIn is FindLastRound for the runs in folder, the file
+// names in runMap include the folder.
+func FindLastRoundIn(folder, ext string) (keys []string, runMap map[string]map[string][]string, err error) {
 	if Debug {
 		defer debug.TimeMe(time.Now())
 	}
@@ -22,7 +29,7 @@ func FindLastRound (globName string) (keys []string, runMap map[string]map[strin
 		fileName string
 		inFiles      []string		
 		exists bool
-		regRes                         map[string]string
+		id RunID
 	)
 	
 	// Init runMap
@@ -31,25 +38,25 @@ func FindLastRound (globName string) (keys []string, runMap map[string]map[strin
 	// will give ["err-....run08-rnd03.txt"]
 
 	
-	if inFiles, err = filepath.Glob(globName); err != nil {
-		log.Fatal("Error globbing files in this folder: ", err)
+	if inFiles, err = Naming.GlobFiles(folder, "*", ext); err != nil {
+		log.Fatal("Error globbing files in ", folder, ": ", err)
 	}
 		
 	for _, fileName = range inFiles {
 		// Try to detect file parameters (type, run, rnd) from fileName
-		regRes, err = Reg(fileName)
+		id, err = ParseRunID(fileName)
 		// Not standard name
 		if err != nil {log.Fatal("Can't find proper name to regex in ", fileName)}
 		// Check if run is present, if not, create it in the map
-		if _, exists = runMap[regRes["run"]]; !exists {
-			runMap[regRes["run"]] = map[string][]string{
+		if _, exists = runMap[id.RunStr()]; !exists {
+			runMap[id.RunStr()] = map[string][]string{
 				"ics": []string{},
 				"err": []string{},
 				"out": []string{},
 			}
 		}
 		// Fill the map entry with the fileName
-		runMap[regRes["run"]][regRes["prefix"]] = append(runMap[regRes["run"]][regRes["prefix"]], fileName)
+		runMap[id.RunStr()][id.Prefix] = append(runMap[id.RunStr()][id.Prefix], fileName)
 	}
 	
 	// Now runMap contains all the fileName 
@@ -0,0 +1,268 @@
+package slt
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"text/template"
+)
+
+// PipelineStage is a program of the ICs pipeline, its STDOUT goes to the
+// STDIN of the next one. The arguments are text/templates receiving a
+// ScriptData, i.e. "{{.Conf.NcmStr}}". They are set in the ICsPipeline
+// field of the configuration:
+//
+//	"ICsPipeline": [
+//		{"Name": "makeking", "Args": ["-n", "{{.Conf.NcmStr}}", "-w", "{{.Conf.WStr}}", "-i", "-u"], "SeedFlag": "-s"},
+//		...
+//	]
+type PipelineStage struct {
+	Name string
+	// Binary is the program, Name if empty. Environment variables are expanded.
+	Binary string
+	Args   []string
+	// SeedFlag is the flag taking the random seed, i.e. "-s", empty if the
+	// program draws no random numbers. With a master seed in the
+	// configuration the stage gets its derived seed (see RunSeeds).
+	SeedFlag string
+}
+
+// stderrTail is how much of the STDERR of each stage is kept for the errors.
+const stderrTail = 4096
+
+// DefaultICsPipeline is the chain of the StarLab tools of ICsTemplate.
+func DefaultICsPipeline() []PipelineStage {
+	return []PipelineStage{
+		{Name: "makeking", Args: []string{"-n", "{{.Conf.NcmStr}}", "-w", "{{.Conf.WStr}}", "-i", "-u"}, SeedFlag: "-s"},
+		{Name: "makemass", Args: []string{"-f", "8", "-l", "0.1", "-u", "150"}, SeedFlag: "-s"},
+		{Name: "makesecondary", Args: []string{"-f", "{{.Conf.FpbStr}}", "-q", "-l", "0.1"}, SeedFlag: "-s"},
+		{Name: "add_star", Args: []string{"-R", "{{.Conf.RvStr}}", "-Z", "{{.Conf.ZStr}}"}},
+		{Name: "scale", Args: []string{"-R", "1", "-M", "1"}},
+		{Name: "makebinary", Args: []string{"-f", "2", "-o", "1", "-l", "1", "-u", "107836.09"}, SeedFlag: "-s"},
+	}
+}
+
+// StageError is the failure of a stage of the pipeline.
+type StageError struct {
+	Stage    string
+	ExitCode int
+	// Stderr is the end of the STDERR of the stage.
+	Stderr string
+	Err    error
+}
+
+func (e *StageError) Error() string {
+	msg := fmt.Sprintf("stage %v: %v", e.Stage, e.Err)
+	if e.ExitCode > 0 {
+		msg = fmt.Sprintf("stage %v exited with code %v", e.Stage, e.ExitCode)
+	}
+	if e.Stderr != "" {
+		msg += ": " + strings.TrimSpace(e.Stderr)
+	}
+	return msg
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// tailWriter keeps the last size bytes written to it.
+type tailWriter struct {
+	mutex sync.Mutex
+	size  int
+	buf   []byte
+}
+
+func (tail *tailWriter) Write(p []byte) (int, error) {
+	tail.mutex.Lock()
+	defer tail.mutex.Unlock()
+	tail.buf = append(tail.buf, p...)
+	if len(tail.buf) > tail.size {
+		tail.buf = tail.buf[len(tail.buf)-tail.size:]
+	}
+	return len(p), nil
+}
+
+func (tail *tailWriter) String() string {
+	tail.mutex.Lock()
+	defer tail.mutex.Unlock()
+	return string(tail.buf)
+}
+
+// lockedWriter lets the stages share the log file.
+type lockedWriter struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+func (locked *lockedWriter) Write(p []byte) (int, error) {
+	locked.mutex.Lock()
+	defer locked.mutex.Unlock()
+	return locked.w.Write(p)
+}
+
+// RenderStages fills the arguments of the stages with data and appends
+// the seeds of data.ICsSeeds.
+func RenderStages(stages []PipelineStage, data ScriptData) ([]PipelineStage, error) {
+	var (
+		rendered = make([]PipelineStage, len(stages))
+		tmpl     *template.Template
+		arg      string
+		err      error
+	)
+	for idx, stage := range stages {
+		if stage.Name == "" {
+			return nil, fmt.Errorf("stage %v without name", idx)
+		}
+		rendered[idx] = PipelineStage{Name: stage.Name, Binary: stage.Binary, Args: make([]string, len(stage.Args)), SeedFlag: stage.SeedFlag}
+		if rendered[idx].Binary == "" {
+			rendered[idx].Binary = stage.Name
+		}
+		rendered[idx].Binary = os.ExpandEnv(rendered[idx].Binary)
+		for argIdx, argTmpl := range stage.Args {
+			if tmpl, err = template.New(stage.Name).Parse(argTmpl); err != nil {
+				return nil, fmt.Errorf("stage %v: %v", stage.Name, err)
+			}
+			if arg, err = RenderScript(tmpl, data); err != nil {
+				return nil, fmt.Errorf("stage %v: %v", stage.Name, err)
+			}
+			rendered[idx].Args[argIdx] = arg
+		}
+		if seed := data.ICsSeeds[stage.Name]; stage.SeedFlag != "" && seed != "" {
+			rendered[idx].Args = append(rendered[idx].Args, stage.SeedFlag, seed)
+		}
+	}
+	return rendered, nil
+}
+
+// PipelineScript writes the stages as a bash pipeline, the ICs script
+// when the configuration has its own pipeline.
+func PipelineScript(stages []PipelineStage, outName string) string {
+	var lines = []string{}
+	for _, stage := range stages {
+		lines = append(lines, strings.Join(append([]string{stage.Binary}, stage.Args...), " "))
+	}
+	return "#!/bin/bash\nset -xeu\n" + strings.Join(lines, " \\\n| ") + " \\\n> " + outName + "\n"
+}
+
+// RunPipeline runs the stages concurrently, piping each one into the next,
+// and writes the output of the last one to outName. The STDERR of the
+// stages goes to logFile. If a stage fails the others are killed, the
+// partial outName is removed and a *StageError is returned.
+func RunPipeline(stages []PipelineStage, outName string, logFile io.Writer) error {
+	var (
+		cmds    = make([]*exec.Cmd, len(stages))
+		tails   = make([]*tailWriter, len(stages))
+		logW    = &lockedWriter{w: logFile}
+		outFile *os.File
+		reader  *os.File
+		writer  *os.File
+		errs    = make(chan error, len(stages))
+		failed  error
+		err     error
+	)
+	if len(stages) == 0 {
+		return fmt.Errorf("empty pipeline")
+	}
+	if outFile, err = os.Create(outName); err != nil {
+		return err
+	}
+
+	for idx, stage := range stages {
+		binary := stage.Binary
+		if binary == "" {
+			binary = stage.Name
+		}
+		cmds[idx] = exec.Command(binary, stage.Args...)
+		tails[idx] = &tailWriter{size: stderrTail}
+		cmds[idx].Stderr = io.MultiWriter(logW, tails[idx])
+	}
+	cmds[len(cmds)-1].Stdout = outFile
+
+	// Start every stage before waiting for any of them, a stage blocked on a
+	// full pipe is only freed by the next one reading.
+	for idx, cmd := range cmds {
+		writer = nil
+		if idx < len(cmds)-1 {
+			if reader, writer, err = os.Pipe(); err != nil {
+				failed = err
+				writer = nil
+			} else {
+				cmd.Stdout = writer
+				cmds[idx+1].Stdin = reader
+			}
+		}
+		if failed == nil {
+			if err = cmd.Start(); err != nil {
+				failed = &StageError{Stage: stages[idx].Name, Err: err}
+			}
+		}
+		// The children have their copies, without closing ours a failing
+		// stage would leave its neighbours waiting forever
+		if cmd.Stdin != nil {
+			cmd.Stdin.(*os.File).Close()
+		}
+		if writer != nil {
+			writer.Close()
+		}
+		if failed != nil {
+			if writer != nil {
+				reader.Close()
+			}
+			for _, started := range cmds[:idx] {
+				started.Process.Kill()
+			}
+			break
+		}
+	}
+
+	for idx, cmd := range cmds {
+		if cmd.Process == nil {
+			continue
+		}
+		go func(idx int, cmd *exec.Cmd) {
+			if err := cmd.Wait(); err != nil {
+				stageErr := &StageError{Stage: stages[idx].Name, Err: err, Stderr: tails[idx].String()}
+				if exitErr, ok := err.(*exec.ExitError); ok {
+					if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Exited() {
+						stageErr.ExitCode = status.ExitStatus()
+					}
+				}
+				errs <- stageErr
+				return
+			}
+			errs <- nil
+		}(idx, cmd)
+	}
+	for _, cmd := range cmds {
+		if cmd.Process == nil {
+			continue
+		}
+		if err = <-errs; err != nil && failed == nil {
+			failed = err
+			// The first failure is the interesting one, the others
+			// are usually broken pipes
+			for _, other := range cmds {
+				if other.Process != nil {
+					other.Process.Kill()
+				}
+			}
+		}
+	}
+
+	if err = outFile.Close(); err != nil && failed == nil {
+		failed = err
+	}
+	if failed != nil {
+		if err = os.Remove(outName); err != nil {
+			log.Println("Can't remove the partial ", outName, ": ", err)
+		}
+		return failed
+	}
+	return nil
+}
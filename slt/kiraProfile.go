@@ -0,0 +1,143 @@
+package slt
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// KiraProfile is how kira is run: binary and arguments. It is the Kira
+// field of the configuration file, the missing fields keep the defaults:
+//
+//	"Kira": {
+//		"Binary": "$HOME/bin/kira",
+//		"LogInterval": 1,
+//		"SnapInterval": 1,
+//		"Friction": 0,
+//		"Softening": 0,
+//		"MinParticles": 10,
+//		"NoBinaries": false,
+//		"Tidal": ""
+//	}
+type KiraProfile struct {
+	// Binary is the kira executable, environment expanded. If empty it is
+	// $HOME/bin/kira, kira-no-GPU, kiraTF or kira-AS depending on NoGPU and Tidal.
+	// If set, the start scripts run it directly instead of kiraWrap.
+	Binary string
+	// LogInterval (-d) and SnapInterval (-D) are in N-body units.
+	LogInterval  float64
+	SnapInterval float64
+	// Friction is the dynamical friction (-f), 0 for none.
+	Friction float64
+	// Softening is -e.
+	Softening float64
+	// MinParticles stops kira when the cluster has fewer particles (-n).
+	MinParticles int
+	// NoBinaries switches off the binary evolution (-S instead of -b 1 -B).
+	NoBinaries bool
+	// Tidal is the tidal field model: "" for none, "tf" or "as" (Allen-Santillan).
+	Tidal string
+	// NoGPU runs kira without the GPU.
+	NoGPU bool
+}
+
+// DefaultKiraProfile returns the arguments sltools has always used.
+func DefaultKiraProfile() *KiraProfile {
+	return &KiraProfile{
+		LogInterval:  1,
+		SnapInterval: 1,
+		MinParticles: 10,
+	}
+}
+
+// LoadKiraProfile reads the Kira field of the configuration file over
+// the defaults, just the defaults if confName is empty.
+func LoadKiraProfile(confName string) (*KiraProfile, error) {
+	var (
+		confFile []byte
+		conf     = struct{ Kira *KiraProfile }{DefaultKiraProfile()}
+		err      error
+	)
+	if confName != "" {
+		if confFile, err = ioutil.ReadFile(confName); err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(confFile, &conf); err != nil {
+			return nil, fmt.Errorf("parse config: %v", err)
+		}
+		// "Kira": null
+		if conf.Kira == nil {
+			conf.Kira = DefaultKiraProfile()
+		}
+	}
+	return conf.Kira, conf.Kira.Validate()
+}
+
+// Validate checks the profile.
+func (profile *KiraProfile) Validate() error {
+	switch profile.Tidal {
+	case "", "tf", "as":
+	default:
+		return fmt.Errorf("unknown tidal model %v, use tf or as", profile.Tidal)
+	}
+	if profile.LogInterval <= 0 || profile.SnapInterval <= 0 {
+		return fmt.Errorf("kira log and snapshot intervals must be positive")
+	}
+	if profile.Friction < 0 || profile.Softening < 0 || profile.MinParticles < 0 {
+		return fmt.Errorf("negative kira friction, softening or minimum particles")
+	}
+	return nil
+}
+
+// BinaryPath returns the kira executable to run.
+func (profile *KiraProfile) BinaryPath() string {
+	var name = "kira"
+	if profile.Binary != "" {
+		return os.ExpandEnv(profile.Binary)
+	}
+	switch {
+	case profile.NoGPU:
+		name = "kira-no-GPU"
+	case profile.Tidal == "tf":
+		name = "kiraTF"
+	case profile.Tidal == "as":
+		name = "kira-AS"
+	}
+	return filepath.Join(os.Getenv("HOME"), "bin", name)
+}
+
+// Args returns the kira arguments to integrate timesteps timesteps,
+// the random seed is left to kira if empty.
+func (profile *KiraProfile) Args(timesteps, seed string) []string {
+	var (
+		format = func(value float64) string { return strconv.FormatFloat(value, 'f', -1, 64) }
+		args   = []string{
+			"-t", timesteps, // number of timesteps to compute
+			"-d", format(profile.LogInterval), // log output interval
+			"-D", format(profile.SnapInterval), // snapshot interval
+			"-f", format(profile.Friction), // dynamical friction (0 = no friction, 1 = friction)
+			"-n", strconv.Itoa(profile.MinParticles), // terminate if the cluster remains with only n particles
+			"-e", format(profile.Softening), // softening
+		}
+	)
+	if profile.NoBinaries {
+		args = append(args, "-S")
+	} else {
+		// frequency of full binary output and binary evolution
+		args = append(args, "-b", "1", "-B")
+	}
+	if seed != "" {
+		args = append(args, "-s", seed)
+	}
+	return args
+}
+
+// CommandLine returns the shell line running kira on icsName.
+func (profile *KiraProfile) CommandLine(timesteps, seed, icsName, outName, errName string) string {
+	return profile.BinaryPath() + " " + strings.Join(profile.Args(timesteps, seed), " ") +
+		" < " + icsName + " > " + outName + " 2> " + errName
+}
@@ -6,8 +6,11 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"os/user"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 		
@@ -21,12 +24,27 @@ func KiraWrap(icsFileName, intTime, randomNumber string, noGPU bool) () {
 		timeLimit string
 		err error
 		kiraString string
-		kiraArgs []string
+		profile *KiraProfile
 		kiraWrappedCmd *exec.Cmd
 		pathName, icsName, outName, errName, ext string
-		icsFile, outFile, errFile *os.File
-		regRes map[string]string
-		done = make(chan string, 1)
+		icsFile *StdReader
+		outFile, errFile *os.File
+		id RunID
+		named bool
+		done chan string
+		stop chan struct{}
+		reason string
+		launchArgs []string
+		stdinName string
+		icsStart, endTimestep int64
+		rst *kiraRestart
+		restarts int
+		signals = make(chan os.Signal, 1)
+		started = time.Now()
+		deadline time.Time
+		hasDeadline bool
+		record *ExitRecord
+		telemetry *TelemetryWriter
 		randomSeed string = ""
 		u  *user.User
 		host, wd string
@@ -41,20 +59,28 @@ func KiraWrap(icsFileName, intTime, randomNumber string, noGPU bool) () {
 	
 	// Extract fileNameBody, round and ext
 	log.Println("Extract files names")
-	ext = filepath.Ext(icsName)
-	regRes, err = Reg(icsName)
-	if err != nil {
+	// kira writes plain text, drop the compression extension of the ICs
+	ext = filepath.Ext(TrimCodecExt(icsName))
+	if id, err = ParseRunID(TrimCodecExt(icsName)); err != nil {
 		log.Println("Can't derive standard names from STDOUT => wrap it!!")
-		errName = "err-" + icsName + ext
-		outName = "out-" + icsName + ext
+		errName = "err-" + TrimCodecExt(icsName) + ext
+		outName = "out-" + TrimCodecExt(icsName) + ext
 	} else {
-		if regRes["prefix"] != "ics" {
-			log.Fatalf("Please specify a STDIN file, found %v prefix", regRes["prefix"])
+		named = true
+		if id.Prefix != "ics" {
+			log.Fatalf("Please specify a STDIN file, found %v prefix", id.Prefix)
 		}
 		
+		// The first round of a realization has a reproducible seed
+		if randomSeed == "" {
+			if randomSeed = FirstKiraSeed(ConfName, id); randomSeed != "" {
+				log.Println("Derived kira seed ", randomSeed, " from the master seed")
+			}
+		}
+
 		// Creating new filenames
-		errName = "err-" + regRes["baseName"] + "-run" + regRes["run"] + "-rnd" + regRes["rnd"] + ext
-		outName = "out-" + regRes["baseName"] + "-run" + regRes["run"] + "-rnd" + regRes["rnd"] + ext
+		errName = id.WithPrefix("err").String()
+		outName = id.WithPrefix("out").String()
 	}
 	
 	if icsName, err = filepath.Abs(filepath.Join(pathName, icsName)); err != nil {
@@ -67,69 +93,42 @@ func KiraWrap(icsFileName, intTime, randomNumber string, noGPU bool) () {
 		log.Fatal("Error composing outName: ", err)
 	}
 	
-	if icsFile, err = os.Open(icsName); err != nil {log.Fatal(err)}
-	defer icsFile.Close()
-	if outFile, err = os.Create(outName); err != nil {log.Fatal(err)}
-	defer outFile.Close()
-	if errFile, err = os.Create(errName); err != nil {log.Fatal(err)}
-	defer errFile.Close()
-	
-	errFile.WriteString("\n#==============================\n")	
-	errFile.WriteString(fmt.Sprintf("\n#   %v Start with kiraWrap.\n", time.Now().Format(time.RFC850)))
-	errFile.WriteString("\n#==============================\n")	
-	
-	outFile.WriteString("\n#==============================\n")	
-	outFile.WriteString(fmt.Sprintf("\n#   %v Start with kiraWrap.\n", time.Now().Format(time.RFC850)))
-	outFile.WriteString("\n#==============================\n")	
-		
-	
-	kiraArgs =  []string{"-t", timeLimit,// +  // number of timesteps to compute
-		"-d", "1",// +  // log output interval
-		"-D", "1",// +  // snapshot interval
-		// 				"-b", "1",// +  // frequency of full binary output
-		"-f", "0",// +  // dynamical friction (0 = no friction, 1 = friction)
-		"-n", "10",// +  // terminate if the cluster remains with only 10 particles
-		"-e", "0.000",// + // softening 
-		// 				"-B",// // switch on binary evolution
-		//"-s 36543" // random seed 
+	if KiraSupervise {
+		// The restarts need to know where the round ends
+		if icsStart, err = icsStartTimestep(icsName); err != nil {
+			log.Fatal("Can't read the ICs timestep for the supervisor: ", err)
+		}
+		if endTimestep, err = strconv.ParseInt(timeLimit, 10, 64); err != nil {
+			log.Fatal("Supervisor needs an integer number of timesteps: ", err)
+		}
+		endTimestep += icsStart
 	}
 	
+	// The configuration gives the kira profile, the old flags still work
+	if profile, err = LoadKiraProfile(ConfName); err != nil {
+		log.Fatal("Kira profile: ", err)
+	}
 	if noGPU {
 		log.Println("Selected the no GPU integration.")
-		log.Println("Assuming kira is in $HOME/bin/kira-no-GPU, if not, please copy it there... for sake of simplicity!:P")
-		kiraString = filepath.Join(os.Getenv("HOME"), "/bin/", "kira-no-GPU")
+		profile.NoGPU = true
 	} else if tf {
 		log.Println("Selected TF versionn.")
-		log.Println("Assuming kira is in $HOME/bin/kiraTF, if not, please copy it there... for sake of simplicity!:P")
-		kiraString = filepath.Join(os.Getenv("HOME"), "/bin/", "kiraTF")
-// 		kiraArgs = append(kiraArgs, "-F") // F now means dynamical friction
+		profile.Tidal = "tf"
 	} else if as {
-		log.Println("Selected TF versionn.")
-		log.Println("Assuming kira is in $HOME/bin/kira-AS, if not, please copy it there... for sake of simplicity!:P")
-		kiraString = filepath.Join(os.Getenv("HOME"), "/bin/", "kira-AS")
-	} else {
-		log.Println("Assuming kira is in $HOME/bin/kira, if not, please copy it there... for sake of simplicity!:P")
-		kiraString = filepath.Join(os.Getenv("HOME"), "/bin/", "kira")
+		log.Println("Selected AS versionn.")
+		profile.Tidal = "as"
 	}
-	
-	if !noBinaries {
-		log.Println("Binary evolution on, adding flags to kira")
-		kiraArgs = append(kiraArgs, "-b", "1", "-B")
+	if noBinaries {
+		profile.NoBinaries = true
+	}
+	kiraString = profile.BinaryPath()
+	log.Println("Assuming kira is ", kiraString, ", if not, please copy it there... for sake of simplicity!:P")
+	if !profile.NoBinaries {
+		log.Println("Binary evolution on")
 	} else {
-		kiraArgs = append(kiraArgs, "-S")
 		log.Println("Binary evolution off")
 	}
 	
-	// Add the random seed if specified
-	if randomSeed != "" {
-		kiraArgs = append(kiraArgs, "-s", randomSeed)
-	}
-	
-	kiraWrappedCmd = exec.Command(kiraString, kiraArgs...)
-	if kiraWrappedCmd.Stdin = icsFile; err != nil {log.Fatal("Error connecting ICs to kira STDIN: ", err)}
-	if kiraWrappedCmd.Stdout = outFile; err != nil {log.Fatal("Error connecting ICs to kira STDOUT: ", err)}
-	if kiraWrappedCmd.Stderr = errFile; err != nil {log.Fatal("Error connecting ICs to kira STDERR: ", err)}
-	
 	if u, err = user.Current(); err != nil {
 		log.Fatal("Can't retrieve username: ", err)
 	}
@@ -140,34 +139,186 @@ func KiraWrap(icsFileName, intTime, randomNumber string, noGPU bool) () {
 		log.Fatal("Can't retrieve working dir: ", err)
 	}
 	
-	log.Println("Run summary:")
-	
-	log.Printf("Username: %v (%v)\n", u.Username, u.Name)
-	log.Println("Hostname: ", host)
-	log.Println("Working dir: ", wd)
-	log.Println("LD_LIBRARY_PATH: ", os.Getenv("LD_LIBRARY_PATH"))
-	
-	log.Println("Command: ", kiraString, kiraArgs)
-	log.Println("STDIN = ", icsName)
-	log.Println("STDOUT = ", outName)
-	log.Println("STDERR = ", errName)
+	// Stop before the scheduler kills us and pass its signals to kira
+	if deadline, hasDeadline = JobDeadline(started); hasDeadline {
+		log.Printf("Walltime ends at %v, kira will be stopped %v minutes before\n", deadline.Format(time.RFC850), KiraWalltimeMargin)
+	}
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGUSR1)
+	defer signal.Stop(signals)
 	
-	log.Println("Ready... steady... Go!")
+	// Keep what killTrigger sees for the post mortem
+	if telemetry, err = NewTelemetryWriter(TelemetryName(outName)); err != nil {
+		log.Println("Can't write the telemetry: ", err)
+	}
+	defer telemetry.Close()
 	
-	if err = kiraWrappedCmd.Start(); err != nil {
-		log.Fatal("Error starting kiraWrappedCmd: ", err)
+	stdinName = icsName
+	for restart := 0; ; restart++ {
+		restarts = restart
+		// kira can't read compressed ICs, feed it through the decompressor
+		if icsFile, err = OpenStd(stdinName); err != nil {log.Fatal(err)}
+		if outFile, errFile, err = openKiraStd(outName, errName, restart > 0); err != nil {log.Fatal(err)}
+		
+		if restart == 0 {
+			errFile.WriteString("\n#==============================\n")	
+			errFile.WriteString(fmt.Sprintf("\n#   %v Start with kiraWrap.\n", time.Now().Format(time.RFC850)))
+			errFile.WriteString("\n#==============================\n")	
+			
+			outFile.WriteString("\n#==============================\n")	
+			outFile.WriteString(fmt.Sprintf("\n#   %v Start with kiraWrap.\n", time.Now().Format(time.RFC850)))
+			outFile.WriteString("\n#==============================\n")	
+		} else {
+			errFile.WriteString("\n#==============================\n")	
+			errFile.WriteString(fmt.Sprintf("\n#   %v Restart %v/%v from timestep %v with %v: %v\n", 
+				time.Now().Format(time.RFC850), restart, KiraMaxRestarts, rst.Timestep, filepath.Base(rst.ICs), reason))
+			errFile.WriteString("\n#==============================\n")	
+		}
+		
+		// The random seed is added if specified
+		launchArgs = profile.Args(timeLimit, randomSeed)
+		
+		kiraWrappedCmd = exec.Command(kiraString, launchArgs...)
+		if kiraWrappedCmd.Stdin = icsFile.Reader; err != nil {log.Fatal("Error connecting ICs to kira STDIN: ", err)}
+		if kiraWrappedCmd.Stdout = outFile; err != nil {log.Fatal("Error connecting ICs to kira STDOUT: ", err)}
+		if kiraWrappedCmd.Stderr = errFile; err != nil {log.Fatal("Error connecting ICs to kira STDERR: ", err)}
+		
+		log.Println("Run summary:")
+		
+		log.Printf("Username: %v (%v)\n", u.Username, u.Name)
+		log.Println("Hostname: ", host)
+		log.Println("Working dir: ", wd)
+		log.Println("LD_LIBRARY_PATH: ", os.Getenv("LD_LIBRARY_PATH"))
+		
+		log.Println("Command: ", kiraString, launchArgs)
+		log.Println("STDIN = ", stdinName)
+		log.Println("STDOUT = ", outName)
+		log.Println("STDERR = ", errName)
+		
+		log.Println("Ready... steady... Go!")
+		
+		if err = kiraWrappedCmd.Start(); err != nil {
+			log.Fatal("Error starting kiraWrappedCmd: ", err)
+		}
+		
+		if named {
+			if err = UpdateState(pathName, func(db *StateDB) error {
+				round := db.Round(id)
+				if randomSeed != "" {
+					round.RandomSeed = randomSeed
+				}
+				if restart > 0 {
+					round.Restarts++
+					return nil
+				}
+				round.ICs = filepath.Base(icsName)
+				round.Out = filepath.Base(outName)
+				round.Err = filepath.Base(errName)
+				round.Restarts = 0
+				round.Started = time.Now()
+				round.Ended = time.Time{}
+				round.ExitReason = ""
+				return nil
+			}); err != nil {
+				log.Println("Can't update the state: ", err)
+			}
+		}
+		
+		log.Println("Waiting kira to finish while checking for problems...")
+		// One for the kill, one for the signals, one for the end of the process
+		done = make(chan string, 3)
+		stop = make(chan struct{})
+		// Wait for the process to end normally
+		go waitProcess(kiraWrappedCmd, done)
+		// Check for pp3-stalling situations
+		go killTrigger(outName, errName, kiraWrappedCmd, telemetry, done, stop)	
+		// Stop it on signals and before the walltime
+		go forwardSignals(kiraWrappedCmd, signals, deadline, hasDeadline, done, stop)
+		
+		if reason = <-done; reason != "" {
+			// Don't touch the files while kira is still writing
+			<-done
+		} else {
+			// The scheduler signals kira too, it can end before we know why
+			select {
+			case reason = <-done:
+			default:
+			}
+		}
+		if reason != "" {
+			errFile.WriteString("\n"+reason+"\n")
+		}
+		close(stop)
+		icsFile.Close()
+		outFile.Close()
+		errFile.Close()
+		
+		if !KiraSupervise || !strings.HasPrefix(reason, "killed because " + stallReason) {
+			break
+		}
+		if restart >= KiraMaxRestarts {
+			log.Println("No restarts left out of ", KiraMaxRestarts)
+			reason += ", no restarts left"
+			break
+		}
+		if randomSeed == "" {
+			if randomSeed, err = ReadRandomSeed(errName); err != nil {
+				log.Println("Can't restart without the random seed: ", err)
+				break
+			}
+		}
+		if rst, err = prepareRestart(icsName, outName, errName, endTimestep, KiraCutBack, restart+1); err != nil {
+			log.Println("Can't restart: ", err)
+			reason += ", restart failed: " + err.Error()
+			break
+		}
+		timeLimit = rst.Time
+		stdinName = rst.ICs
+		log.Printf("Restart %v/%v from timestep %v with %v\n", restart+1, KiraMaxRestarts, rst.Timestep, rst.ICs)
 	}
 	
-	log.Println("Waiting kira to finish while checking for problems...")
-	// Wait for the process to end normally
-	go waitProcess(kiraWrappedCmd, done)
-	// Check for pp3-stalling situations
-	go killTrigger(errName, kiraWrappedCmd, done)	
+	if reason == "" {
+		reason = "kira exited"
+	}
+	// Tell out2ics where to resume
+	record = &ExitRecord{
+		Reason:     reason,
+		RandomSeed: randomSeed,
+		Restarts:   restarts,
+		Started:    started,
+		Ended:      time.Now(),
+	}
+	if strings.HasPrefix(reason, "stopped because received ") {
+		record.Signal = strings.TrimPrefix(reason, "stopped because received ")
+	}
+	record.WallTime = record.Ended.Sub(started).String()
+	if record.LastTimestep, err = lastCompleteTimestep(outName); err != nil {
+		log.Println("Can't find the last complete timestep: ", err)
+	}
+	if record.RandomSeed == "" {
+		record.RandomSeed, _ = ReadRandomSeed(errName)
+	}
+	if err = WriteExitRecord(ExitRecordName(outName), record); err != nil {
+		log.Println("Can't write the exit record: ", err)
+	}
 	
-	if err = errors.New(<-done); err.Error() != "" {
-		errFile.WriteString("\n"+err.Error()+"\n")
+	if named {
+		if err = UpdateState(pathName, func(db *StateDB) error {
+			round := db.Round(id)
+			round.Ended = record.Ended
+			round.ExitReason = reason
+			if record.LastTimestep != "" {
+				round.EndTimestep = record.LastTimestep
+			}
+			return nil
+		}); err != nil {
+			log.Println("Can't update the state: ", err)
+		}
 	}
 	
+	if outFile, errFile, err = openKiraStd(outName, errName, true); err != nil {log.Fatal(err)}
+	defer outFile.Close()
+	defer errFile.Close()
+	
 	errFile.WriteString("\n#==============================\n")
 	errFile.WriteString(fmt.Sprintf("\n#   %v Done with kiraWrap.\n", time.Now().Format(time.RFC850)))	
 	errFile.WriteString(fmt.Sprintf("\n#   Username: %v (%V)\n", u.Username, u.Name))
@@ -181,64 +332,126 @@ func KiraWrap(icsFileName, intTime, randomNumber string, noGPU bool) () {
 	fmt.Print("\x07") // Beep when finish!!:D
 }
 
-func killTrigger(errName string, kiraWrappedCmd *exec.Cmd, done chan string) () {
+// openKiraStd creates the STDOUT and STDERR of kira, or opens them
+// to append after a restart.
+func openKiraStd(outName, errName string, appendMode bool) (outFile, errFile *os.File, err error) {
+	var flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appendMode {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	if outFile, err = os.OpenFile(outName, flag, 0666); err != nil {
+		return nil, nil, err
+	}
+	if errFile, err = os.OpenFile(errName, flag, 0666); err != nil {
+		outFile.Close()
+		return nil, nil, err
+	}
+	return outFile, errFile, nil
+}
+
+// killTrigger kills kira if it stalls or the machine is running out of
+// resources. It returns without doing anything once stop is closed.
+func killTrigger(outName, errName string, kiraWrappedCmd *exec.Cmd, telemetry *TelemetryWriter, done chan string, stop chan struct{}) () {
 	const toGB = float64(1. / (1024*1024*1024))
-	const maxStderrGB = float64(2)
-	const minDiskGB = float64(5)
-	const maxMemPerCent = float64(98)
 	
 	var (
 		fileInfo os.FileInfo
 		sysInfo *sysinfo.SI
-		memAvail float64
+		memUsed float64
 		diskAvailGB float64 
 		wd string 
-		reason string
+		reason, stalled string
+		detector = newStallDetector(outName, errName, &Limits)
+		sample *TelemetrySample
 		err error
 	)
 	
+	// This goroutine must not exit kiraWrap, that would skip the footer
+	// and the exit record: errors are only logged.
 	if wd, err = os.Getwd(); err != nil {
-		log.Fatal("Can't retrieve local dir: ", err)
+		log.Println("Can't retrieve local dir: ", err)
+		wd = "."
 	}
 	
 	fsInfo := syscall.Statfs_t{}
 	
 	for {
+		sample = &TelemetrySample{Time: time.Now()}
+		
 		// Check STDERR file size
 		if fileInfo, err = os.Stat(errName); err != nil {
-			log.Fatal("Error checking STDERR file size, err")
+			log.Println("Error checking STDERR file size: ", err)
+		} else {
+			sample.StderrGB = float64(fileInfo.Size()) * toGB
 		}
-		// STDERR exceesing aloowed dimension of 2GB
+		// STDERR exceesing aloowed dimension
 		// probably the simulation is stalling because 
 		// of pp3 locked on a binary
-		if float64(fileInfo.Size()) * toGB > maxStderrGB {
-			reason = "probable pp3 stalling"
-			break
+		if sample.StderrGB > Limits.MaxStderrGB {
+			reason = fmt.Sprintf("%v: STDERR larger than %v GB", stallReason, Limits.MaxStderrGB)
 		} 
 		
+		// Check the simulated time is advancing
+		if stalled, err = detector.Check(sample.Time); err != nil {
+			log.Println("Can't follow kira output: ", err)
+		} else if reason == "" {
+			reason = stalled
+		}
+		sample.SimTime = detector.simTime
+		sample.StdoutMBPerMin, sample.StderrMBPerMin = detector.outGrowth, detector.errGrowth
+		
 		// Check memory availability
-		sysInfo = sysinfo.Get()
-		memAvail = float64(sysInfo.FreeRam) / float64(sysInfo.TotalRam) * 100
-		if memAvail > maxMemPerCent {
-			reason = fmt.Sprintf("memory less than 95%% on the system: %2.2f",  memAvail)
-			break
+		if memUsed, err = memUsedPerCent(); err != nil {
+			// FreeRam doesn't count the page cache, so it is only a fallback
+			sysInfo = sysinfo.Get()
+			memUsed = 100 - float64(sysInfo.FreeRam) / float64(sysInfo.TotalRam) * 100
+		}
+		sample.MemUsedPerCent = memUsed
+		if memUsed > Limits.MaxMemPerCent && reason == "" {
+			reason = fmt.Sprintf("memory used more than %v%% on the system: %2.2f", Limits.MaxMemPerCent, memUsed)
 		}
 		
 		// Check HDD space availability
 		if err = syscall.Statfs(wd, &fsInfo); err!= nil{
-			log.Fatal("Cant't retrieve file system information: ", err)
+			log.Println("Cant't retrieve file system information: ", err)
+		} else {
+			diskAvailGB = float64(fsInfo.Bavail) * float64(fsInfo.Frsize) * toGB
+			sample.DiskAvailGB = diskAvailGB
+			if diskAvailGB < Limits.MinDiskGB && reason == "" {
+				reason = fmt.Sprintf("available disk space less than %v GB on the system", Limits.MinDiskGB)
+			}
 		}
-		if diskAvailGB = float64(fsInfo.Bavail) * float64(fsInfo.Frsize) * toGB; diskAvailGB < minDiskGB {
-			reason = " available disk space less than 5 GB on the system"
+		
+		// What kira itself is using
+		if sample.KiraRSSMB, sample.KiraCPUSeconds, err = procStats(kiraWrappedCmd.Process.Pid); err != nil && Verb {
+			log.Println("Can't read kira process stats: ", err)
+		}
+		sample.Reason = reason
+		telemetry.Write(sample)
+		if reason != "" {
 			break
 		}
 		
 		// Wait some time
-		time.Sleep(time.Duration(2) * time.Minute)
+		select {
+		case <-stop:
+			return
+		case <-time.After(Limits.Poll()):
+		}
+	}
+	select {
+	case <-stop:
+		// kira is already gone, maybe relaunched
+		return
+	default:
 	}
 	log.Println("Kill kira because ", reason)
 	if err := kiraWrappedCmd.Process.Kill(); err != nil {
-		log.Fatal("failed to kill: ", err)
+		// kira exited after the stop check, waitProcess reports it
+		if !errors.Is(err, os.ErrProcessDone) {
+			log.Println("Failed to kill kira: ", err)
+		}
+		return
 	}
 	done <- "killed because " + reason
 }
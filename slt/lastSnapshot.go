@@ -0,0 +1,204 @@
+package slt
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brunetto/goutils/debug"
+)
+
+// ErrNoCompleteSnapshot means a STDOUT has no complete snapshot.
+var ErrNoCompleteSnapshot = errors.New("no complete snapshot")
+
+// reverseBlockSize is how much of the STDOUT is read at a time going back
+// from the end.
+const reverseBlockSize = 1 << 20
+
+// LastCompleteSnapshot returns the last complete snapshot of the STDOUT
+// path. Uncompressed files are read backward from the end, so that only
+// the tail is read even for huge outputs; compressed ones can't be seeked
+// and are read forward.
+func LastCompleteSnapshot(path string) (*DumbSnapshot, error) {
+	return LastCompleteSnapshotUpTo(path, "")
+}
+
+// LastCompleteSnapshotUpTo is LastCompleteSnapshot skipping the snapshots
+// after timestep, if not empty, like the ones written after the last
+// timestep of the kiraWrap exit record.
+func LastCompleteSnapshotUpTo(path, timestep string) (*DumbSnapshot, error) {
+	if Debug {
+		defer debug.TimeMe(time.Now())
+	}
+	var (
+		inFile *StdReader
+		info   os.FileInfo
+		upTo   = -1.
+		err    error
+	)
+	if timestep != "" {
+		if upTo, err = strconv.ParseFloat(timestep, 64); err != nil {
+			return nil, err
+		}
+	}
+	if inFile, err = OpenStd(path); err != nil {
+		return nil, err
+	}
+	defer inFile.Close()
+	if inFile.Codec != nil {
+		return lastSnapshotForward(inFile.Reader, upTo)
+	}
+	if info, err = inFile.file.Stat(); err != nil {
+		return nil, err
+	}
+	return lastSnapshotBackward(inFile.file, info.Size(), upTo)
+}
+
+// lastSnapshotForward reads all the snapshots and keeps the last complete
+// one not after upTo (if not negative).
+func lastSnapshotForward(nReader *bufio.Reader, upTo float64) (*DumbSnapshot, error) {
+	var (
+		scanner = NewOutSnapshotScanner(nReader)
+		last    *DumbSnapshot
+	)
+	for scanner.Scan() {
+		if !snapshotUpTo(scanner.Snapshot(), upTo) {
+			break
+		}
+		last = scanner.Snapshot()
+	}
+	// A corrupted snapshot ends the reading like the end of the file,
+	// but a snapshot without root means the STDOUT is not what it seems
+	if err := scanner.Err(); errors.Is(err, ErrMissingRoot) {
+		return nil, err
+	}
+	if last == nil {
+		return nil, ErrNoCompleteSnapshot
+	}
+	return last, nil
+}
+
+// lastSnapshotBackward goes back from the end of file looking for the
+// beginning of the root particles: a "(Particle" line followed by
+// "name = root". From each of them, the last first, it reads forward one
+// snapshot with readOutSnapshot, checking the nesting, until it finds a
+// complete one not after upTo (if not negative).
+// The lines between the previous snapshot and the root particle (the
+// header) belong to the snapshot, as when reading forward.
+func lastSnapshotBackward(file io.ReaderAt, size int64, upTo float64) (*DumbSnapshot, error) {
+	var (
+		lines    = &reverseLineReader{file: file, offset: size}
+		line     string
+		offset   int64
+		nameRoot bool // the line seen before, the next in the file, is "name = root"
+		header   bool // going back through the header of a root particle
+		snap     *DumbSnapshot
+		err      error
+	)
+	for {
+		if line, offset, err = lines.Prev(); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if header {
+			if trimmed := strings.TrimSpace(line); !strings.HasPrefix(trimmed, "(") && !strings.HasPrefix(trimmed, ")") {
+				continue
+			}
+			header = false
+			if snap, err = readSnapshotAt(file, offset+int64(len(line))+1, size, upTo); snap != nil || err != nil {
+				return snap, err
+			}
+		}
+		if strings.Contains(line, "name = root") {
+			nameRoot = true
+			continue
+		}
+		if nameRoot && strings.HasPrefix(strings.TrimSpace(line), "(Particle") {
+			header = true
+		}
+		nameRoot = false
+	}
+	if header {
+		if snap, err = readSnapshotAt(file, 0, size, upTo); snap != nil || err != nil {
+			return snap, err
+		}
+	}
+	return nil, ErrNoCompleteSnapshot
+}
+
+// readSnapshotAt reads the snapshot beginning at start, nil if it is not
+// complete or it is after upTo (if not negative).
+func readSnapshotAt(file io.ReaderAt, start, size int64, upTo float64) (*DumbSnapshot, error) {
+	var (
+		nReader = bufio.NewReader(io.NewSectionReader(file, start, size-start))
+		lineNo  int64
+		snap    *DumbSnapshot
+		err     error
+	)
+	if snap, err = readOutSnapshot(nReader, &lineNo); err != nil {
+		if errors.Is(err, ErrMissingRoot) {
+			return nil, err
+		}
+		// Truncated or badly nested, try the previous one
+		return nil, nil
+	}
+	if !snap.Integrity || !snapshotUpTo(snap, upTo) {
+		return nil, nil
+	}
+	return snap, nil
+}
+
+// snapshotUpTo tells whether snap is not after upTo, always true if upTo
+// is negative.
+func snapshotUpTo(snap *DumbSnapshot, upTo float64) bool {
+	if upTo < 0 {
+		return true
+	}
+	timestep, err := strconv.ParseFloat(snap.Timestep, 64)
+	return err != nil || timestep <= upTo
+}
+
+// reverseLineReader reads the lines of a file from the last one to the
+// first, reverseBlockSize bytes at a time.
+type reverseLineReader struct {
+	file io.ReaderAt
+	// offset is where buf begins in the file: what is before has not been read yet.
+	offset int64
+	buf    []byte
+	done   bool
+}
+
+// Prev returns the previous line, without the newline, and its offset in
+// the file. It returns io.EOF after the first line.
+func (r *reverseLineReader) Prev() (string, int64, error) {
+	for {
+		if idx := bytes.LastIndexByte(r.buf, '\n'); idx >= 0 {
+			line := string(r.buf[idx+1:])
+			r.buf = r.buf[:idx]
+			return line, r.offset + int64(idx) + 1, nil
+		}
+		if r.offset == 0 {
+			if r.done {
+				return "", 0, io.EOF
+			}
+			r.done = true
+			return string(r.buf), 0, nil
+		}
+		n := int64(reverseBlockSize)
+		if n > r.offset {
+			n = r.offset
+		}
+		block := make([]byte, n, n+int64(len(r.buf)))
+		if _, err := r.file.ReadAt(block, r.offset-n); err != nil && err != io.EOF {
+			return "", 0, err
+		}
+		r.offset -= n
+		r.buf = append(block, r.buf...)
+	}
+}
@@ -0,0 +1,97 @@
+package slt
+
+import (
+	"bufio"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// writeStd writes data to path, compressed according to its extension.
+func writeStd(t *testing.T, path, data string) {
+	var (
+		writer *StdWriter
+		err    error
+	)
+	if writer, err = CreateStd(path); err != nil {
+		t.Fatal(err)
+	}
+	writer.WriteString(data)
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLastCompleteSnapshot(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		tests = []struct {
+			name     string
+			data     string
+			upTo     string
+			timestep string
+			err      error
+		}{
+			{"complete", outData(0, 5, false), "", "4", nil},
+			{"truncated tail", outData(0, 5, true), "", "4", nil},
+			{"up to", outData(0, 5, true), "2", "2", nil},
+			{"up to the end", outData(0, 5, false), "4", "4", nil},
+			{"up to before the start", outData(3, 2, false), "1", "", ErrNoCompleteSnapshot},
+			{"only the tail", testSnapshot[:300], "", "", ErrNoCompleteSnapshot},
+			{"empty", "", "", "", ErrNoCompleteSnapshot},
+		}
+	)
+	for _, test := range tests {
+		for _, ext := range []string{".txt", ".txt.gz"} {
+			var (
+				path = filepath.Join(dir, "out"+ext)
+				snap *DumbSnapshot
+				err  error
+			)
+			writeStd(t, path, test.data)
+			snap, err = LastCompleteSnapshotUpTo(path, test.upTo)
+			if !errors.Is(err, test.err) || (err == nil) != (test.err == nil) {
+				t.Errorf("%v%v: error %v, want %v", test.name, ext, err, test.err)
+				continue
+			}
+			if err != nil {
+				continue
+			}
+			if snap.Timestep != test.timestep || !snap.Integrity {
+				t.Errorf("%v%v: timestep %v, want %v", test.name, ext, snap.Timestep, test.timestep)
+			}
+			timestep, _ := strconv.Atoi(snap.Timestep)
+			if want := strings.TrimSuffix(outData(timestep, 1, false), "\n"); strings.Join(snap.Lines, "\n") != want {
+				t.Errorf("%v%v: the snapshot lines differ from the file", test.name, ext)
+			}
+		}
+	}
+}
+
+// TestLastSnapshotBackward checks that reading backward finds the same
+// snapshot as reading forward wherever the file is cut.
+func TestLastSnapshotBackward(t *testing.T) {
+	var data = "# kiraWrap header\n" + outData(0, 3, false)
+	for cut := 0; cut <= len(data); cut++ {
+		for _, upTo := range []float64{-1, 1} {
+			var (
+				tail                = data[:cut]
+				forward, backward   *DumbSnapshot
+				forwardErr, backErr error
+			)
+			forward, forwardErr = lastSnapshotForward(bufio.NewReader(strings.NewReader(tail)), upTo)
+			backward, backErr = lastSnapshotBackward(strings.NewReader(tail), int64(len(tail)), upTo)
+			if (forwardErr == nil) != (backErr == nil) {
+				t.Fatalf("cut at %v, up to %v: errors %v and %v", cut, upTo, forwardErr, backErr)
+			}
+			if forwardErr != nil {
+				continue
+			}
+			if forward.Timestep != backward.Timestep || strings.Join(forward.Lines, "\n") != strings.Join(backward.Lines, "\n") {
+				t.Fatalf("cut at %v, up to %v: timestep %v forward, %v backward", cut, upTo, forward.Timestep, backward.Timestep)
+			}
+		}
+	}
+}
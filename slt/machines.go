@@ -0,0 +1,305 @@
+package slt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MachineProfile describes how to run kira on a cluster.
+// Profiles are read from a machines file like:
+//
+//	[
+//		{
+//			"Name": "eurora",
+//			"Hosts": ["eurora"],
+//			"Scheduler": "pbs",
+//			"Queue": "parallel",
+//			"Walltime": "4:00:00",
+//			"Account": "IscrC_SCmerge",
+//			"Resources": "select=1:ncpus=1:ngpus=2",
+//			"CPUs": 1,
+//			"GPUs": 2,
+//			"Modules": ["profile/advanced", "gnu/4.6.3", "cuda"],
+//			"Env": {"LD_LIBRARY_PATH": "/cineca/prod/compilers/cuda/5.0.35/none/lib64"}
+//		}
+//	]
+type MachineProfile struct {
+	Name string
+	// Hosts are substrings of the output of "hostname -A" identifying the machine.
+	Hosts []string
+	// Scheduler is the batch system: pbs, slurm, local or fake.
+	Scheduler string
+	Queue     string
+	// Walltime is in the HH:MM:SS or DD:HH:MM:SS form.
+	Walltime string
+	Account  string
+	// Resources is the PBS resource request, i.e. select=1:ncpus=1:ngpus=2.
+	Resources string
+	CPUs      int
+	GPUs      int
+	// Modules are loaded after a "module purge", none if empty.
+	Modules []string
+	// Env are the environment variables exported before running kira.
+	Env map[string]string
+	// KiraWrap is the kiraWrap binary, $HOME/bin/kiraWrap if empty.
+	KiraWrap string
+	// Kira, if set, is run directly instead of kiraWrap.
+	Kira string
+	// Templates override the built-in scripts on this machine.
+	Templates *ScriptTemplates
+}
+
+// MachinesFile is the machines file chosen with the --machines flag.
+var MachinesFile string
+
+var (
+	machines      []*MachineProfile
+	machinesMutex sync.Mutex
+)
+
+// defaultMachines are the profiles used when there is no machines file,
+// the ones that have always been known to sltools.
+const defaultMachines = `[
+	{
+		"Name": "eurora",
+		"Hosts": ["eurora"],
+		"Scheduler": "pbs",
+		"Queue": "parallel",
+		"Walltime": "4:00:00",
+		"Account": "IscrC_SCmerge",
+		"Resources": "select=1:ncpus=1:ngpus=2",
+		"CPUs": 1,
+		"GPUs": 2,
+		"Modules": ["profile/advanced", "gnu/4.6.3", "boost/1.53.0--gnu--4.6.3", "cuda"],
+		"Env": {"LD_LIBRARY_PATH": "/cineca/prod/compilers/cuda/5.0.35/none/lib64:/cineca/prod/libraries/boost/1.53.0/gnu--4.6.3/lib"}
+	},
+	{
+		"Name": "g2swin",
+		"Hosts": ["swin"],
+		"Scheduler": "pbs",
+		"Queue": "gstar",
+		"Walltime": "07:00:00:00",
+		"Account": "p003_swin",
+		"Resources": "nodes=1:ppn=1:gpus=2",
+		"CPUs": 1,
+		"GPUs": 2,
+		"Modules": ["gcc/4.6.4", "boost/x86_64/gnu/1.51.0-gcc4.6", "cuda/4.0"]
+	},
+	{
+		"Name": "plx",
+		"Hosts": ["plx"],
+		"Scheduler": "pbs",
+		"Queue": "longpar",
+		"Walltime": "24:00:00",
+		"Account": "IscrC_SCmerge",
+		"Resources": "select=1:ncpus=1:ngpus=2",
+		"CPUs": 1,
+		"GPUs": 2,
+		"Modules": ["gnu/4.1.2", "profile/advanced", "boost/1.41.0--intel--11.1--binary", "cuda/4.0"],
+		"Env": {"LD_LIBRARY_PATH": "/cineca/prod/compilers/cuda/4.0/none/lib64:/cineca/prod/compilers/cuda/4.0/none/lib:/cineca/prod/libraries/boost/1.41.0/intel--11.1--binary/lib:/cineca/prod/compilers/intel/11.1/binary/lib/intel64"},
+		"Kira": "$HOME/slpack/starlab/usr/bin/kira"
+	}
+]`
+
+var walltimeReg = regexp.MustCompile(`^(\d+:)?\d+:\d\d:\d\d$`)
+
+// machinesFileNames returns where to look for the machines file:
+// the --machines flag, machines.json here and ~/.sltools/machines.json.
+func machinesFileNames() []string {
+	if MachinesFile != "" {
+		return []string{MachinesFile}
+	}
+	return []string{"machines.json", filepath.Join(os.Getenv("HOME"), ".sltools", "machines.json")}
+}
+
+// ReadMachines parses and validates a machines file.
+func ReadMachines(content []byte) ([]*MachineProfile, error) {
+	var (
+		profiles = []*MachineProfile{}
+		names    = map[string]bool{}
+		err      error
+	)
+	if err = json.Unmarshal(content, &profiles); err != nil {
+		return nil, err
+	}
+	for _, profile := range profiles {
+		if err = profile.Validate(); err != nil {
+			return nil, err
+		}
+		if names[profile.Name] {
+			return nil, fmt.Errorf("machine %v defined twice", profile.Name)
+		}
+		names[profile.Name] = true
+	}
+	return profiles, nil
+}
+
+// LoadMachines returns the machine profiles from the machines file,
+// the built-in ones if there isn't any.
+func LoadMachines() []*MachineProfile {
+	var (
+		content []byte
+		err     error
+	)
+	machinesMutex.Lock()
+	defer machinesMutex.Unlock()
+	if machines != nil {
+		return machines
+	}
+	for _, fileName := range machinesFileNames() {
+		if content, err = ioutil.ReadFile(fileName); err != nil {
+			if os.IsNotExist(err) && MachinesFile == "" {
+				continue
+			}
+			log.Fatal("Can't read machines file: ", err)
+		}
+		if machines, err = ReadMachines(content); err != nil {
+			log.Fatal("Machines file ", fileName, ": ", err)
+		}
+		if Verb {
+			log.Println("Machines loaded from ", fileName)
+		}
+		return machines
+	}
+	if machines, err = ReadMachines([]byte(defaultMachines)); err != nil {
+		log.Fatal("Built-in machines: ", err)
+	}
+	return machines
+}
+
+// GetMachine returns the profile with the given name.
+func GetMachine(name string) (*MachineProfile, error) {
+	for _, profile := range LoadMachines() {
+		if profile.Name == name {
+			return profile, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown machine %v", name)
+}
+
+// DetectMachine finds the profile of the machine we are running on
+// by matching the host names against the Hosts of the profiles.
+func DetectMachine() (*MachineProfile, error) {
+	var (
+		stdo      bytes.Buffer
+		cmd       = exec.Command("hostname", "-A")
+		hostNames string
+		err       error
+	)
+	cmd.Stdout = &stdo
+	if err = cmd.Run(); err == nil {
+		hostNames = stdo.String()
+	} else if hostNames, err = os.Hostname(); err != nil {
+		return nil, fmt.Errorf("can't find the host name: %v", err)
+	}
+	for _, profile := range LoadMachines() {
+		for _, host := range profile.Hosts {
+			if strings.Contains(hostNames, host) {
+				return profile, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no machine profile matches %v", strings.TrimSpace(hostNames))
+}
+
+// Validate checks the profile.
+func (profile *MachineProfile) Validate() error {
+	if profile.Name == "" {
+		return fmt.Errorf("machine without name")
+	}
+	if _, err := NewScheduler(profile.Scheduler); err != nil {
+		return fmt.Errorf("machine %v: %v", profile.Name, err)
+	}
+	if profile.Scheduler != "local" && profile.Scheduler != "fake" && profile.Queue == "" {
+		return fmt.Errorf("machine %v: empty queue", profile.Name)
+	}
+	if profile.Walltime != "" && !walltimeReg.MatchString(profile.Walltime) {
+		return fmt.Errorf("machine %v: walltime %v is not HH:MM:SS or DD:HH:MM:SS", profile.Name, profile.Walltime)
+	}
+	if profile.CPUs < 0 || profile.GPUs < 0 {
+		return fmt.Errorf("machine %v: negative CPUs or GPUs", profile.Name)
+	}
+	for _, host := range profile.Hosts {
+		if host == "" {
+			return fmt.Errorf("machine %v: empty host pattern", profile.Name)
+		}
+	}
+	return nil
+}
+
+// Setup returns the shell lines loading the modules and
+// exporting the environment.
+func (profile *MachineProfile) Setup() string {
+	var (
+		setup string
+		names = []string{}
+	)
+	if len(profile.Modules) > 0 {
+		setup += "module purge\n"
+		for _, module := range profile.Modules {
+			setup += "module load " + module + "\n"
+		}
+		setup += "\n"
+	}
+	for name := range profile.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		setup += name + "=" + profile.Env[name] + "\n" + "export " + name + "\n"
+	}
+	if len(names) > 0 {
+		setup += "\n"
+	}
+	return setup
+}
+
+// KiraWrapPath returns the kiraWrap binary with the environment expanded.
+func (profile *MachineProfile) KiraWrapPath() string {
+	if profile.KiraWrap == "" {
+		return filepath.Join(os.Getenv("HOME"), "bin", "kiraWrap")
+	}
+	return os.ExpandEnv(profile.KiraWrap)
+}
+
+// KiraPath returns the kira binary run directly, empty to use kiraWrap.
+func (profile *MachineProfile) KiraPath() string {
+	return os.ExpandEnv(profile.Kira)
+}
+
+// Print prints the profile.
+func (profile *MachineProfile) Print() {
+	fmt.Println("Name:\t\t", profile.Name)
+	fmt.Println("Hosts:\t\t", strings.Join(profile.Hosts, ", "))
+	fmt.Println("Scheduler:\t", profile.Scheduler)
+	fmt.Println("Queue:\t\t", profile.Queue)
+	fmt.Println("Walltime:\t", profile.Walltime)
+	fmt.Println("Account:\t", profile.Account)
+	fmt.Println("Resources:\t", profile.Resources)
+	fmt.Println("CPUs/GPUs:\t", profile.CPUs, "/", profile.GPUs)
+	if profile.Kira != "" {
+		fmt.Println("Kira:\t\t", profile.KiraPath())
+	} else {
+		fmt.Println("KiraWrap:\t", profile.KiraWrapPath())
+	}
+	fmt.Println("Setup:")
+	fmt.Print(profile.Setup())
+}
+
+// ListMachines prints the known machine profiles.
+func ListMachines() {
+	for _, profile := range LoadMachines() {
+		fmt.Printf("%-12v %-8v %-10v %-12v %v\n", profile.Name, profile.Scheduler,
+			profile.Queue, profile.Walltime, strings.Join(profile.Hosts, ","))
+	}
+}
@@ -0,0 +1,313 @@
+package slt
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NameField is a parameter written in the file names.
+type NameField struct {
+	// Name is the placeholder used in the template. Comb, Tf, Rv, Ncm, Fpb,
+	// W and Z are the RunID fields, any other name is an extra parameter
+	// whose value is taken from the Params of the configuration.
+	Name string
+	// Kind tells how the value is written: "int", "string", "float" or
+	// "compact" (a fraction without the dot, i.e. 005 for 0.05).
+	Kind string
+	// Width is the zero padding of "int" values.
+	Width int
+	// Decimals fixes the number of digits of "compact" values,
+	// 0 means as many as needed.
+	Decimals int
+}
+
+// NamingScheme describes the part of the file names shared by all the
+// files of a cluster (see ConfigStruct.BaseName). The whole file name is
+//
+//	prefix-[head]<template>-runNN-rndNN.ext
+//
+// where head is whatever old names have in front of the template (i.e. "cineca-").
+type NamingScheme struct {
+	// Template contains one {{.Name}} placeholder for each field,
+	// like "comb{{.Comb}}-NCM{{.Ncm}}".
+	Template string
+	Fields   []NameField
+	parts    []namePart
+	reg      *regexp.Regexp
+}
+
+// namePart is either a literal piece of the template or a field.
+type namePart struct {
+	literal string
+	field   *NameField
+}
+
+var placeholderReg = regexp.MustCompile(`\{\{\s*\.(\w+)\s*\}\}`)
+
+// DefaultNaming is the historical scheme,
+// i.e. comb16-TFno-Rv1-NCM10000-fPB005-W5-Z010.
+var DefaultNaming = &NamingScheme{
+	Template: "comb{{.Comb}}-TF{{.Tf}}-Rv{{.Rv}}-NCM{{.Ncm}}-fPB{{.Fpb}}-W{{.W}}-Z{{.Z}}",
+	Fields: []NameField{
+		{Name: "Comb", Kind: "int", Width: 2},
+		{Name: "Tf", Kind: "string"},
+		{Name: "Rv", Kind: "int"},
+		{Name: "Ncm", Kind: "int"},
+		{Name: "Fpb", Kind: "compact"},
+		{Name: "W", Kind: "int"},
+		{Name: "Z", Kind: "compact", Decimals: 2},
+	},
+}
+
+// builtinNameFields are the fields stored in RunID and ConfigStruct,
+// the others go in Params.
+var builtinNameFields = map[string]bool{
+	"Comb": true, "Tf": true, "Rv": true, "Ncm": true, "Fpb": true, "W": true, "Z": true,
+}
+
+// Naming is the scheme used to parse and create file names. It is set
+// from the configuration file by InitVars or LoadNaming.
+var Naming *NamingScheme
+
+func init() {
+	if err := DefaultNaming.Compile(); err != nil {
+		log.Fatal("Default naming scheme: ", err)
+	}
+	Naming = DefaultNaming
+}
+
+// Compile checks the scheme and prepares the parser.
+// It must be called before using a scheme read from JSON.
+func (scheme *NamingScheme) Compile() error {
+	var (
+		fields  = map[string]*NameField{}
+		used    = map[string]bool{}
+		regStr  string
+		matches [][]int
+		last    int
+		field   *NameField
+		ok      bool
+	)
+	if scheme.Template == "" {
+		return fmt.Errorf("empty naming template")
+	}
+	for idx := range scheme.Fields {
+		field = &scheme.Fields[idx]
+		switch field.Kind {
+		case "int", "string", "float", "compact":
+		case "":
+			field.Kind = "string"
+		default:
+			return fmt.Errorf("unknown kind %v for field %v", field.Kind, field.Name)
+		}
+		if _, ok = fields[field.Name]; ok {
+			return fmt.Errorf("field %v declared twice", field.Name)
+		}
+		fields[field.Name] = field
+	}
+
+	scheme.parts = []namePart{}
+	regStr = `^(\w+)-(\S*?)`
+	matches = placeholderReg.FindAllStringSubmatchIndex(scheme.Template, -1)
+	for _, match := range matches {
+		if match[0] > last {
+			scheme.parts = append(scheme.parts, namePart{literal: scheme.Template[last:match[0]]})
+			regStr += regexp.QuoteMeta(scheme.Template[last:match[0]])
+		}
+		name := scheme.Template[match[2]:match[3]]
+		if field, ok = fields[name]; !ok {
+			return fmt.Errorf("placeholder %v is not in the field list", name)
+		}
+		if used[name] {
+			return fmt.Errorf("placeholder %v used twice", name)
+		}
+		used[name] = true
+		scheme.parts = append(scheme.parts, namePart{field: field})
+		switch field.Kind {
+		case "int", "compact":
+			regStr += `(\d+)`
+		case "float":
+			regStr += `([0-9.]+)`
+		default:
+			regStr += `(\S+?)`
+		}
+		last = match[1]
+	}
+	if last < len(scheme.Template) {
+		scheme.parts = append(scheme.parts, namePart{literal: scheme.Template[last:]})
+		regStr += regexp.QuoteMeta(scheme.Template[last:])
+	}
+	for name := range fields {
+		if !used[name] {
+			return fmt.Errorf("field %v is not in the template", name)
+		}
+	}
+	regStr += `-run(\d+)-rnd(\d+)(\.\S+)$`
+
+	scheme.reg = regexp.MustCompile(regStr)
+	return nil
+}
+
+// Parse extracts the run information from a file name following the scheme.
+func (scheme *NamingScheme) Parse(fileName string) (RunID, error) {
+	var (
+		id     = RunID{Full: true}
+		res    []string
+		groups []string
+		err    error
+	)
+	if res = scheme.reg.FindStringSubmatch(fileName); res == nil {
+		return RunID{}, fmt.Errorf("%v doesn't follow the naming scheme %v", fileName, scheme.Template)
+	}
+	id.Prefix, id.Head = res[1], res[2]
+	groups = res[3 : len(res)-3]
+	for _, part := range scheme.parts {
+		if part.field == nil {
+			continue
+		}
+		if err = id.SetValue(part.field.Name, parseFieldValue(part.field, groups[0])); err != nil {
+			return RunID{}, fmt.Errorf("can't extract %v from %v: %v", part.field.Name, fileName, err)
+		}
+		groups = groups[1:]
+	}
+	if id.Run, err = strconv.Atoi(res[len(res)-3]); err != nil {
+		return RunID{}, err
+	}
+	if id.Round, err = strconv.Atoi(res[len(res)-2]); err != nil {
+		return RunID{}, err
+	}
+	id.Ext = res[len(res)-1]
+	return id, nil
+}
+
+// Format writes the base name of id following the scheme.
+func (scheme *NamingScheme) Format(id RunID) string {
+	var name string
+	for _, part := range scheme.parts {
+		if part.field == nil {
+			name += part.literal
+			continue
+		}
+		name += formatFieldValue(part.field, id.Value(part.field.Name))
+	}
+	return name
+}
+
+// Glob returns the pattern matching all the files of the given prefix
+// and extension, i.e. Glob("*", ".txt") for all the text files.
+func (scheme *NamingScheme) Glob(prefix, ext string) string {
+	var glob = prefix + "-*"
+	for _, part := range scheme.parts {
+		if part.field == nil {
+			glob += part.literal
+		} else {
+			glob += "*"
+		}
+	}
+	return glob + "-run*-rnd*" + ext
+}
+
+// legacyGlob matches the older names accepted by ParseRunID through
+// runIDReg, i.e. out-cineca-comb16-NCM10000-fPB005-W5-Z010-run06-rnd00.txt.
+func legacyGlob(prefix, ext string) string {
+	return prefix + "-*comb*-run*-*" + ext
+}
+
+// GlobFiles returns the sorted files in folder with the given prefix and
+// extension (patterns allowed) named after the scheme or with the older
+// names ParseRunID still accepts.
+func (scheme *NamingScheme) GlobFiles(folder, prefix, ext string) ([]string, error) {
+	var (
+		fileNames = []string{}
+		seen      = map[string]bool{}
+		matches   []string
+		err       error
+	)
+	for _, glob := range []string{scheme.Glob(prefix, ext), legacyGlob(prefix, ext)} {
+		if matches, err = filepath.Glob(filepath.Join(folder, glob)); err != nil {
+			return nil, err
+		}
+		for _, fileName := range matches {
+			if seen[fileName] {
+				continue
+			}
+			seen[fileName] = true
+			if _, err = ParseRunID(fileName); err == nil {
+				fileNames = append(fileNames, fileName)
+			}
+		}
+	}
+	sort.Strings(fileNames)
+	return fileNames, nil
+}
+
+// parseFieldValue converts the value as written in the name to the value
+// as written in the configuration.
+func parseFieldValue(field *NameField, str string) string {
+	switch field.Kind {
+	case "int":
+		if value, err := strconv.Atoi(str); err == nil {
+			return strconv.Itoa(value)
+		}
+	case "compact":
+		if value, err := parseCompact(str); err == nil {
+			return strconv.FormatFloat(value, 'f', -1, 64)
+		}
+	}
+	return str
+}
+
+// formatFieldValue converts a value as written in the configuration to
+// the value to be written in the name.
+func formatFieldValue(field *NameField, str string) string {
+	switch field.Kind {
+	case "int":
+		if value, err := strconv.Atoi(str); err == nil {
+			return fmt.Sprintf("%0*d", field.Width, value)
+		}
+	case "compact":
+		if value, err := strconv.ParseFloat(str, 64); err == nil {
+			if field.Decimals > 0 {
+				str = strconv.FormatFloat(value, 'f', field.Decimals, 64)
+			} else {
+				str = fmt.Sprintf("%v", value)
+			}
+			return strings.Replace(str, ".", "", -1)
+		}
+	}
+	return str
+}
+
+// LoadNaming sets Naming from the configuration file, if any, without
+// checking the rest of the configuration. Commands that work on the files
+// of a folder call it to understand the names.
+func LoadNaming(confName string) {
+	var (
+		confFile []byte
+		conf     struct{ Naming *NamingScheme }
+		err      error
+	)
+	if confName == "" {
+		return
+	}
+	if confFile, err = ioutil.ReadFile(confName); err != nil {
+		log.Fatal(err)
+	}
+	if err = json.Unmarshal(confFile, &conf); err != nil {
+		log.Fatal("Parse config: ", err)
+	}
+	if conf.Naming == nil {
+		return
+	}
+	if err = conf.Naming.Compile(); err != nil {
+		log.Fatal("Naming scheme in ", confName, ": ", err)
+	}
+	Naming = conf.Naming
+}
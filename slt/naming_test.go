@@ -0,0 +1,134 @@
+package slt
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNamingRoundTrip(t *testing.T) {
+	var (
+		confJSON = `{"Comb": 3, "Tf": "no", "Rv": 1, "Ncm": 5000, "Fpb": 0.1, "W": 5, "Z": 0.02,
+			"Params": {"Imf": "2.3", "Soft": "0.01"},
+			"Naming": {"Template": "comb{{.Comb}}-NCM{{.Ncm}}-fPB{{.Fpb}}-IMF{{.Imf}}-eps{{.Soft}}-Z{{.Z}}",
+				"Fields": [{"Name": "Comb", "Kind": "int", "Width": 2}, {"Name": "Ncm", "Kind": "int"},
+					{"Name": "Fpb", "Kind": "compact"}, {"Name": "Imf", "Kind": "compact"},
+					{"Name": "Soft", "Kind": "float"}, {"Name": "Z", "Kind": "compact", "Decimals": 2}]}}`
+		oldNaming = Naming
+		defaults  = []struct {
+			conf     ConfigStruct
+			run      int
+			fileName string
+		}{
+			{ConfigStruct{Comb: 16, Tf: "no", Rv: 1, Ncm: 10000, Fpb: 0.05, W: 5, Z: 0.1}, 6,
+				"out-comb16-TFno-Rv1-NCM10000-fPB005-W5-Z010-run06-rnd00.txt"},
+			{ConfigStruct{Comb: 3, Tf: "yes", Rv: 5, Ncm: 1000000, Fpb: 0.2, W: 9, Z: 1}, 12,
+				"out-comb03-TFyes-Rv5-NCM1000000-fPB02-W9-Z100-run12-rnd00.txt"},
+			{ConfigStruct{Comb: 120, Tf: "no", Rv: 1, Ncm: 5000, Fpb: 0.1, W: 3, Z: 0.01}, 0,
+				"out-comb120-TFno-Rv1-NCM5000-fPB01-W3-Z001-run00-rnd00.txt"},
+		}
+		conf ConfigStruct
+	)
+	defer func() { Naming = oldNaming }()
+
+	for _, test := range defaults {
+		var (
+			name = test.conf.RunID(test.run).WithPrefix("out").WithExt(".txt").String()
+			id   RunID
+			err  error
+		)
+		if name != test.fileName {
+			t.Errorf("name %v, want %v", name, test.fileName)
+		}
+		if id, err = ParseRunID(name); err != nil {
+			t.Fatal(err)
+		}
+		if id.BaseName() != test.conf.BaseName() || id.Run != test.run || id.String() != name {
+			t.Errorf("%v parsed as %+v", name, id)
+		}
+		if next := id.NextRound().WithPrefix("ics").String(); next != "ics"+name[3:len(name)-6]+"01.txt" {
+			t.Errorf("next round of %v is %v", name, next)
+		}
+	}
+
+	if err := json.Unmarshal([]byte(confJSON), &conf); err != nil {
+		t.Fatal(err)
+	}
+	if err := conf.Naming.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	Naming = conf.Naming
+	for run := 0; run < 3; run++ {
+		var (
+			want = conf.RunID(run).WithPrefix("out").WithExt(".txt")
+			id   RunID
+			err  error
+		)
+		if id, err = ParseRunID(want.String()); err != nil {
+			t.Fatal(err)
+		}
+		if !id.Full || id.String() != want.String() || id.Params["Imf"] != "2.3" || id.Params["Soft"] != "0.01" || id.Z != 0.02 {
+			t.Errorf("run %v: %v parsed as %+v", run, want, id)
+		}
+		if conf.RunFileName(id) != want.String() {
+			t.Errorf("run %v: RunFileName %v, want %v", run, conf.RunFileName(id), want)
+		}
+	}
+	if want := "out-comb03-NCM5000-fPB01-IMF23-eps0.01-Z002-run01-rnd00.txt"; conf.RunID(1).WithPrefix("out").WithExt(".txt").String() != want {
+		t.Errorf("name %v, want %v", conf.RunID(1).WithPrefix("out").WithExt(".txt"), want)
+	}
+}
+
+func TestNamingCompile(t *testing.T) {
+	var tests = []struct {
+		scheme NamingScheme
+		fails  bool
+	}{
+		{NamingScheme{Template: "comb{{.Comb}}", Fields: []NameField{{Name: "Comb", Kind: "int"}}}, false},
+		{NamingScheme{Template: ""}, true},
+		{NamingScheme{Template: "comb{{.Comb}}", Fields: []NameField{{Name: "Comb", Kind: "complex"}}}, true},
+		{NamingScheme{Template: "comb{{.Comb}}-{{.W}}", Fields: []NameField{{Name: "Comb", Kind: "int"}}}, true},
+		{NamingScheme{Template: "comb{{.Comb}}", Fields: []NameField{{Name: "Comb"}, {Name: "W"}}}, true},
+		{NamingScheme{Template: "comb{{.Comb}}{{.Comb}}", Fields: []NameField{{Name: "Comb"}}}, true},
+	}
+	for _, test := range tests {
+		if err := test.scheme.Compile(); (err != nil) != test.fails {
+			t.Errorf("Compile(%v) error %v", test.scheme.Template, err)
+		}
+	}
+}
+
+func TestGlobFiles(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		files = []string{
+			"out-comb16-TFno-Rv1-NCM10000-fPB005-W5-Z010-run01-rnd00.txt",
+			"out-cineca-comb16-NCM10000-fPB005-W5-Z010-run00-rnd00.txt",
+			"out-comb16-TFno-Rv1-NCM10000-fPB005-W5-Z010-run01-rnd01.txt.gz",
+			"err-comb16-TFno-Rv1-NCM10000-fPB005-W5-Z010-run01-rnd00.txt",
+			"out-notes.txt",
+		}
+		want = []string{
+			"out-cineca-comb16-NCM10000-fPB005-W5-Z010-run00-rnd00.txt",
+			"out-comb16-TFno-Rv1-NCM10000-fPB005-W5-Z010-run01-rnd00.txt",
+		}
+		found []string
+		err   error
+	)
+	for _, fileName := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, fileName), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if found, err = DefaultNaming.GlobFiles(dir, "out", ".txt"); err != nil {
+		t.Fatal(err)
+	}
+	for idx := range found {
+		found[idx] = filepath.Base(found[idx])
+	}
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("GlobFiles found %v, want %v", found, want)
+	}
+}
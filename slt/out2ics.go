@@ -1,278 +1,319 @@
 package slt
 
 import (
-	"bufio"
-	"compress/gzip"
 	"fmt"
+	"io"
 	"log"
-	"math"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/brunetto/goutils/debug"
 )
 
-var mute bool = false
-
-func Out2ICsEmbed(inFileNameChan chan string, cssInfo chan map[string]string) {
-	mute = true
-	Out2ICs(inFileNameChan, cssInfo)
+// Out2ICsEmbed is Out2ICs without the messages for the terminal user.
+func Out2ICsEmbed(inFileNameChan chan string, cssInfo chan map[string]string, plan *Plan) {
+	out2ICs(inFileNameChan, cssInfo, plan, true)
 }
 
 // Out2ICs read the STDOUT and write the new ICs with the last snapshot.
-func Out2ICs(inFileNameChan chan string, cssInfo chan map[string]string) {
+// The ICs and the state are written through plan, right away if nil.
+func Out2ICs(inFileNameChan chan string, cssInfo chan map[string]string, plan *Plan) {
+	out2ICs(inFileNameChan, cssInfo, plan, false)
+}
+
+func out2ICs(inFileNameChan chan string, cssInfo chan map[string]string, plan *Plan, quiet bool) {
 	if Debug {
 		defer debug.TimeMe(time.Now())
 	}
 
 	var (
-		inFileName                     string
-		err                            error    // errora container
-		newICsFileName                 string   // new ICs file names
-		inFile, newICsFile             *os.File // last STDOUT and new ICs file
-		nReader                        *bufio.Reader
-		nWriter                        *bufio.Writer
-		fileNameBody, newRnd, ext      string                                              // newRnd is the number of the new run round
-		snapshots                      = []*DumbSnapshot{&DumbSnapshot{}, &DumbSnapshot{}} // slice for two snapshots
-		snpN                           int                                                 // number of the snapshot
-		simulationStop                 int64                                               // when to stop the simulation
-		thisTimestep, remainingTime    int64                                               // current timestep number and remaining timesteps to reach simulationStop
-		randomSeed                     string                                              // random seed from STDERR
-		runString                      string                                              // string to run the next round from terminal
-		newErrFileName, newOutFileName string                                              // new names from STDERR and STDOUT
-		regRes                         map[string]string
-		rnd                            string
-		fZip                           *gzip.Reader
-		lengthUnit, length, nStars, fPB, ncm       float64
-		endOfSimMyr float64
+		inFileName string
+		info       map[string]string
+		err        error
 	)
 
-	// 	simulationStop = 500
-
-	fmt.Printf("\tSimulation stop set to (slightly more than) 100 Myr and calculated in the code\n")
+	fmt.Printf("\tSimulation stop set to (slightly more than) %v and calculated from the snapshot units\n", endOfSimMyrString)
 
 	// Retrieve infile from channel and use it
 	for inFileName = range inFileNameChan {
+		if info, err = Out2ICsFile(inFileName, plan, os.Stdout, quiet); err != nil {
+			log.Fatal(err)
+		}
+		cssInfo <- info
+	}
+	close(cssInfo)
+	// 	done <- struct{}{}
+}
 
-		// Extract fileNameBody, round and ext
-		if regRes, err = DeepReg(inFileName); err == nil {
-			if regRes["prefix"] != "out" {
-				log.Fatalf("Please specify a STDOUT file, found %v prefix", regRes["prefix"])
-			}
-			
-			fileNameBody = regRes["baseName"]
-			rnd = regRes["rnd"]
-			ext = regRes["ext"]
-			temp, _ := strconv.ParseInt(rnd, 10, 64)
-			newRnd = strconv.Itoa(int(temp + 1))
-			
-			// Creating new filenames
-			newICsFileName = "ics-" + fileNameBody + "-run" + regRes["run"] + "-rnd" + LeftPad(newRnd, "0", 2) + ext
-			newErrFileName = "err-" + fileNameBody + "-run" + regRes["run"] + "-rnd" + LeftPad(newRnd, "0", 2) + ext
-			newOutFileName = "out-" + fileNameBody + "-run" + regRes["run"] + "-rnd" + LeftPad(newRnd, "0", 2) + ext
-			
-			if length, err = strconv.ParseFloat(regRes["Rv"], 64); err != nil {
-				log.Fatalf("Can't convert cluster length %v to float64: %v\n", regRes["Rv"], err)
-			}
-			if fPB, err = strconv.ParseFloat(regRes["fPB"][:1]+"."+regRes["fPB"][1:], 64); err != nil {
-				log.Fatalf("Can't convert cluster fPB %v to float64: %v\n", regRes["fPB"], err)
-			}
-			if ncm, err = strconv.ParseFloat(regRes["NCM"], 64); err != nil {
-				log.Fatalf("Can't convert cluster NCM %v to float64: %v\n", regRes["NCM"], err)
-			}
-			nStars = ncm * (1 + fPB)
-			
-		} else if regRes, err = Reg(inFileName); err == nil {			
-			if regRes["prefix"] != "out" {
-				log.Fatalf("Please specify a STDOUT file, found %v prefix", regRes["prefix"])
-			}
-			
-			log.Println("Can't derive deep info from name, only take the names and go standard for the rest")
-			
-			fileNameBody = regRes["baseName"]
-			rnd = regRes["rnd"]
-			ext = regRes["ext"]
-			temp, _ := strconv.ParseInt(rnd, 10, 64)
-			newRnd = strconv.Itoa(int(temp + 1))
-			
-			// Creating new filenames
-			newICsFileName = "ics-" + fileNameBody + "-run" + regRes["run"] + "-rnd" + LeftPad(newRnd, "0", 2) + ext
-			newErrFileName = "err-" + fileNameBody + "-run" + regRes["run"] + "-rnd" + LeftPad(newRnd, "0", 2) + ext
-			newOutFileName = "out-" + fileNameBody + "-run" + regRes["run"] + "-rnd" + LeftPad(newRnd, "0", 2) + ext
+// Out2ICsFile reads the STDOUT inFileName, writes the new ICs with the last
+// complete snapshot through plan and returns the information for
+// CreateStartScripts, empty if the simulation is complete. The report goes
+// to out, so that ForEachRun can read many STDOUTs at the same time; quiet
+// leaves out the messages for the terminal user.
+func Out2ICsFile(inFileName string, plan *Plan, out io.Writer, quiet bool) (map[string]string, error) {
+	var (
+		err                            error
+		newICsFileName                 string // new ICs file names
+		id, newID                      RunID  // run, round and cluster parameters from the name
+		named                          bool   // whether the name is standard, to update the state
+		ext                            string
+		snapshot                       *DumbSnapshot // last complete snapshot
+		lastTimestep                   string        // from the exit record, if any
+		simulationStop                 int64         // when to stop the simulation
+		thisTimestep, remainingTime    int64         // current timestep number and remaining timesteps to reach simulationStop
+		randomSeed                     string        // random seed from STDERR
+		runString                      string        // string to run the next round from terminal
+		newErrFileName, newOutFileName string        // new names from STDERR and STDOUT
+		units                          Units
+		parsed                         *Snapshot
+		endOfSim                       float64
+		endOfSimNBody                  bool
+		record                         *ExitRecord // written by kiraWrap, if any
+		kira                           *KiraProfile
+	)
 
-			// Default
-			length = float64(1)
-			nStars = float64(5500)
-			
-			fmt.Printf("Set default parameters for cluster: \n")
-			fmt.Printf("Radius: %v\n", length)
-			fmt.Printf("Number of stars: %v\n", nStars)
-		} else {
-			log.Println("Can't derive standard names or deep info from STDOUT => wrap it!!")
-			ext = filepath.Ext(inFileName)
-			newICsFileName = "ics-" + inFileName + ext
-			newErrFileName = "err-" + inFileName + ext
-			newOutFileName = "out-" + inFileName + ext
-			
-			// Default
-			length = float64(1)
-			nStars = float64(5500)
-			
-			fmt.Printf("Set default parameters for cluster: \n")
-			fmt.Printf("Radius: %v\n", length)
-			fmt.Printf("Number of stars: %v\n", nStars)
-		}
+	// 	simulationStop = 500
 
-		// Now simulation stop is calculated scaling that of the clusters of the first simlations
-		// with the formula
-		//
-		// maxTimeStep2 = ( maxTime / sqrt(timeUnit1**2 * (length2/length1)**3 * (m1 / m2)))
-		//
-		// with
-		//
-		// maxTime ~ 100 Myr
-		// timeUnit1 ~ 0.25 Myr
-		// length1 = 1 pc
-		// m1 / m2 approximated with the number of stars, so m2 = NCM * (1 + fPB) and m1 = 5500
-		// FIXME maybe leng should be timeUnit or something similar????
+	if endOfSim, endOfSimNBody, err = ParseSimTime(endOfSimMyrString); err != nil {
+		return nil, err
+	}
 
-		if endOfSimMyr, err = strconv.ParseFloat(endOfSimMyrString, 64); err != nil {
-			log.Fatal(err)
-		}
-		
-		lengthUnit = math.Sqrt(0.25*0.25*math.Pow(length, 3)*(5500./nStars))
-		simulationStop = 1 + int64(math.Floor(endOfSimMyr/lengthUnit))
-		fmt.Printf("\tApprox length unit: %2.2f || simulationStop: %v\n", lengthUnit, simulationStop)
+	if kira, err = LoadKiraProfile(ConfName); err != nil {
+		return nil, fmt.Errorf("kira profile: %v", err)
+	}
 
-		// Open infile, both text or gzip and create the reader
-		if !mute {
-			log.Println("Opening STDOUT file: ", inFileName)
-		}
-		if inFile, err = os.Open(inFileName); err != nil {
-			log.Fatal(err)
+	// Extract run, round and cluster parameters
+	if id, err = ParseRunID(inFileName); err == nil {
+		named = true
+		if id.Prefix != "out" {
+			return nil, fmt.Errorf("please specify a STDOUT file, found %v prefix in %v", id.Prefix, inFileName)
 		}
-		defer inFile.Close()
 
-		switch ext {
-		case ".txt":
-			{
-				nReader = bufio.NewReader(inFile)
-			}
-		case ".gz":
-			{
-				fZip, err = gzip.NewReader(inFile)
-				if err != nil {
-					log.Fatal("Can't open %s: error: %s\n", inFile, err)
-				}
-				nReader = bufio.NewReader(fZip)
-			}
-		default:
-			{
-				log.Println("Unrecognized file type", inFileName)
-				log.Fatal("with extension ", ext)
-			}
-		}
+		// Creating new filenames
+		newID = id.NextRound()
+		newICsFileName = newID.WithPrefix("ics").String()
+		newErrFileName = newID.WithPrefix("err").String()
+		newOutFileName = newID.String()
+	}
+
+	if !named {
+		log.Println("Can't derive standard names from STDOUT => wrap it!!")
+		ext = filepath.Ext(inFileName)
+		newICsFileName = "ics-" + inFileName + ext
+		newErrFileName = "err-" + inFileName + ext
+		newOutFileName = "out-" + inFileName + ext
+	}
+
+	// kira writes plain text, only the new ICs can be compressed
+	newErrFileName = TrimCodecExt(newErrFileName)
+	newOutFileName = TrimCodecExt(newOutFileName)
+	if newICsFileName, err = CompressedName(newICsFileName, compress); err != nil {
+		return nil, err
+	}
+
+	// kiraWrap tells which is the last complete snapshot
+	if record, err = ReadExitRecord(ExitRecordName(inFileName)); err == nil {
+		log.Printf("kiraWrap exit record: %v, last complete timestep %v\n", record.Reason, record.LastTimestep)
+		lastTimestep = record.LastTimestep
+	} else if !os.IsNotExist(err) {
+		log.Println("Can't read the exit record: ", err)
+	}
+
+	// Only the end of uncompressed files is read
+	if !quiet {
+		log.Println("Reading the last complete snapshot of STDOUT file: ", inFileName)
+	}
+	if snapshot, err = LastCompleteSnapshotUpTo(inFileName, lastTimestep); err != nil {
+		log.Println("No complete snapshot on file ", inFileName)
+		fmt.Fprintln(out, "Maybe your output file is empty")
+		return nil, fmt.Errorf("reading %v exit with error %v", inFileName, err)
+	}
+	fmt.Fprintln(out)
+	if !quiet {
+		log.Println("Done reading, last complete timestep is ", snapshot.Timestep)
+	}
+	thisTimestep, _ = strconv.ParseInt(snapshot.Timestep, 10, 64)
 
-		if !mute {
-			log.Println("Start reading...")
+	// The stop comes from the N-body units in the snapshot,
+	// the name only gives an approximation
+	if parsed, err = snapshot.Parse(); err == nil {
+		units, err = UnitsFromSnapshot(parsed)
+	}
+	if err != nil {
+		log.Println("Can't read the units from the snapshot, approximate them from the name: ", err)
+		if units, err = ApproxUnits(id); err != nil {
+			return nil, fmt.Errorf("can't find the units of %v: %v", inFileName, err)
 		}
-		// Read two snapshot each loop to ensure at least one of them is complete
-		// (= I keep the previous read in memory in case the last is corrupted)
-		for {
-			if snapshots[0], err = ReadOutSnapshot(nReader); err != nil {
-				break
-			}
-			if snapshots[1], err = ReadOutSnapshot(nReader); err != nil {
-				break
-			}
+	}
+	simulationStop = units.StopTimestep(endOfSim, endOfSimNBody)
+	fmt.Fprintf(out, "\t%v || simulationStop: %v (%2.2f Myr)\n", units, simulationStop, units.Myr(float64(simulationStop)))
+	remainingTime = simulationStop - thisTimestep
+
+	// Write last complete snapshot to file
+	if !force && remainingTime < 1 {
+		fmt.Fprintln(out, "\tNo need to create a new ICs, simulation complete.")
+		if named {
+			planCompleteState(plan, id, inFileName, snapshot.Timestep)
 		}
+		return map[string]string{}, nil // empty map if no need to create css scripts
+	}
+	// Create the new ICs file
+	if !quiet {
+		fmt.Fprintln(out, "Creating new ICs file ", newICsFileName)
+	}
+	fmt.Fprintln(out, "\tWriting snapshot to ", newICsFileName)
+	if err = planICs(plan, snapshot, newICsFileName, inFileName); err != nil {
+		return nil, err
+	}
+	fmt.Fprintln(out, "\tSet -t flag to ", remainingTime)
+
+	if !quiet {
+		fmt.Fprint(os.Stderr, "\n")
+	}
+	if !quiet {
+		log.Println("Search for random seed...")
+	}
+	if record != nil && record.RandomSeed != "" {
+		randomSeed = record.RandomSeed
+	} else if randomSeed, err = ReadRandomSeed("err" + strings.TrimPrefix(inFileName, "out")); err != nil {
+		return nil, err
+	}
+	fmt.Fprintln(out, "\tSet -s flag to ", randomSeed)
+
+	// Record the end of this round and the start of the next one
+	if named {
+		planRoundState(plan, id, newID, inFileName, newICsFileName, randomSeed, snapshot.Timestep)
+	}
+
+	runString = "\nYou can run the new round from the terminal with:\n" +
+		"----------------------\n" +
+		"(" + kira.CommandLine(strconv.Itoa(int(remainingTime)), randomSeed, newICsFileName, newOutFileName, newErrFileName) + ")& \n" +
+		"\nor\n\n" +
+		"($HOME/bin/kiraWrap " + "-i " + newICsFileName + " -t " +
+		strconv.Itoa(int(remainingTime)) + " -s " +
+		randomSeed + ")\n\n" +
+		"----------------------\n\n" +
+		"You can watch the status of the simulation by running: \n" +
+		"----------------------\n" +
+		"watch stat " + newErrFileName + "\n" +
+		"----------\n" +
+		"cat " + newErrFileName + ` | grep "Time = " | tail -n 1` + "\n" +
+		"----------------------\n"
+
+	if !quiet {
+		fmt.Fprintln(out, runString)
+	}
+	fmt.Fprintln(out)
+
+	return map[string]string{
+		"remainingTime":  strconv.Itoa(int(remainingTime)),
+		"randomSeed":     randomSeed,
+		"newICsFileName": newICsFileName,
+	}, nil
+}
 
-		// Check integrity once the file reading is ended
-		// First the last read, then the previous one
-		if snapshots[1].Integrity == true {
-			snpN = 1
-		} else if snapshots[0].Integrity == true {
-			snpN = 0
-		} else {
-			log.Println("Both last two snapshots corrupted on file ", inFileName)
-			fmt.Println("Snapshot ", snapshots[1].Timestep, " is ", snapshots[1].Integrity)
-			fmt.Println("Snapshot ", snapshots[0].Timestep, " is ", snapshots[0].Integrity)
-			fmt.Println("Maybe your output file is empty")
-			log.Fatal("Reading exit with error ", err)
+// planICs plans writing snap to the new ICs icsName and validating them,
+// so that a broken snapshot doesn't go to the queue. The plan only keeps
+// outName and the timestep, the snapshot is read again when the plan is
+// executed so that the snapshots of all the runs are not in memory.
+func planICs(plan *Plan, snap *DumbSnapshot, icsName, outName string) error {
+	var timestep = snap.Timestep
+	if plan == nil {
+		return writeICs(snap, icsName)
+	}
+	return plan.Add(ActionWrite, icsName, "", "ICs from timestep "+timestep+" of "+outName, func() error {
+		snap, err := LastCompleteSnapshotUpTo(outName, timestep)
+		if err != nil {
+			return fmt.Errorf("can't read timestep %v of %v again: %v", timestep, outName, err)
 		}
-		// Info
-		fmt.Println() // To leave a space after the non verbose print
-		if !mute {
-			log.Println("Done reading, last complete timestep is ", snapshots[snpN].Timestep)
+		if snap.Timestep != timestep {
+			return fmt.Errorf("%v changed, last complete timestep %v instead of %v", outName, snap.Timestep, timestep)
 		}
-		thisTimestep, _ = strconv.ParseInt(snapshots[snpN].Timestep, 10, 64)
-		remainingTime = simulationStop - thisTimestep
+		return writeICs(snap, icsName)
+	})
+}
 
-		// Write last complete snapshot to file
-		if !force && remainingTime < 1 {
-			fmt.Println("\tNo need to create a new ICs, simulation complete.")
-			cssInfo <- map[string]string{} // empty map if no need to create css scripts
-			continue
-		} else {
-			// Create the new ICs file
-			if !mute {
-				fmt.Println("Creating new ICs file ", newICsFileName)
-			}
-			if newICsFile, err = os.Create(newICsFileName); err != nil {
-				log.Fatal(err)
-			}
-			defer newICsFile.Close()
-			nWriter = bufio.NewWriter(newICsFile)
-			defer nWriter.Flush()
+// writeICs writes snap to the new ICs icsName and validates them.
+func writeICs(snap *DumbSnapshot, icsName string) error {
+	var (
+		icsFile *StdWriter
+		expect  = ICsExpectFromName(icsName)
+		report  *ICsReport
+		err     error
+	)
+	if icsFile, err = CreateStd(icsName); err != nil {
+		return err
+	}
+	if err = snap.WriteSnapshot(icsFile.Writer); err != nil {
+		icsFile.Close()
+		return fmt.Errorf("error while writing snapshot to file: %v", err)
+	}
+	if err = icsFile.Close(); err != nil {
+		return err
+	}
+	expect.Fresh = false
+	if report, err = ValidateICs(icsName, expect); err != nil {
+		return fmt.Errorf("can't validate: %v", err)
+	}
+	return report.Err()
+}
 
-			fmt.Println("\tWriting snapshot to ", newICsFileName)
-			if err = snapshots[snpN].WriteSnapshot(nWriter); err != nil {
-				log.Fatal("Error while writing snapshot to file: ", err)
+// planCompleteState plans recording in the state that the run of id is
+// complete, with the last timestep of the round if not empty.
+func planCompleteState(plan *Plan, id RunID, outName, endTimestep string) {
+	plan.Add(ActionState, filepath.Join(filepath.Dir(outName), StateFileName), "", "run "+id.RunStr()+" complete", func() error {
+		if err := UpdateState(filepath.Dir(outName), func(db *StateDB) error {
+			if endTimestep != "" {
+				db.Round(id).EndTimestep = endTimestep
 			}
-			fmt.Println("\tSet -t flag to ", remainingTime)
+			db.Run(id).Complete = true
+			return nil
+		}); err != nil {
+			log.Println("Can't update the state: ", err)
 		}
+		return nil
+	})
+}
 
-		if !mute {
-			fmt.Fprint(os.Stderr, "\n")
+// planRoundState plans recording the end of the round of id and the start
+// of the next one, newID.
+func planRoundState(plan *Plan, id, newID RunID, outName, icsName, randomSeed, timestep string) {
+	plan.Add(ActionState, filepath.Join(filepath.Dir(outName), StateFileName), "", "round "+strconv.Itoa(newID.Round)+" of run "+id.RunStr(), func() error {
+		var (
+			outInfo os.FileInfo
+			icsSum  string
+			err     error
+		)
+		// Out of the lock, kiraWrap and the other workers update the state too
+		if outInfo, err = os.Stat(outName); err != nil {
+			log.Println("Can't update the state: ", err)
+			return nil
 		}
-		if !mute {
-			log.Println("Search for random seed...")
+		if icsSum, err = FileChecksum(icsName); err != nil {
+			log.Println("Can't update the state: ", err)
+			return nil
 		}
-		randomSeed = DetectRandomSeed(inFileName)
-		fmt.Println("\tSet -s flag to ", randomSeed)
-
-		cssInfo <- map[string]string{
-			"remainingTime":  strconv.Itoa(int(remainingTime)),
-			"randomSeed":     randomSeed,
-			"newICsFileName": newICsFileName,
-		}
-
-		runString = "\nYou can run the new round from the terminal with:\n" +
-			"----------------------\n" +
-			"(" + os.Getenv("HOME") + "/bin/kira -t " +
-			strconv.Itoa(int(remainingTime)) +
-			" -d 1 -D 1 -b 1 -f 0 " +
-			"-n 10 -e 0.000 -B -s " + randomSeed +
-			" < " + newICsFileName + " >  " + newOutFileName + " 2> " + newErrFileName + ")& \n" +
-			"\nor\n\n" +
-			"($HOME/bin/kiraWrap " + "-i " + newICsFileName + " -t " +
-			strconv.Itoa(int(remainingTime)) + " -s " +
-			randomSeed + ")\n\n" +
-			"----------------------\n\n" +
-			"You can watch the status of the simulation by running: \n" +
-			"----------------------\n" +
-			"watch stat " + newErrFileName + "\n" +
-			"----------\n" +
-			"cat " + newErrFileName + ` | grep "Time = " | tail -n 1` + "\n" +
-			"----------------------\n"
-
-		if !mute {
-			fmt.Println(runString)
+		if err = UpdateState(filepath.Dir(outName), func(db *StateDB) error {
+			round := db.Round(id)
+			if round.ICs == "" {
+				round.ICs = id.WithPrefix("ics").String()
+			}
+			round.Out = filepath.Base(outName)
+			round.Err = id.WithPrefix("err").String()
+			round.EndTimestep = timestep
+			round.OutSize, round.OutModTime = outInfo.Size(), outInfo.ModTime()
+			next := db.Round(newID)
+			next.ICs = filepath.Base(icsName)
+			next.RandomSeed = randomSeed
+			next.StartTimestep = timestep
+			next.SetChecksum(icsName, icsSum)
+			return nil
+		}); err != nil {
+			log.Println("Can't update the state: ", err)
 		}
-		fmt.Println()
-	}
-	close(cssInfo)
-	// 	done <- struct{}{}
+		return nil
+	})
 }
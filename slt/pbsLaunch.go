@@ -1,11 +1,8 @@
 package slt
 
 import (
-	"bytes"
-	"errors"
 	"fmt"
 	"log"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"sort"
@@ -14,7 +11,16 @@ import (
 	"github.com/brunetto/goutils/debug"
 )
 
+// PbsLaunch submits all the job scripts in the folder.
+// The scripts keep the historical PBS prefix whatever the scheduler.
 func PbsLaunch () (error) {
+	var plan = NewPlan("pbsLaunch")
+	PlanPbsLaunch(plan)
+	return plan.Run()
+}
+
+// PlanPbsLaunch adds the submissions of PbsLaunch to plan.
+func PlanPbsLaunch (plan *Plan) () {
 	if Debug {
 		defer debug.TimeMe(time.Now())
 	}
@@ -30,9 +36,8 @@ func PbsLaunch () (error) {
 		err error
 		keys []string
 		key string
-		pbsCmd *exec.Cmd
 		exists bool
-		stdo, stde bytes.Buffer 
+		scheduler = GetScheduler()
 	)
 	
 	log.Println("Searching for files in the form: ", globName)
@@ -61,28 +66,28 @@ func PbsLaunch () (error) {
     sort.Strings(keys)
 	
 	for _, key = range keys {
-		pbsCmd = exec.Command("qsub", pbsFiles[key])
-		if pbsCmd.Stdout = &stdo; err != nil {log.Fatal("Error connecting STDOUT: ", err)}
-		if pbsCmd.Stderr = &stde; err != nil {log.Fatal("Error connecting STDERR: ", err)}
-		if err = pbsCmd.Start(); err != nil {
-			log.Fatal("Error starting pbsCmd: ", err)
-		}
-		log.Println("Execute ", "qsub ", pbsFiles[key])
-		if err = pbsCmd.Wait(); err != nil {
-			log.Fatal("Error while waiting for pbsCmd: ", err)
-		}
-		fmt.Println(stdo.String())
-		fmt.Println(stde.String())
-		if stde.Len() != 0 {
-			return errors.New(stde.String())
-		}
-		stdo.Reset()
-		stde.Reset()
+		planSubmit(plan, scheduler, pbsFiles[key])
 	}
-	return nil
 }
 
-func PbsLaunchOnTheFly (pbsLaunchChannel chan string, done chan struct{}) (error) {
+// planSubmit plans the submission of the job script pbsFile and its
+// record in the state.
+func planSubmit (plan *Plan, scheduler Scheduler, pbsFile string) (error) {
+	return plan.Add(ActionSubmit, pbsFile, "", "submit to "+scheduler.Name(), func() error {
+		log.Println("Submit ", pbsFile, " to ", scheduler.Name())
+		jobID, err := scheduler.Submit(pbsFile)
+		if err != nil {
+			return err
+		}
+		fmt.Println("\t" + jobID)
+		RecordSubmission(pbsFile, jobID)
+		return nil
+	})
+}
+
+// PbsLaunchOnTheFly submits the job scripts as they arrive from CreateStartScripts,
+// through plan or right away if nil.
+func PbsLaunchOnTheFly (pbsLaunchChannel chan string, done chan struct{}, plan *Plan) (error) {
 	if Debug {
 		defer debug.TimeMe(time.Now())
 	}
@@ -90,8 +95,7 @@ func PbsLaunchOnTheFly (pbsLaunchChannel chan string, done chan struct{}) (error
 	var (
 		pbsFile string
 		err error
-		pbsCmd *exec.Cmd
-		stdo, stde bytes.Buffer 
+		scheduler = GetScheduler()
 	)
 	
 	for pbsFile = range pbsLaunchChannel {
@@ -99,23 +103,9 @@ func PbsLaunchOnTheFly (pbsLaunchChannel chan string, done chan struct{}) (error
 			done <- struct{}{}
 			continue
 		} // complete simulation, no need for a new run
-		pbsCmd = exec.Command("qsub", pbsFile)
-		if pbsCmd.Stdout = &stdo; err != nil {log.Fatal("Error connecting STDOUT: ", err)}
-		if pbsCmd.Stderr = &stde; err != nil {log.Fatal("Error connecting STDERR: ", err)}
-		if err = pbsCmd.Start(); err != nil {
-			log.Fatal("Error starting pbsCmd: ", err)
-		}
-		fmt.Println("\tExecute ", "qsub ", pbsFile)
-		if err = pbsCmd.Wait(); err != nil {
-			log.Fatal("Error while waiting for pbsCmd: ", err)
-		}
-		fmt.Println("\t" + stdo.String())
-		fmt.Println("\t" + stde.String())
-		if stde.Len() != 0 {
-			return errors.New(stde.String())
+		if err = planSubmit(plan, scheduler, pbsFile); err != nil {
+			return err
 		}
-		stdo.Reset()
-		stde.Reset()
 		done <- struct{}{}
 	}
 	return nil
@@ -0,0 +1,224 @@
+package slt
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// DryRun makes the commands changing the folder (cac, simClean, relaunch,
+// pbsLaunch, autosim) print their plan instead of executing it.
+var DryRun bool
+
+// PlanFormat is how the plan is printed with DryRun: table or json.
+var PlanFormat = "table"
+
+// AddPlanFlags adds --dry-run and --plan-format to the flags of the
+// binaries using the flag package, i.e. AddPlanFlags(flag.CommandLine).
+func AddPlanFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&DryRun, "dry-run", false, "Print the plan without changing anything")
+	flags.StringVar(&PlanFormat, "plan-format", "table", "Format of the plan: table or json")
+}
+
+// Kinds of Action.
+const (
+	ActionMkdir  = "mkdir"
+	ActionRemove = "remove"
+	ActionRename = "rename"
+	// ActionWrite creates or overwrites a file: new ICs, scripts, ...
+	ActionWrite  = "write"
+	ActionState  = "state"
+	ActionSubmit = "submit"
+)
+
+// Action is a change of the folder.
+type Action struct {
+	Kind string
+	Path string
+	// Target is the new name of a rename.
+	Target string `json:",omitempty"`
+	Reason string `json:",omitempty"`
+	// Run is the run the action belongs to, empty if it is about the
+	// whole folder.
+	Run string `json:",omitempty"`
+	do  func() error
+}
+
+// Plan lists the actions of a command. The command first adds all the
+// actions looking at the folder without changing it, then the plan is
+// printed (DryRun) or executed. A nil Plan executes the actions as they
+// are added, for the commands that don't plan.
+type Plan struct {
+	Command string
+	Actions []*Action
+	mutex   sync.Mutex
+	// dirs are the folders the plan creates, not to create them twice.
+	dirs map[string]bool
+	// run tags the actions added, see ForRun.
+	run string
+}
+
+// NewPlan returns an empty plan for command.
+func NewPlan(command string) *Plan {
+	return &Plan{Command: command, Actions: []*Action{}, dirs: map[string]bool{}}
+}
+
+// Add appends an action doing do, or runs do now if plan is nil.
+func (plan *Plan) Add(kind, path, target, reason string, do func() error) error {
+	if plan == nil {
+		return do()
+	}
+	plan.mutex.Lock()
+	defer plan.mutex.Unlock()
+	plan.Actions = append(plan.Actions, &Action{Kind: kind, Path: path, Target: target, Reason: reason, Run: plan.run, do: do})
+	return nil
+}
+
+// ForRun tags the actions added from now on, merged ones included, with
+// run, empty for the actions about the whole folder. When an action of a
+// run fails, Execute skips the other actions of that run only.
+func (plan *Plan) ForRun(run string) {
+	if plan == nil {
+		return
+	}
+	plan.mutex.Lock()
+	defer plan.mutex.Unlock()
+	plan.run = run
+}
+
+// Mkdir plans the creation of dir, once.
+func (plan *Plan) Mkdir(dir, reason string) error {
+	if plan != nil {
+		plan.mutex.Lock()
+		planned := plan.dirs[dir]
+		plan.dirs[dir] = true
+		plan.mutex.Unlock()
+		if planned {
+			return nil
+		}
+	}
+	return plan.Add(ActionMkdir, dir, "", reason, func() error {
+		return os.Mkdir(dir, 0700)
+	})
+}
+
+// Remove plans the removal of path.
+func (plan *Plan) Remove(path, reason string) error {
+	return plan.Add(ActionRemove, path, "", reason, func() error {
+		return os.Remove(path)
+	})
+}
+
+// Rename plans moving path to target.
+func (plan *Plan) Rename(path, target, reason string) error {
+	return plan.Add(ActionRename, path, target, reason, func() error {
+		return os.Rename(path, target)
+	})
+}
+
+// WriteFile plans writing content to path.
+func (plan *Plan) WriteFile(path, content, reason string) error {
+	return plan.Add(ActionWrite, path, "", reason, func() error {
+		return ioutil.WriteFile(path, []byte(content), 0666)
+	})
+}
+
+// Sub returns an empty plan for a job of ForEachRun, to be merged in the
+// order of the items, nil if plan is nil.
+func (plan *Plan) Sub() *Plan {
+	if plan == nil {
+		return nil
+	}
+	return NewPlan(plan.Command)
+}
+
+// Merge appends the actions of sub, the folders already planned aren't
+// created twice. The actions of sub without a run get the one of plan.
+func (plan *Plan) Merge(sub *Plan) {
+	if plan == nil || sub == nil {
+		return
+	}
+	for _, action := range sub.Actions {
+		if action.Kind == ActionMkdir {
+			plan.mutex.Lock()
+			planned := plan.dirs[action.Path]
+			plan.dirs[action.Path] = true
+			plan.mutex.Unlock()
+			if planned {
+				continue
+			}
+		}
+		plan.mutex.Lock()
+		merged := *action
+		if merged.Run == "" {
+			merged.Run = plan.run
+		}
+		plan.Actions = append(plan.Actions, &merged)
+		plan.mutex.Unlock()
+	}
+}
+
+// Execute runs the actions in order. A failure doesn't stop the plan:
+// the following actions of the same run are skipped, i.e. a run with
+// invalid ICs is not submitted, the others go on. The error lists all
+// the failures.
+func (plan *Plan) Execute() error {
+	var (
+		failed = map[string]bool{}
+		errs   = []string{}
+	)
+	for _, action := range plan.Actions {
+		if action.Run != "" && failed[action.Run] {
+			continue
+		}
+		if err := action.do(); err != nil {
+			if action.Run != "" {
+				failed[action.Run] = true
+				errs = append(errs, fmt.Sprintf("run %v: %v %v: %v, the rest of the run is skipped", action.Run, action.Kind, action.Path, err))
+			} else {
+				errs = append(errs, fmt.Sprintf("%v %v: %v", action.Kind, action.Path, err))
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%v out of %v actions failed:\n%v", len(errs), len(plan.Actions), strings.Join(errs, "\n"))
+}
+
+// Print writes the plan as a table or as JSON.
+func (plan *Plan) Print(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		content, err := json.MarshalIndent(plan, "", "\t")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(content))
+		return err
+	case "table", "":
+		fmt.Fprintf(w, "Plan of %v, %v actions:\n", plan.Command, len(plan.Actions))
+		tab := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tab, "KIND\tPATH\tTARGET\tRUN\tREASON")
+		for _, action := range plan.Actions {
+			fmt.Fprintf(tab, "%v\t%v\t%v\t%v\t%v\n", action.Kind, action.Path, action.Target, action.Run, action.Reason)
+		}
+		return tab.Flush()
+	default:
+		return fmt.Errorf("unknown plan format %v, use table or json", format)
+	}
+}
+
+// Run prints the plan with DryRun, executes it otherwise.
+func (plan *Plan) Run() error {
+	if DryRun {
+		return plan.Print(os.Stdout, PlanFormat)
+	}
+	return plan.Execute()
+}
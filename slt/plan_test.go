@@ -0,0 +1,215 @@
+package slt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// recordingPlan returns a plan whose actions append their path to done
+// and fail if listed in fail.
+func recordingPlan(done *[]string, fail map[string]bool, actions ...[2]string) *Plan {
+	var plan = NewPlan("test")
+	for _, action := range actions {
+		run, path := action[0], action[1]
+		plan.ForRun(run)
+		plan.Add(ActionWrite, path, "", "test", func() error {
+			*done = append(*done, path)
+			if fail[path] {
+				return errors.New("failed")
+			}
+			return nil
+		})
+	}
+	plan.ForRun("")
+	return plan
+}
+
+func TestPlanExecute(t *testing.T) {
+	var (
+		actions = [][2]string{
+			{"", "folder"},
+			{"run00", "ics00"}, {"run00", "pbs00"},
+			{"run01", "ics01"}, {"run01", "pbs01"},
+			{"", "state"},
+		}
+		tests = []struct {
+			name   string
+			fail   []string
+			done   []string
+			errors int
+		}{
+			{"all fine", nil, []string{"folder", "ics00", "pbs00", "ics01", "pbs01", "state"}, 0},
+			{"run fails", []string{"ics00"}, []string{"folder", "ics00", "ics01", "pbs01", "state"}, 1},
+			{"folder fails", []string{"folder"}, []string{"folder", "ics00", "pbs00", "ics01", "pbs01", "state"}, 1},
+			{"both runs fail", []string{"pbs00", "ics01"}, []string{"folder", "ics00", "pbs00", "ics01", "state"}, 2},
+		}
+	)
+	for _, test := range tests {
+		var (
+			done []string
+			fail = map[string]bool{}
+		)
+		for _, path := range test.fail {
+			fail[path] = true
+		}
+		err := recordingPlan(&done, fail, actions...).Execute()
+		if !reflect.DeepEqual(done, test.done) {
+			t.Errorf("%v: executed %v, want %v", test.name, done, test.done)
+		}
+		if test.errors == 0 {
+			if err != nil {
+				t.Errorf("%v: error %v", test.name, err)
+			}
+			continue
+		}
+		if err == nil || strings.Count(err.Error(), "\n") != test.errors {
+			t.Errorf("%v: error %v, want %v failures", test.name, err, test.errors)
+		}
+	}
+}
+
+func TestPlanMerge(t *testing.T) {
+	var (
+		plan = NewPlan("test")
+		sub  = plan.Sub()
+	)
+	plan.Mkdir("Rounds", "")
+	sub.Mkdir("Rounds", "")
+	sub.Remove("old", "")
+	sub.ForRun("run02")
+	sub.Remove("older", "")
+	plan.ForRun("run01")
+	plan.Merge(sub)
+	if len(plan.Actions) != 3 {
+		t.Fatalf("%v actions, the folder is created twice?", len(plan.Actions))
+	}
+	if plan.Actions[1].Run != "run01" || plan.Actions[2].Run != "run02" {
+		t.Errorf("runs %v and %v, want run01 and run02", plan.Actions[1].Run, plan.Actions[2].Run)
+	}
+}
+
+func TestPlanNil(t *testing.T) {
+	var (
+		plan *Plan
+		dir  = t.TempDir()
+		path = filepath.Join(dir, "file.txt")
+	)
+	plan.ForRun("run00")
+	if err := plan.WriteFile(path, "content", ""); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := ioutil.ReadFile(path); err != nil || string(content) != "content" {
+		t.Errorf("a nil plan doesn't write right away: %q, %v", content, err)
+	}
+	if err := plan.Rename(path, path+".old", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".old"); err != nil {
+		t.Error(err)
+	}
+	if plan.Sub() != nil {
+		t.Error("the Sub of a nil plan should be nil")
+	}
+}
+
+func TestPlanPrint(t *testing.T) {
+	var (
+		plan = NewPlan("test")
+		out  bytes.Buffer
+		read struct {
+			Command string
+			Actions []map[string]string
+		}
+	)
+	plan.Mkdir("Rounds", "keep the old rounds")
+	plan.ForRun("run00")
+	plan.Rename("out-run00.txt", "Rounds/out-run00.txt", "")
+
+	if err := plan.Print(&out, "table"); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 || lines[0] != "Plan of test, 2 actions:" || strings.Fields(lines[1])[3] != "RUN" {
+		t.Fatalf("table:\n%v", out.String())
+	}
+	if fields := strings.Fields(lines[3]); !reflect.DeepEqual(fields, []string{"rename", "out-run00.txt", "Rounds/out-run00.txt", "run00"}) {
+		t.Errorf("rename row %v", fields)
+	}
+
+	out.Reset()
+	if err := plan.Print(&out, "json"); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(out.Bytes(), &read); err != nil {
+		t.Fatal(err)
+	}
+	if read.Command != "test" || len(read.Actions) != 2 {
+		t.Fatalf("json: %v", out.String())
+	}
+	if !reflect.DeepEqual(read.Actions[0], map[string]string{"Kind": "mkdir", "Path": "Rounds", "Reason": "keep the old rounds"}) {
+		t.Errorf("empty fields should be omitted: %v", read.Actions[0])
+	}
+	if read.Actions[1]["Run"] != "run00" || read.Actions[1]["Target"] != "Rounds/out-run00.txt" {
+		t.Errorf("json rename %v", read.Actions[1])
+	}
+
+	if err := plan.Print(&out, "yaml"); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
+
+func TestPlanPbsLaunch(t *testing.T) {
+	var (
+		fake    = NewFakeScheduler()
+		plan    = NewPlan("pbsLaunch")
+		dir     = t.TempDir()
+		wd, _   = os.Getwd()
+		scripts = []string{
+			"PBS-comb16-TFno-Rv1-NCM10000-fPB005-W5-Z010-run01-rnd02.sh",
+			"PBS-comb16-TFno-Rv1-NCM10000-fPB005-W5-Z010-run00-rnd01.sh",
+		}
+		db    *StateDB
+		err   error
+		round *RoundState
+	)
+	SetScheduler(fake)
+	defer SetScheduler(nil)
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	for _, script := range scripts {
+		if err = ioutil.WriteFile(script, []byte("#!/bin/bash\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	PlanPbsLaunch(plan)
+	if len(plan.Actions) != 2 || len(fake.Submitted) != 0 {
+		t.Fatalf("planning submitted %v, %v actions", fake.Submitted, len(plan.Actions))
+	}
+	if err = plan.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	// Sorted by run
+	if !reflect.DeepEqual(fake.Submitted, []string{scripts[1], scripts[0]}) {
+		t.Errorf("submitted %v", fake.Submitted)
+	}
+	if db, err = ReadState("."); err != nil {
+		t.Fatal(err)
+	}
+	for idx, script := range []string{scripts[1], scripts[0]} {
+		id, _ := ParseRunID(script)
+		if round = db.LastRound(id); round == nil || round.JobID != fmt.Sprintf("fake-%v", idx+1) {
+			t.Errorf("%v recorded as %+v", script, round)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package slt
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -9,7 +8,6 @@ import (
 	"os"
 	"strconv"
 	"strings"
-	"text/template"
 	"time"
 
 	"github.com/brunetto/goutils/debug"
@@ -35,6 +33,24 @@ type ConfigStruct struct {
 	BinFolder string
 	RunICC bool
 	FileName string
+	// Naming is the file naming scheme, DefaultNaming if not set.
+	Naming *NamingScheme
+	// Params are the values of the extra fields of the naming scheme.
+	Params map[string]string
+	// Scheduler is the batch system: pbs (default), slurm, local or fake.
+	// The --scheduler flag takes precedence.
+	Scheduler string
+	// Templates override the built-in and the machine scripts.
+	Templates *ScriptTemplates
+	// KiraLimits are the thresholds of kiraWrap, the flags win.
+	KiraLimits *KiraLimits
+	// Kira is the kira profile: binary and arguments.
+	Kira *KiraProfile
+	// ICsPipeline are the programs creating the ICs, DefaultICsPipeline if not set.
+	ICsPipeline []PipelineStage
+	// Seed is the master seed the seeds of the ICs and of the first kira
+	// round are derived from, 0 to let the programs choose.
+	Seed int64
 }
 
 // ReadConf load configuration parameters for this set of runs forom a json file.
@@ -88,9 +104,25 @@ func (conf *ConfigStruct) ReadConf(confName string) {
 	if conf.EndTime <= 0 {
 		log.Fatal("EndTime field in configuation file is empty, zero or negative")
 	}
+	if conf.Seed < 0 {
+		log.Fatal("Seed field in configuation file is negative")
+	}
 	if len(conf.Tf) == 0 {
 		conf.Tf = "no"
 	}
+	if conf.Naming != nil {
+		if err = conf.Naming.Compile(); err != nil {
+			log.Fatal("Naming field in configuation file: ", err)
+		}
+		for _, field := range conf.Naming.Fields {
+			if _, builtin := builtinNameFields[field.Name]; builtin {
+				continue
+			}
+			if _, ok := conf.Params[field.Name]; !ok {
+				log.Fatalf("Naming field %v has no value in the Params field of configuation file", field.Name)
+			}
+		}
+	}
 	fmt.Println("OK!")
 }
 
@@ -170,34 +202,15 @@ func (conf *ConfigStruct) EndTimeStr() string {
 }
 
 // BaseNameprovides the basename for all the files here
-// by filling the basename template with the configuation parameter.
-// Not the best way but just to try the template package!:P
+// by filling the naming scheme with the configuation parameter.
 func (conf *ConfigStruct) BaseName() string {
 	if Debug {
 		defer debug.TimeMe(time.Now())
 	}
-
-	var baseName string
-	// baseName string
-	baseName = "comb{{.CombStr}}" +
-		"-TF{{.Tf}}" +
-		"-Rv{{.RvStr}}" +
-		"-NCM{{.NcmStr}}" +
-		"-fPB{{.FpbCmpStr}}" +
-		"-W{{.WStr}}" +
-		"-Z{{.ZCmpStr}}"
-	// template to be filled
-	var baseTmpl *template.Template = template.Must(template.New("baseNameTmpl").Parse(baseName))
-	// buffer to write into
-	var buf bytes.Buffer
-	// execute the template and check for errors
-	if err := baseTmpl.Execute(&buf, conf); err != nil {
-		log.Println("Error while creating basename in conf.BaseName:", err)
-	}
-	// copy the buf content in a string
-	ret := buf.String()
-	buf.Reset() // reset the buffer just to be sure
-	return ret
+	if conf.Naming != nil {
+		return conf.Naming.Format(conf.RunID(0))
+	}
+	return Naming.Format(conf.RunID(0))
 }
 
 // InitVars provide the configuration struct to the package.
@@ -231,6 +244,10 @@ func InitVars(ConfName string) *ConfigStruct {
 	}
 	conf.RunICC = false // default
 	conf.FileName = ConfName
+	// All the names from now on follow the scheme of this configuration
+	if conf.Naming != nil {
+		Naming = conf.Naming
+	}
 	// Return a pointer to the new configuration structure
 	return conf
 }
@@ -0,0 +1,233 @@
+package slt
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+// runIDReg is the legacy fallback for names that don't follow the
+// naming scheme, like out-cineca-comb16-NCM10000-fPB005-W5-Z010-run06-rnd00.txt
+var runIDReg = regexp.MustCompile(`(\w{3})-(\S*comb(\S*?)-\S*)-run(\d*)-[a-z]*(\d*)(\.\S+\.*\S*)`)
+
+// RunID identifies a file of a simulation round: what it is (Prefix),
+// which cluster (Comb ... Z), which realization (Run), which restart (Round)
+// and its format (Ext).
+// Field names follow ConfigStruct and the NameField names.
+type RunID struct {
+	// Prefix is the kind of file: "ics", "out", "err", ...
+	Prefix string
+	// Head is whatever comes before the naming template, i.e. "cineca-".
+	Head string
+	Comb int
+	Tf   string
+	Rv   int
+	Ncm  int
+	Fpb  float64
+	W    int
+	Z    float64
+	// Params are the extra parameters of the naming scheme.
+	Params map[string]string
+	Run    int
+	// Round is the restart number, the "rnd" part of the name.
+	Round int
+	// Ext is the extension, dot included, i.e. ".txt" or ".txt.gz".
+	Ext string
+	// Full is true if the name follows the naming scheme, otherwise
+	// only Prefix, Comb, Run, Round and Ext are meaningful and the
+	// base name is kept as it was.
+	Full bool
+	body string
+}
+
+// ParseRunID extracts the run information from a file name.
+// Names following Naming are preferred, older names like
+// out-cineca-comb16-NCM10000-fPB005-W5-Z010-run06-rnd00.txt
+// are accepted with Full set to false.
+func ParseRunID(fileName string) (RunID, error) {
+	var (
+		id     RunID
+		res    []string
+		err    error
+		tmpInt int64
+	)
+	fileName = filepath.Base(fileName)
+
+	if id, err = Naming.Parse(fileName); err == nil {
+		return id, nil
+	}
+
+	if res = runIDReg.FindStringSubmatch(fileName); res != nil {
+		id = RunID{Prefix: res[1], Ext: res[6], body: res[2]}
+		for _, field := range []struct {
+			dst *int
+			str string
+		}{{&id.Comb, res[3]}, {&id.Run, res[4]}, {&id.Round, res[5]}} {
+			if tmpInt, err = strconv.ParseInt(field.str, 10, 64); err != nil {
+				return RunID{}, fmt.Errorf("can't extract run info from %v: %v", fileName, err)
+			}
+			*field.dst = int(tmpInt)
+		}
+		return id, nil
+	}
+
+	return RunID{}, fmt.Errorf("can't extract run info from %v", fileName)
+}
+
+// parseCompact reads the compact form of a fraction used in the names,
+// i.e. "005" for 0.05.
+func parseCompact(str string) (float64, error) {
+	if len(str) == 0 {
+		return 0, fmt.Errorf("empty value")
+	}
+	return strconv.ParseFloat(str[:1]+"."+str[1:], 64)
+}
+
+// RunID returns the identifier of the first round of realization run
+// of the simulations described by the configuration.
+func (conf *ConfigStruct) RunID(run int) RunID {
+	return RunID{
+		Comb:   conf.Comb,
+		Tf:     conf.Tf,
+		Rv:     conf.Rv,
+		Ncm:    conf.Ncm,
+		Fpb:    conf.Fpb,
+		W:      conf.W,
+		Z:      conf.Z,
+		Params: conf.Params,
+		Run:    run,
+		Ext:    ".txt",
+		Full:   true,
+	}
+}
+
+// BaseName returns the part of the name shared by all the runs of the
+// cluster, the same given by ConfigStruct.BaseName.
+func (id RunID) BaseName() string {
+	if !id.Full {
+		return id.body
+	}
+	return id.Head + Naming.Format(id)
+}
+
+// Value returns the value of a naming field as written in the configuration.
+func (id RunID) Value(name string) string {
+	switch name {
+	case "Comb":
+		return strconv.Itoa(id.Comb)
+	case "Tf":
+		return id.Tf
+	case "Rv":
+		return strconv.Itoa(id.Rv)
+	case "Ncm":
+		return strconv.Itoa(id.Ncm)
+	case "Fpb":
+		return strconv.FormatFloat(id.Fpb, 'f', -1, 64)
+	case "W":
+		return strconv.Itoa(id.W)
+	case "Z":
+		return strconv.FormatFloat(id.Z, 'f', -1, 64)
+	}
+	return id.Params[name]
+}
+
+// SetValue sets a naming field from its value as written in the configuration.
+func (id *RunID) SetValue(name, value string) (err error) {
+	switch name {
+	case "Comb":
+		id.Comb, err = strconv.Atoi(value)
+	case "Tf":
+		id.Tf = value
+	case "Rv":
+		id.Rv, err = strconv.Atoi(value)
+	case "Ncm":
+		id.Ncm, err = strconv.Atoi(value)
+	case "Fpb":
+		id.Fpb, err = strconv.ParseFloat(value, 64)
+	case "W":
+		id.W, err = strconv.Atoi(value)
+	case "Z":
+		id.Z, err = strconv.ParseFloat(value, 64)
+	default:
+		// Copy not to share the map with the RunID we come from
+		params := map[string]string{name: value}
+		for key, val := range id.Params {
+			if key != name {
+				params[key] = val
+			}
+		}
+		id.Params = params
+	}
+	return err
+}
+
+// String returns the file name.
+func (id RunID) String() string {
+	return id.Prefix + "-" + id.BaseName() + id.suffix()
+}
+
+// suffix is the part of the file name after the base name.
+func (id RunID) suffix() string {
+	return "-run" + id.RunStr() + "-rnd" + id.RoundStr() + id.Ext
+}
+
+// FileName returns the file name of id following the scheme, like
+// String does with Naming.
+func (scheme *NamingScheme) FileName(id RunID) string {
+	return id.Prefix + "-" + id.Head + scheme.Format(id) + id.suffix()
+}
+
+// RunFileName returns the file name of id, one of the runs of conf,
+// following the naming scheme of conf.
+func (conf *ConfigStruct) RunFileName(id RunID) string {
+	if conf.Naming != nil {
+		return conf.Naming.FileName(id)
+	}
+	return Naming.FileName(id)
+}
+
+// NextRound returns the identifier of the following restart.
+func (id RunID) NextRound() RunID {
+	id.Round++
+	return id
+}
+
+// WithPrefix returns the same identifier for another kind of file,
+// i.e. WithPrefix("err") on an STDOUT gives the matching STDERR.
+func (id RunID) WithPrefix(prefix string) RunID {
+	id.Prefix = prefix
+	return id
+}
+
+// WithExt returns the same identifier with another extension.
+func (id RunID) WithExt(ext string) RunID {
+	id.Ext = ext
+	return id
+}
+
+// ShortName returns the compact name used for the jobs, i.e. r16-06-00.
+func (id RunID) ShortName() string {
+	return "r" + id.CombStr() + "-" + id.RunStr() + "-" + id.RoundStr()
+}
+
+// NStars approximates the number of stars as centres of mass plus
+// primordial binaries companions.
+func (id RunID) NStars() float64 {
+	return float64(id.Ncm) * (1 + id.Fpb)
+}
+
+// RunStr return the run number in string form
+func (id RunID) RunStr() string {
+	return fmt.Sprintf("%02d", id.Run)
+}
+
+// RoundStr return the round number in string form
+func (id RunID) RoundStr() string {
+	return fmt.Sprintf("%02d", id.Round)
+}
+
+// CombStr return the combination number in string form
+func (id RunID) CombStr() string {
+	return fmt.Sprintf("%02d", id.Comb)
+}
@@ -0,0 +1,47 @@
+package slt
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseRunID(t *testing.T) {
+	var tests = []struct {
+		fileName string
+		want     RunID
+		fails    bool
+	}{
+		{
+			fileName: "ics-comb03-TFno-Rv1-NCM10000-fPB005-W5-Z001-run02-rnd03.txt.gz",
+			want: RunID{Prefix: "ics", Comb: 3, Tf: "no", Rv: 1, Ncm: 10000, Fpb: 0.05, W: 5, Z: 0.01,
+				Run: 2, Round: 3, Ext: ".txt.gz", Full: true},
+		},
+		{
+			fileName: "/some/folder/err-cineca-comb16-TFyes-Rv5-NCM5000-fPB02-W9-Z100-run10-rnd11.txt",
+			want: RunID{Prefix: "err", Head: "cineca-", Comb: 16, Tf: "yes", Rv: 5, Ncm: 5000, Fpb: 0.2, W: 9, Z: 1,
+				Run: 10, Round: 11, Ext: ".txt", Full: true},
+		},
+		{
+			// Legacy, without TF and Rv
+			fileName: "out-cineca-comb16-NCM10000-fPB005-W5-Z010-run06-rnd00.txt",
+			want: RunID{Prefix: "out", Comb: 16, Run: 6, Round: 0, Ext: ".txt",
+				body: "cineca-comb16-NCM10000-fPB005-W5-Z010"},
+		},
+		{fileName: "bogus.txt", fails: true},
+		{fileName: "out-comb16.txt", fails: true},
+	}
+	for _, test := range tests {
+		id, err := ParseRunID(test.fileName)
+		if (err != nil) != test.fails {
+			t.Errorf("ParseRunID(%v) error %v", test.fileName, err)
+			continue
+		}
+		if !test.fails && !reflect.DeepEqual(id, test.want) {
+			t.Errorf("ParseRunID(%v)\n got %+v\nwant %+v", test.fileName, id, test.want)
+		}
+		if !test.fails && id.String() != filepath.Base(test.fileName) {
+			t.Errorf("ParseRunID(%v).String() = %v", test.fileName, id.String())
+		}
+	}
+}
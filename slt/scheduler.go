@@ -0,0 +1,412 @@
+package slt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// JobSpec describes a job independently of the batch system.
+type JobSpec struct {
+	// Name is the job name shown by the scheduler, i.e. rr16-06-00.
+	Name    string
+	Project string
+	Queue   string
+	// Walltime is in the HH:MM:SS or DD:HH:MM:SS form.
+	Walltime string
+	// Resources is the PBS resource request, i.e. select=1:ncpus=1:ngpus=2.
+	Resources string
+	CPUs      int
+	GPUs      int
+	// Setup is run before Command, i.e. the module loading.
+	Setup   string
+	Command string
+}
+
+// Scheduler is a batch system able to run the job scripts.
+type Scheduler interface {
+	// Name is the name used in the --scheduler flag and in the configuration.
+	Name() string
+	// Template is the built-in text/template of the job script,
+	// it receives a ScriptData.
+	Template() string
+	// Submit queues the script and returns the job ID.
+	Submit(scriptName string) (string, error)
+	// Status returns the state of the job as told by the scheduler.
+	Status(jobID string) (string, error)
+	// Cancel removes the job from the queue or kills it.
+	Cancel(jobID string) error
+}
+
+// SchedulerName is the backend chosen with the --scheduler flag.
+var SchedulerName string
+
+var (
+	activeScheduler Scheduler
+	schedulerMutex  sync.Mutex
+)
+
+// NewScheduler returns the backend with the given name:
+// pbs, slurm, local or fake.
+func NewScheduler(name string) (Scheduler, error) {
+	switch name {
+	case "pbs", "torque", "":
+		return PBSScheduler{}, nil
+	case "slurm":
+		return SlurmScheduler{}, nil
+	case "local":
+		return LocalScheduler{}, nil
+	case "fake":
+		return NewFakeScheduler(), nil
+	}
+	return nil, fmt.Errorf("unknown scheduler %v", name)
+}
+
+// GetScheduler returns the scheduler in use. It is chosen by the
+// --scheduler flag, then by the Scheduler field of the configuration
+// file, then by the profile of the machine (the one in the configuration
+// or the one we are running on) and it defaults to PBS.
+func GetScheduler() Scheduler {
+	return resolveScheduler(nil)
+}
+
+// GetScheduler returns the scheduler in use, the one of the profile
+// unless the flag or the configuration say otherwise.
+func (profile *MachineProfile) GetScheduler() Scheduler {
+	return resolveScheduler(profile)
+}
+
+func resolveScheduler(profile *MachineProfile) Scheduler {
+	var (
+		name     string
+		confFile []byte
+		conf     struct{ Scheduler, Machine string }
+		err      error
+	)
+	schedulerMutex.Lock()
+	defer schedulerMutex.Unlock()
+	if activeScheduler != nil {
+		return activeScheduler
+	}
+	name = SchedulerName
+	if name == "" && ConfName != "" {
+		if confFile, err = ioutil.ReadFile(ConfName); err != nil {
+			log.Fatal(err)
+		}
+		if err = json.Unmarshal(confFile, &conf); err != nil {
+			log.Fatal("Parse config: ", err)
+		}
+		name = conf.Scheduler
+	}
+	if name == "" && profile == nil {
+		if conf.Machine != "" {
+			profile, _ = GetMachine(conf.Machine)
+		} else {
+			profile, _ = DetectMachine()
+		}
+	}
+	if name == "" && profile != nil {
+		name = profile.Scheduler
+	}
+	if activeScheduler, err = NewScheduler(name); err != nil {
+		log.Fatal(err)
+	}
+	return activeScheduler
+}
+
+// SetScheduler forces the scheduler to use, i.e. a FakeScheduler.
+func SetScheduler(scheduler Scheduler) {
+	schedulerMutex.Lock()
+	activeScheduler = scheduler
+	schedulerMutex.Unlock()
+}
+
+// runScheduler runs a scheduler command and returns its STDOUT.
+// Anything on STDERR is an error, like PbsLaunch always did.
+func runScheduler(name string, args ...string) (string, error) {
+	var (
+		cmd        = exec.Command(name, args...)
+		stdo, stde bytes.Buffer
+		err        error
+	)
+	cmd.Stdout = &stdo
+	cmd.Stderr = &stde
+	if err = cmd.Run(); err != nil {
+		return "", fmt.Errorf("%v %v: %v %v", name, strings.Join(args, " "), err, stde.String())
+	}
+	if stde.Len() != 0 {
+		return stdo.String(), fmt.Errorf("%v %v: %v", name, strings.Join(args, " "), stde.String())
+	}
+	return stdo.String(), nil
+}
+
+// PBSScheduler submits with qsub (PBS Pro and Torque).
+type PBSScheduler struct{}
+
+func (PBSScheduler) Name() string { return "pbs" }
+
+func (PBSScheduler) Template() string {
+	return `#!/bin/bash
+#PBS -N {{.Name}}
+#PBS -A {{.Project}}
+#PBS -q {{.Queue}}
+#PBS -l walltime={{.Walltime}}
+{{if .Resources}}#PBS -l {{.Resources}}
+{{end}}
+{{.Setup}}{{.Command}}
+`
+}
+
+func (PBSScheduler) Submit(scriptName string) (string, error) {
+	var (
+		out string
+		err error
+	)
+	if out, err = runScheduler("qsub", scriptName); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+func (PBSScheduler) Status(jobID string) (string, error) {
+	var (
+		out string
+		err error
+	)
+	if out, err = runScheduler("qstat", "-f", jobID); err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if fields := strings.SplitN(strings.TrimSpace(line), " = ", 2); len(fields) == 2 && fields[0] == "job_state" {
+			return fields[1], nil
+		}
+	}
+	return "", fmt.Errorf("can't find the state of job %v", jobID)
+}
+
+func (PBSScheduler) Cancel(jobID string) error {
+	_, err := runScheduler("qdel", jobID)
+	return err
+}
+
+// SlurmScheduler submits with sbatch.
+type SlurmScheduler struct{}
+
+func (SlurmScheduler) Name() string { return "slurm" }
+
+func (SlurmScheduler) Template() string {
+	return `#!/bin/bash
+#SBATCH --job-name={{.Name}}
+#SBATCH --account={{.Project}}
+#SBATCH --partition={{.Queue}}
+#SBATCH --time={{.SlurmWalltime}}
+#SBATCH --nodes=1
+#SBATCH --ntasks=1
+{{if .GPUs}}#SBATCH --gres=gpu:{{.GPUs}}
+{{end}}
+{{.Setup}}{{.Command}}
+`
+}
+
+// SlurmWalltime converts DD:HH:MM:SS to the DD-HH:MM:SS Slurm wants.
+func (job JobSpec) SlurmWalltime() string {
+	if parts := strings.SplitN(job.Walltime, ":", 2); strings.Count(job.Walltime, ":") == 3 {
+		return parts[0] + "-" + parts[1]
+	}
+	return job.Walltime
+}
+
+func (SlurmScheduler) Submit(scriptName string) (string, error) {
+	var (
+		out string
+		err error
+	)
+	if out, err = runScheduler("sbatch", "--parsable", scriptName); err != nil {
+		return "", err
+	}
+	// --parsable gives "jobid" or "jobid;cluster"
+	return strings.SplitN(strings.TrimSpace(out), ";", 2)[0], nil
+}
+
+func (SlurmScheduler) Status(jobID string) (string, error) {
+	var (
+		out string
+		err error
+	)
+	if out, err = runScheduler("squeue", "-h", "-j", jobID, "-o", "%T"); err != nil {
+		return "", err
+	}
+	if out = strings.TrimSpace(out); out == "" {
+		// squeue forgets the jobs soon after they end
+		return "FINISHED", nil
+	}
+	return out, nil
+}
+
+func (SlurmScheduler) Cancel(jobID string) error {
+	_, err := runScheduler("scancel", jobID)
+	return err
+}
+
+// LocalScheduler runs the scripts in the background on this machine,
+// like "nohup sh script &". The job ID is the PID and the output goes
+// to the script name plus ".log".
+type LocalScheduler struct{}
+
+func (LocalScheduler) Name() string { return "local" }
+
+func (LocalScheduler) Template() string {
+	return `#!/bin/bash
+# {{.Name}}
+
+{{.Setup}}{{.Command}}
+`
+}
+
+func (LocalScheduler) Submit(scriptName string) (string, error) {
+	var (
+		cmd     *exec.Cmd
+		logFile *os.File
+		err     error
+	)
+	if scriptName, err = filepath.Abs(scriptName); err != nil {
+		return "", err
+	}
+	cmd = exec.Command("nohup", "sh", scriptName)
+	if logFile, err = os.Create(scriptName + ".log"); err != nil {
+		return "", err
+	}
+	defer logFile.Close()
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.Dir = filepath.Dir(scriptName)
+	// Don't die with us
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err = cmd.Start(); err != nil {
+		return "", err
+	}
+	pid := cmd.Process.Pid
+	// Reap it when it ends, nobody else is waiting
+	go cmd.Wait()
+	return strconv.Itoa(pid), nil
+}
+
+func (LocalScheduler) Status(jobID string) (string, error) {
+	var (
+		pid int
+		err error
+	)
+	if pid, err = strconv.Atoi(jobID); err != nil {
+		return "", fmt.Errorf("local job ID %v is not a PID", jobID)
+	}
+	if err = syscall.Kill(pid, 0); err != nil {
+		return "finished", nil
+	}
+	return "running", nil
+}
+
+func (LocalScheduler) Cancel(jobID string) error {
+	var (
+		pid int
+		err error
+	)
+	if pid, err = strconv.Atoi(jobID); err != nil {
+		return fmt.Errorf("local job ID %v is not a PID", jobID)
+	}
+	// The job is the leader of its own session
+	return syscall.Kill(-pid, syscall.SIGTERM)
+}
+
+// FakeScheduler doesn't run anything, it records what it is asked.
+// Use it to try the pipeline without a batch system.
+type FakeScheduler struct {
+	mutex     sync.Mutex
+	Submitted []string
+	Cancelled []string
+	states    map[string]string
+}
+
+// NewFakeScheduler returns an empty FakeScheduler.
+func NewFakeScheduler() *FakeScheduler {
+	return &FakeScheduler{states: map[string]string{}}
+}
+
+func (fake *FakeScheduler) Name() string { return "fake" }
+
+func (fake *FakeScheduler) Template() string {
+	return LocalScheduler{}.Template()
+}
+
+func (fake *FakeScheduler) Submit(scriptName string) (string, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	fake.Submitted = append(fake.Submitted, scriptName)
+	jobID := "fake-" + strconv.Itoa(len(fake.Submitted))
+	fake.states[jobID] = "queued"
+	log.Println("Fake submission of ", scriptName, " as ", jobID)
+	return jobID, nil
+}
+
+func (fake *FakeScheduler) Status(jobID string) (string, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	if state, ok := fake.states[jobID]; ok {
+		return state, nil
+	}
+	return "", fmt.Errorf("unknown job %v", jobID)
+}
+
+func (fake *FakeScheduler) Cancel(jobID string) error {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	if _, ok := fake.states[jobID]; !ok {
+		return fmt.Errorf("unknown job %v", jobID)
+	}
+	fake.states[jobID] = "cancelled"
+	fake.Cancelled = append(fake.Cancelled, jobID)
+	return nil
+}
+
+// JobStatus prints the scheduler state of the last submitted round of each
+// run recorded in the state of the folder and cancels them if asked.
+func JobStatus(cancel bool) {
+	var (
+		db        *StateDB
+		scheduler = GetScheduler()
+		round     *RoundState
+		status    string
+		err       error
+	)
+	if db, err = ReadState("."); err != nil {
+		log.Fatal("Can't read the state: ", err)
+	}
+	for _, name := range db.RunNames() {
+		round = nil
+		for _, r := range db.Runs[name].Rounds {
+			if r.JobID != "" {
+				round = r
+			}
+		}
+		if round == nil {
+			continue
+		}
+		if status, err = scheduler.Status(round.JobID); err != nil {
+			status = err.Error()
+		}
+		fmt.Printf("%v\trnd%02d\t%v\t%v\n", name, round.Round, round.JobID, status)
+		if cancel {
+			if err = scheduler.Cancel(round.JobID); err != nil {
+				log.Println("Can't cancel ", round.JobID, ": ", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package slt
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewScheduler(t *testing.T) {
+	var tests = []struct {
+		name, want string
+	}{
+		{"", "pbs"},
+		{"pbs", "pbs"},
+		{"torque", "pbs"},
+		{"slurm", "slurm"},
+		{"local", "local"},
+		{"fake", "fake"},
+		{"lsf", ""},
+	}
+	for _, test := range tests {
+		scheduler, err := NewScheduler(test.name)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("NewScheduler(%v) accepted", test.name)
+			}
+			continue
+		}
+		if err != nil || scheduler.Name() != test.want {
+			t.Errorf("NewScheduler(%v) = %v, %v, want %v", test.name, scheduler, err, test.want)
+		}
+	}
+}
+
+func TestSchedulerTemplates(t *testing.T) {
+	var (
+		job = JobSpec{
+			Name:      "rr16-06-01",
+			Project:   "IscrC_VMStars",
+			Queue:     "parallel",
+			Walltime:  "01:23:59:00",
+			Resources: "select=1:ncpus=1:ngpus=2",
+			GPUs:      2,
+			Setup:     "module load cuda\n",
+			Command:   "sh kiraLaunch.sh\n",
+		}
+		tests = []struct {
+			scheduler Scheduler
+			job       JobSpec
+			header    []string
+		}{
+			{PBSScheduler{}, job, []string{
+				"#!/bin/bash",
+				"#PBS -N rr16-06-01",
+				"#PBS -A IscrC_VMStars",
+				"#PBS -q parallel",
+				"#PBS -l walltime=01:23:59:00",
+				"#PBS -l select=1:ncpus=1:ngpus=2",
+			}},
+			{SlurmScheduler{}, job, []string{
+				"#!/bin/bash",
+				"#SBATCH --job-name=rr16-06-01",
+				"#SBATCH --account=IscrC_VMStars",
+				"#SBATCH --partition=parallel",
+				"#SBATCH --time=01-23:59:00",
+				"#SBATCH --nodes=1",
+				"#SBATCH --ntasks=1",
+				"#SBATCH --gres=gpu:2",
+			}},
+			// Without GPUs and resources the lines are left out
+			{SlurmScheduler{}, JobSpec{Name: "rr16-06-01", Walltime: "23:59:00"}, []string{
+				"#!/bin/bash",
+				"#SBATCH --job-name=rr16-06-01",
+				"#SBATCH --account=",
+				"#SBATCH --partition=",
+				"#SBATCH --time=23:59:00",
+				"#SBATCH --nodes=1",
+				"#SBATCH --ntasks=1",
+				"",
+			}},
+			{PBSScheduler{}, JobSpec{Name: "rr16-06-01", Walltime: "23:59:00"}, []string{
+				"#!/bin/bash",
+				"#PBS -N rr16-06-01",
+				"#PBS -A ",
+				"#PBS -q ",
+				"#PBS -l walltime=23:59:00",
+				"",
+			}},
+			{LocalScheduler{}, job, []string{"#!/bin/bash", "# rr16-06-01", ""}},
+		}
+	)
+	for _, test := range tests {
+		tmpl, err := LoadTemplate(test.scheduler.Name(), "", test.scheduler.Template())
+		if err != nil {
+			t.Fatal(err)
+		}
+		script, err := RenderScript(tmpl, ScriptData{JobSpec: test.job})
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(script, "\n")
+		if len(lines) < len(test.header) || !reflect.DeepEqual(lines[:len(test.header)], test.header) {
+			t.Errorf("%v header:\n%v", test.scheduler.Name(), script)
+		}
+		if !strings.HasSuffix(script, "\n"+test.job.Setup+test.job.Command+"\n") {
+			t.Errorf("%v script doesn't end with setup and command:\n%v", test.scheduler.Name(), script)
+		}
+	}
+}
+
+func TestFakeScheduler(t *testing.T) {
+	var (
+		fake   = NewFakeScheduler()
+		status string
+		err    error
+	)
+	for _, script := range []string{"PBS-a.sh", "PBS-b.sh"} {
+		if _, err = fake.Submit(script); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(fake.Submitted, []string{"PBS-a.sh", "PBS-b.sh"}) {
+		t.Errorf("submitted %v", fake.Submitted)
+	}
+	if err = fake.Cancel("fake-2"); err != nil {
+		t.Fatal(err)
+	}
+	for jobID, want := range map[string]string{"fake-1": "queued", "fake-2": "cancelled"} {
+		if status, err = fake.Status(jobID); err != nil || status != want {
+			t.Errorf("job %v is %v, %v, want %v", jobID, status, err, want)
+		}
+	}
+	if _, err = fake.Status("fake-3"); err == nil {
+		t.Error("status of an unknown job")
+	}
+	if err = fake.Cancel("fake-3"); err == nil || len(fake.Cancelled) != 1 {
+		t.Errorf("cancelled %v, error %v", fake.Cancelled, err)
+	}
+}
+
+// fakeCommands puts shell scripts named after the keys of commands in
+// front of the PATH. Each script logs its arguments in calls.log.
+func fakeCommands(t *testing.T, commands map[string]string) string {
+	var dir = t.TempDir()
+	for name, body := range commands {
+		script := "#!/bin/sh\necho " + name + " \"$@\" >> " + filepath.Join(dir, "calls.log") + "\n" + body + "\n"
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return filepath.Join(dir, "calls.log")
+}
+
+func TestPBSCommands(t *testing.T) {
+	var (
+		calls = fakeCommands(t, map[string]string{
+			"qsub": `echo "1234.master.cineca.it"`,
+			"qstat": `case "$2" in
+1234*) cat <<EOF
+Job Id: 1234.master.cineca.it
+    Job_Name = rr16-06-01
+    resources_used.walltime = 00:10:12
+    job_state = R
+    queue = parallel
+EOF
+;;
+*) echo "qstat: Unknown Job Id $2" >&2; exit 153;;
+esac`,
+			"qdel": "",
+		})
+		scheduler = PBSScheduler{}
+		jobID     string
+		status    string
+		log       []byte
+		err       error
+	)
+	if jobID, err = scheduler.Submit("PBS-x.sh"); err != nil || jobID != "1234.master.cineca.it" {
+		t.Fatalf("job ID %q, %v", jobID, err)
+	}
+	if status, err = scheduler.Status(jobID); err != nil || status != "R" {
+		t.Errorf("status %q, %v", status, err)
+	}
+	if _, err = scheduler.Status("999"); err == nil {
+		t.Error("status of an unknown job")
+	}
+	if err = scheduler.Cancel(jobID); err != nil {
+		t.Fatal(err)
+	}
+	if log, err = ioutil.ReadFile(calls); err != nil {
+		t.Fatal(err)
+	}
+	if want := "qsub PBS-x.sh\nqstat -f 1234.master.cineca.it\nqstat -f 999\nqdel 1234.master.cineca.it\n"; string(log) != want {
+		t.Errorf("commands run:\n%v", string(log))
+	}
+}
+
+func TestSlurmCommands(t *testing.T) {
+	var (
+		calls = fakeCommands(t, map[string]string{
+			"sbatch": `echo "5678;galileo"`,
+			// squeue -h -j ID -o %T, the finished jobs are not listed
+			"squeue":  `if [ "$3" = "5678" ]; then echo "RUNNING"; fi`,
+			"scancel": "",
+		})
+		scheduler = SlurmScheduler{}
+		jobID     string
+		status    string
+		log       []byte
+		err       error
+	)
+	if jobID, err = scheduler.Submit("PBS-x.sh"); err != nil || jobID != "5678" {
+		t.Fatalf("job ID %q, %v", jobID, err)
+	}
+	for id, want := range map[string]string{"5678": "RUNNING", "1111": "FINISHED"} {
+		if status, err = scheduler.Status(id); err != nil || status != want {
+			t.Errorf("job %v is %q, %v, want %v", id, status, err, want)
+		}
+	}
+	if err = scheduler.Cancel(jobID); err != nil {
+		t.Fatal(err)
+	}
+	if log, err = ioutil.ReadFile(calls); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(log), "sbatch --parsable PBS-x.sh\n") || !strings.HasSuffix(string(log), "scancel 5678\n") {
+		t.Errorf("commands run:\n%v", string(log))
+	}
+}
+
+func TestJobStatus(t *testing.T) {
+	var (
+		fake    = NewFakeScheduler()
+		dir     = t.TempDir()
+		wd, _   = os.Getwd()
+		stdout  = os.Stdout
+		scripts = []string{
+			"PBS-comb16-TFno-Rv1-NCM10000-fPB005-W5-Z010-run00-rnd00.sh",
+			"PBS-comb16-TFno-Rv1-NCM10000-fPB005-W5-Z010-run00-rnd01.sh",
+			"PBS-comb16-TFno-Rv1-NCM10000-fPB005-W5-Z010-run01-rnd00.sh",
+		}
+		reader, writer *os.File
+		out            []byte
+		err            error
+	)
+	SetScheduler(fake)
+	defer SetScheduler(nil)
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	for _, script := range scripts {
+		jobID, _ := fake.Submit(script)
+		RecordSubmission(script, jobID)
+	}
+	fake.Cancel("fake-3")
+
+	if reader, writer, err = os.Pipe(); err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = writer
+	JobStatus(true)
+	os.Stdout = stdout
+	writer.Close()
+	out, _ = ioutil.ReadAll(reader)
+
+	// The last round of each run only
+	want := []string{"fake-2\tqueued", "fake-3\tcancelled"}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("status:\n%v", string(out))
+	}
+	for idx, line := range lines {
+		if !strings.HasSuffix(line, want[idx]) {
+			t.Errorf("line %q, want it to end with %q", line, want[idx])
+		}
+	}
+	// fake-3 was already cancelled
+	if !reflect.DeepEqual(fake.Cancelled, []string{"fake-3", "fake-2", "fake-3"}) {
+		t.Errorf("cancelled %v", fake.Cancelled)
+	}
+}
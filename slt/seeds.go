@@ -0,0 +1,117 @@
+package slt
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strconv"
+)
+
+// kiraSeedName is the program name used to derive the kira seed.
+const kiraSeedName = "kira"
+
+// RunSeeds are the random seeds of a realization, derived from the
+// master seed of the configuration (the Seed field) so that the ICs and
+// the first round of kira can be regenerated bit-for-bit.
+type RunSeeds struct {
+	Run int
+	// ICs are the seeds of the ICs pipeline stages with a SeedFlag, by stage name.
+	ICs  map[string]int64
+	Kira int64
+}
+
+// DeriveSeed returns the seed of program for the realization run of the
+// combination comb. It is a hash of the master seed and the indices,
+// between 1 and 2^31-1: StarLab reads the seed as an int and takes 0
+// as "from the clock".
+func DeriveSeed(master int64, comb, run int, program string) int64 {
+	var sum = sha256.Sum256([]byte(fmt.Sprintf("%v/%v/%v/%v", master, comb, run, program)))
+	if seed := int64(binary.BigEndian.Uint32(sum[:4]) & 0x7fffffff); seed != 0 {
+		return seed
+	}
+	return 1
+}
+
+// RunSeeds returns the seeds of the realization run, nil if the
+// configuration has no master seed.
+func (conf *ConfigStruct) RunSeeds(run int) *RunSeeds {
+	var (
+		pipeline = conf.ICsPipeline
+		seeds    *RunSeeds
+	)
+	if conf.Seed == 0 {
+		return nil
+	}
+	if pipeline == nil {
+		pipeline = DefaultICsPipeline()
+	}
+	seeds = &RunSeeds{Run: run, ICs: map[string]int64{}, Kira: DeriveSeed(conf.Seed, conf.Comb, run, kiraSeedName)}
+	for _, stage := range pipeline {
+		if stage.SeedFlag != "" {
+			seeds.ICs[stage.Name] = DeriveSeed(conf.Seed, conf.Comb, run, stage.Name)
+		}
+	}
+	return seeds
+}
+
+// ICsStrings returns the ICs seeds for ScriptData.ICsSeeds, nil if seeds is nil.
+func (seeds *RunSeeds) ICsStrings() map[string]string {
+	if seeds == nil {
+		return nil
+	}
+	strs := map[string]string{}
+	for name, seed := range seeds.ICs {
+		strs[name] = strconv.FormatInt(seed, 10)
+	}
+	return strs
+}
+
+// loadConfSeed reads the master seed of the configuration file, 0 if
+// confName is empty or has none.
+func loadConfSeed(confName string) int64 {
+	var (
+		confFile []byte
+		conf     struct{ Seed int64 }
+		err      error
+	)
+	if confName == "" {
+		return 0
+	}
+	if confFile, err = ioutil.ReadFile(confName); err != nil {
+		log.Fatal(err)
+	}
+	if err = json.Unmarshal(confFile, &conf); err != nil {
+		log.Fatal("Parse config: ", err)
+	}
+	return conf.Seed
+}
+
+// FirstKiraSeed returns the derived kira seed for the ICs id, empty if
+// the configuration confName has no master seed or id is not the first
+// round: the following rounds reuse the seed kira wrote in the STDERR.
+func FirstKiraSeed(confName string, id RunID) string {
+	var master int64
+	if id.Round != 0 {
+		return ""
+	}
+	if master = loadConfSeed(confName); master == 0 {
+		return ""
+	}
+	return strconv.FormatInt(DeriveSeed(master, id.Comb, id.Run, kiraSeedName), 10)
+}
+
+// AllRunSeeds returns the seeds of all the runs of the configuration,
+// nil without a master seed.
+func (conf *ConfigStruct) AllRunSeeds() []*RunSeeds {
+	var seeds []*RunSeeds
+	if conf.Seed == 0 {
+		return nil
+	}
+	for run := 0; run < conf.Runs; run++ {
+		seeds = append(seeds, conf.RunSeeds(run))
+	}
+	return seeds
+}
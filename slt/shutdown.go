@@ -0,0 +1,174 @@
+package slt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Walltime settings for KiraWrap, set by the kiraWrap flags.
+var (
+	// KiraWalltime is the walltime of the job, from the start of kiraWrap.
+	// If empty it is read from the scheduler environment.
+	KiraWalltime string
+	// KiraWalltimeMargin is how many minutes before the walltime kira is stopped.
+	KiraWalltimeMargin float64 = 10
+)
+
+// killGrace is how long kira has to exit after a forwarded signal.
+const killGrace = 30 * time.Second
+
+// ExitRecord is written by kiraWrap next to the STDOUT when it ends,
+// out2ics reads it to know where to resume.
+type ExitRecord struct {
+	// Reason is why kira ended, i.e. "kira exited" or "killed because ...".
+	Reason string
+	// Signal is the signal received by kiraWrap, if any.
+	Signal string `json:",omitempty"`
+	// LastTimestep is the last complete snapshot in the STDOUT, empty if none.
+	LastTimestep string
+	RandomSeed   string
+	Restarts     int
+	Started      time.Time
+	Ended        time.Time
+	// WallTime is the time spent by kiraWrap, i.e. "3h52m10s".
+	WallTime string
+}
+
+// ParseWalltime reads a walltime in the HH:MM:SS or DD:HH:MM:SS form.
+func ParseWalltime(str string) (time.Duration, error) {
+	var (
+		fields   = strings.Split(strings.TrimSpace(str), ":")
+		value    int64
+		walltime time.Duration
+		units    = []time.Duration{time.Second, time.Minute, time.Hour, 24 * time.Hour}
+		err      error
+	)
+	if !walltimeReg.MatchString(strings.TrimSpace(str)) {
+		return 0, fmt.Errorf("walltime %v is not HH:MM:SS or DD:HH:MM:SS", str)
+	}
+	for idx := range fields {
+		if value, err = strconv.ParseInt(fields[len(fields)-1-idx], 10, 64); err != nil {
+			return 0, fmt.Errorf("walltime %v: %v", str, err)
+		}
+		walltime += time.Duration(value) * units[idx]
+	}
+	return walltime, nil
+}
+
+// JobDeadline returns when the job will be killed by the scheduler:
+// the --walltime flag counted from start, SLURM_JOB_END_TIME or
+// PBS_WALLTIME (Torque, in seconds from start). ok is false if unknown.
+func JobDeadline(start time.Time) (deadline time.Time, ok bool) {
+	var (
+		walltime time.Duration
+		seconds  int64
+		err      error
+	)
+	if KiraWalltime != "" {
+		if walltime, err = ParseWalltime(KiraWalltime); err != nil {
+			log.Fatal(err)
+		}
+		return start.Add(walltime), true
+	}
+	if seconds, err = strconv.ParseInt(os.Getenv("SLURM_JOB_END_TIME"), 10, 64); err == nil && seconds > 0 {
+		return time.Unix(seconds, 0), true
+	}
+	if seconds, err = strconv.ParseInt(os.Getenv("PBS_WALLTIME"), 10, 64); err == nil && seconds > 0 {
+		return start.Add(time.Duration(seconds) * time.Second), true
+	}
+	return time.Time{}, false
+}
+
+// forwardSignals passes the signals received by kiraWrap to kira and
+// stops kira KiraWalltimeMargin minutes before the deadline, if any.
+// If kira doesn't exit within killGrace it is killed.
+// It returns without doing anything once stop is closed.
+func forwardSignals(kiraWrappedCmd *exec.Cmd, signals chan os.Signal, deadline time.Time, hasDeadline bool, done chan string, stop chan struct{}) {
+	var (
+		timeout <-chan time.Time
+		reason  string
+	)
+	if hasDeadline {
+		margin := time.Duration(KiraWalltimeMargin * float64(time.Minute))
+		timeout = time.After(deadline.Add(-margin).Sub(time.Now()))
+	}
+	select {
+	case <-stop:
+		return
+	case sig := <-signals:
+		log.Println("Forward ", sig, " to kira")
+		reason = "received " + sig.String()
+		if err := kiraWrappedCmd.Process.Signal(sig); err != nil {
+			log.Println("Can't forward the signal: ", err)
+		}
+	case <-timeout:
+		log.Println("Stop kira, the walltime ends at ", deadline.Format(time.RFC850))
+		reason = fmt.Sprintf("walltime ending at %v", deadline.Format(time.RFC850))
+		if err := kiraWrappedCmd.Process.Signal(syscall.SIGTERM); err != nil {
+			log.Println("Can't stop kira: ", err)
+		}
+	}
+	done <- "stopped because " + reason
+	select {
+	case <-stop:
+	case <-time.After(killGrace):
+		log.Println("kira still running, kill it")
+		kiraWrappedCmd.Process.Kill()
+	}
+}
+
+// ExitRecordName returns the name of the exit record of a STDOUT,
+// i.e. exit-cineca-comb16-...-run06-rnd00.json.
+func ExitRecordName(outName string) string {
+	return sidecarName(outName, "exit", ".json")
+}
+
+// WriteExitRecord writes record as indented JSON.
+func WriteExitRecord(fileName string, record *ExitRecord) error {
+	var (
+		content []byte
+		err     error
+	)
+	if content, err = json.MarshalIndent(record, "", "\t"); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, append(content, '\n'), 0644)
+}
+
+// ReadExitRecord reads the exit record in fileName.
+func ReadExitRecord(fileName string) (*ExitRecord, error) {
+	var (
+		content []byte
+		record  = new(ExitRecord)
+		err     error
+	)
+	if content, err = ioutil.ReadFile(fileName); err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(content, record); err != nil {
+		return nil, fmt.Errorf("%v: %v", fileName, err)
+	}
+	return record, nil
+}
+
+// lastCompleteTimestep returns the timestep of the last complete snapshot
+// of a STDOUT, empty if there is none.
+func lastCompleteTimestep(outName string) (string, error) {
+	snap, err := LastCompleteSnapshot(outName)
+	if errors.Is(err, ErrNoCompleteSnapshot) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return snap.Timestep, nil
+}
@@ -3,7 +3,6 @@ package slt
 import (
 	"fmt"
 	"log"
-	"os"
 	"path/filepath"
 	"time"
 
@@ -11,7 +10,18 @@ import (
 	"github.com/brunetto/goutils/debug"
 )
 
+// SimClean tidies the folder: job outputs to Trash, scripts and logs to
+// Scripts, temporary and hidden files removed.
 func SimClean () () {
+	var plan = NewPlan("simClean")
+	PlanSimClean(plan)
+	if err := plan.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// PlanSimClean adds the actions of SimClean to plan.
+func PlanSimClean (plan *Plan) () {
 	if Debug {
 		defer debug.TimeMe(time.Now())
 	}
@@ -27,13 +37,13 @@ func SimClean () () {
 	
 	log.Println("Check dirs existance and in case create them")
 	if !goutils.Exists(scriptDir) {
-		if err = os.Mkdir(scriptDir, 0700); err != nil {
+		if err = plan.Mkdir(scriptDir, "scripts and logs"); err != nil {
 			log.Fatal("Can't create folder ", err)
 		}
 	}
 	
 	if !goutils.Exists(trashDir) {
-		if err = os.Mkdir(trashDir, 0700); err != nil {
+		if err = plan.Mkdir(trashDir, "job outputs"); err != nil {
 			log.Fatal("Can't create folder ", err)
 		}
 	}
@@ -44,7 +54,7 @@ func SimClean () () {
 	}
 	fmt.Println(files)
 	for _, file = range files {
-		if err = os.Rename(file, filepath.Join(trashDir, file)); err != nil {
+		if err = plan.Rename(file, filepath.Join(trashDir, file), "job output"); err != nil {
 			log.Fatal("Error while moving ", file, ": ", err)
 		}
 	}
@@ -55,7 +65,7 @@ func SimClean () () {
 	}
 	fmt.Println(files)
 	for _, file = range files {
-		if err = os.Rename(file, filepath.Join(scriptDir, file)); err != nil {
+		if err = plan.Rename(file, filepath.Join(scriptDir, file), "script"); err != nil {
 			log.Fatal("Error while moving ", file, ": ", err)
 		}
 	}
@@ -66,7 +76,7 @@ func SimClean () () {
 	}
 	fmt.Println(files)
 	for _, file = range files {
-		if err = os.Rename(file, filepath.Join(logDir, file)); err != nil {
+		if err = plan.Rename(file, filepath.Join(logDir, file), "log"); err != nil {
 			log.Fatal("Error while moving ", file, ": ", err)
 		}
 	}
@@ -77,7 +87,7 @@ func SimClean () () {
 	}
 	fmt.Println(files)
 	for _, file = range files {
-		if err = os.Remove(file); err != nil {
+		if err = plan.Remove(file, "temporary file"); err != nil {
 			log.Fatal("Error while removing ", file, ": ", err)
 		}
 	}
@@ -88,7 +98,7 @@ func SimClean () () {
 	}
 	fmt.Println(files)
 	for _, file = range files {
-		if err = os.Remove(file); err != nil {
+		if err = plan.Remove(file, "hidden file"); err != nil {
 			log.Fatal("Error while removing ", file, ": ", err)
 		}
 	}
@@ -98,7 +108,7 @@ func SimClean () () {
 	}
 	fmt.Println(files)
 	for _, file = range files {
-		if err = os.Remove(file); err != nil {
+		if err = plan.Remove(file, "hidden file"); err != nil {
 			log.Fatal("Error while removing ", file, ": ", err)
 		}
 	}
@@ -108,7 +118,7 @@ func SimClean () () {
 	}
 	fmt.Println(files)
 	for _, file = range files {
-		if err = os.Remove(file); err != nil {
+		if err = plan.Remove(file, "hidden file"); err != nil {
 			log.Fatal("Error while removing ", file, ": ", err)
 		}
 	}
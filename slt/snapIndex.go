@@ -0,0 +1,395 @@
+package slt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/brunetto/goutils/debug"
+	"github.com/brunetto/goutils/readfile"
+)
+
+// IndexDirName is the folder, next to the indexed files, with their indexes.
+const IndexDirName = ".index"
+
+// SnapEntry locates a snapshot of a STDOUT, or a timestep of a STDERR, in
+// its file. The offsets of compressed files are in the decompressed data.
+type SnapEntry struct {
+	Timestep  string
+	Offset    int64
+	Length    int64
+	Integrity bool
+}
+
+// SnapIndex lists the snapshots of a STDOUT or the timesteps of a STDERR
+// (Kind out or err). It is saved in IndexDirName and rebuilt when the size
+// or the modification time of the file change.
+type SnapIndex struct {
+	File    string
+	Kind    string
+	Size    int64
+	ModTime time.Time
+	// Entries are in the order of the file: all complete but the last,
+	// where the reading stopped, if the file is truncated or corrupted.
+	Entries []*SnapEntry
+	// timesteps are the first complete entry of each timestep.
+	timesteps map[string]*SnapEntry
+}
+
+// IndexName returns the name of the index of path.
+func IndexName(path string) string {
+	return filepath.Join(filepath.Dir(path), IndexDirName, filepath.Base(path)+".json")
+}
+
+// stdKind tells from the name whether path is a STDERR (err) or a STDOUT (out).
+func stdKind(path string) string {
+	if id, err := ParseRunID(filepath.Base(path)); err == nil && id.Prefix == "err" {
+		return "err"
+	}
+	if strings.HasPrefix(filepath.Base(path), "err") {
+		return "err"
+	}
+	return "out"
+}
+
+// LoadSnapIndex returns the index of path, rebuilding it if the file has
+// changed since it was saved.
+func LoadSnapIndex(path, kind string) (*SnapIndex, error) {
+	var (
+		info    os.FileInfo
+		content []byte
+		index   *SnapIndex
+		err     error
+	)
+	if info, err = os.Stat(path); err != nil {
+		return nil, err
+	}
+	if content, err = ioutil.ReadFile(IndexName(path)); err == nil {
+		index = new(SnapIndex)
+		if err = json.Unmarshal(content, index); err == nil && index.valid(filepath.Base(path), kind, info) {
+			index.mapTimesteps()
+			return index, nil
+		}
+	}
+	if index, err = BuildSnapIndex(path, kind); err != nil {
+		return nil, err
+	}
+	// The index is only a shortcut, the folder can be read only
+	if err = index.Save(path); err != nil {
+		log.Println("Can't save the index: ", err)
+	}
+	return index, nil
+}
+
+// BuildSnapIndex reads path from the start and indexes its snapshots.
+func BuildSnapIndex(path, kind string) (*SnapIndex, error) {
+	if Debug {
+		defer debug.TimeMe(time.Now())
+	}
+	var (
+		info        os.FileInfo
+		inFile      *StdReader
+		counter     *countingReader
+		nReader     *bufio.Reader
+		scanner     *SnapshotScanner
+		index       *SnapIndex
+		offset, end int64
+		err         error
+	)
+	// The file can grow while reading, the index is valid for what was there
+	if info, err = os.Stat(path); err != nil {
+		return nil, err
+	}
+	if inFile, err = OpenStd(path); err != nil {
+		return nil, err
+	}
+	defer inFile.Close()
+	// The position is what was read minus what is still in the buffer
+	counter = &countingReader{Reader: inFile.Reader}
+	nReader = bufio.NewReader(counter)
+	switch kind {
+	case "out":
+		scanner = NewOutSnapshotScanner(nReader)
+	case "err":
+		scanner = NewErrSnapshotScanner(nReader)
+	default:
+		return nil, fmt.Errorf("unknown kind %v, use out or err", kind)
+	}
+	index = &SnapIndex{File: filepath.Base(path), Kind: kind, Size: info.Size(), ModTime: info.ModTime(), Entries: []*SnapEntry{}}
+	for scanner.Scan() {
+		end = counter.read - int64(nReader.Buffered())
+		index.Entries = append(index.Entries, &SnapEntry{Timestep: scanner.Snapshot().Timestep, Offset: offset, Length: end - offset, Integrity: true})
+		offset = end
+	}
+	if err = scanner.Err(); err != nil {
+		end = counter.read - int64(nReader.Buffered())
+		index.Entries = append(index.Entries, &SnapEntry{Timestep: scanner.Snapshot().Timestep, Offset: offset, Length: end - offset})
+	}
+	index.mapTimesteps()
+	return index, nil
+}
+
+// valid tells whether the index is the one of the file name of kind with info.
+func (index *SnapIndex) valid(name, kind string, info os.FileInfo) bool {
+	return index.File == name && index.Kind == kind && index.Size == info.Size() && index.ModTime.Equal(info.ModTime())
+}
+
+func (index *SnapIndex) mapTimesteps() {
+	index.timesteps = map[string]*SnapEntry{}
+	for _, entry := range index.Entries {
+		if _, found := index.timesteps[entry.Timestep]; entry.Integrity && !found {
+			index.timesteps[entry.Timestep] = entry
+		}
+	}
+}
+
+// Save writes the index of path in IndexDirName.
+func (index *SnapIndex) Save(path string) error {
+	var (
+		indexName = IndexName(path)
+		content   []byte
+		err       error
+	)
+	if err = os.MkdirAll(filepath.Dir(indexName), 0700); err != nil {
+		return err
+	}
+	if content, err = json.Marshal(index); err != nil {
+		return err
+	}
+	// Write and rename, not to leave a broken index
+	if err = ioutil.WriteFile(indexName+".tmp", content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(indexName+".tmp", indexName)
+}
+
+// Find returns the first complete entry of timestep, nil if there is none.
+func (index *SnapIndex) Find(timestep string) *SnapEntry {
+	return index.timesteps[timestep]
+}
+
+// Cut returns the entry of timestep or, if not there, the last complete
+// one, nil if there is none. The complete entries end where it ends.
+func (index *SnapIndex) Cut(timestep string) *SnapEntry {
+	if entry := index.Find(timestep); entry != nil {
+		return entry
+	}
+	for idx := len(index.Entries) - 1; idx >= 0; idx-- {
+		if index.Entries[idx].Integrity {
+			return index.Entries[idx]
+		}
+	}
+	return nil
+}
+
+// countingReader counts the bytes read.
+type countingReader struct {
+	io.Reader
+	read int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.read += int64(n)
+	return n, err
+}
+
+// SnapFile reads the snapshots of a STDOUT or a STDERR through its index:
+// uncompressed files are seeked, compressed ones are read forward.
+type SnapFile struct {
+	*SnapIndex
+	path   string
+	inFile *StdReader
+	// pos is where inFile is in the decompressed data.
+	pos int64
+}
+
+// OpenSnapFile opens path and loads its index, see LoadSnapIndex.
+func OpenSnapFile(path, kind string) (*SnapFile, error) {
+	var (
+		file = &SnapFile{path: path}
+		err  error
+	)
+	if file.SnapIndex, err = LoadSnapIndex(path, kind); err != nil {
+		return nil, err
+	}
+	if file.inFile, err = OpenStd(path); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// Section returns a reader over the bytes of entry.
+func (f *SnapFile) Section(entry *SnapEntry) (io.Reader, error) {
+	var (
+		content []byte
+		err     error
+	)
+	if f.inFile.Codec == nil {
+		return io.NewSectionReader(f.inFile.file, entry.Offset, entry.Length), nil
+	}
+	// Compressed data can only be read forward, go back from the start
+	if entry.Offset < f.pos {
+		f.inFile.Close()
+		if f.inFile, err = OpenStd(f.path); err != nil {
+			return nil, err
+		}
+		f.pos = 0
+	}
+	if _, err = io.CopyN(ioutil.Discard, f.inFile.Reader, entry.Offset-f.pos); err != nil {
+		return nil, err
+	}
+	content = make([]byte, entry.Length)
+	if _, err = io.ReadFull(f.inFile.Reader, content); err != nil {
+		return nil, err
+	}
+	f.pos = entry.Offset + entry.Length
+	return bytes.NewReader(content), nil
+}
+
+// WriteEntry copies entry to w as it is in the file, ending it with a
+// newline if the file doesn't.
+func (f *SnapFile) WriteEntry(w io.Writer, entry *SnapEntry) error {
+	var (
+		section io.Reader
+		tail    = &lastByteWriter{Writer: w}
+		err     error
+	)
+	if section, err = f.Section(entry); err != nil {
+		return err
+	}
+	if _, err = io.Copy(tail, section); err != nil {
+		return err
+	}
+	if tail.last != '\n' && entry.Length > 0 {
+		_, err = io.WriteString(w, "\n")
+	}
+	return err
+}
+
+// lastByteWriter remembers the last byte written.
+type lastByteWriter struct {
+	io.Writer
+	last byte
+}
+
+func (w *lastByteWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		w.last = p[len(p)-1]
+	}
+	return w.Writer.Write(p)
+}
+
+// Snapshot reads the snapshot of entry.
+func (f *SnapFile) Snapshot(entry *SnapEntry) (*DumbSnapshot, error) {
+	var (
+		section io.Reader
+		snap    *DumbSnapshot
+		lineNo  int64
+		err     error
+	)
+	if section, err = f.Section(entry); err != nil {
+		return nil, err
+	}
+	if f.Kind == "err" {
+		snap, err = readErrSnapshot(bufio.NewReader(section), &lineNo)
+	} else {
+		snap, err = readOutSnapshot(bufio.NewReader(section), &lineNo)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v timestep %v: %v", f.File, entry.Timestep, err)
+	}
+	return snap, nil
+}
+
+// RootSection reads the story name (i.e. Dynamics) of the root particle
+// of entry, without reading the rest of the snapshot.
+func (f *SnapFile) RootSection(entry *SnapEntry, name string) (*Section, error) {
+	var (
+		section   io.Reader
+		nReader   *bufio.Reader
+		line      string
+		lines     = []string{}
+		particles int
+		sec       *Section
+		err       error
+	)
+	if section, err = f.Section(entry); err != nil {
+		return nil, err
+	}
+	nReader = bufio.NewReader(section)
+	for {
+		if line, err = readfile.Readln(nReader); err != nil {
+			break
+		}
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "(Particle") {
+			// The stories of the root come before its children
+			if particles++; particles > 1 {
+				break
+			}
+		} else if particles == 1 && trimmed == "("+name && len(lines) == 0 {
+			lines = append(lines, line)
+		} else if len(lines) > 0 {
+			lines = append(lines, line)
+			if trimmed == ")"+name {
+				sec, _, err = parseSection(lines, 0)
+				return sec, err
+			}
+		}
+	}
+	return nil, fmt.Errorf("no %v in the root particle of timestep %v", name, entry.Timestep)
+}
+
+// Close closes the file.
+func (f *SnapFile) Close() error {
+	return f.inFile.Close()
+}
+
+// SnapIndexReport prints the index of the STDOUT or STDERR inFileName.
+func SnapIndexReport(inFileName string) {
+	var (
+		index *SnapIndex
+		err   error
+	)
+	if index, err = LoadSnapIndex(inFileName, stdKind(inFileName)); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Index of %v, %v entries, %v bytes modified at %v\n", inFileName, len(index.Entries),
+		index.Size, index.ModTime.Format("2006-01-02 15:04:05"))
+	tab := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tab, "TIMESTEP\tOFFSET\tLENGTH\tCOMPLETE")
+	for _, entry := range index.Entries {
+		fmt.Fprintf(tab, "%v\t%v\t%v\t%v\n", entry.Timestep, entry.Offset, entry.Length, entry.Integrity)
+	}
+	tab.Flush()
+}
+
+// SnapGet prints the snapshot at timestep of the STDOUT, or the timestep of
+// the STDERR, inFileName, as it is in the file.
+func SnapGet(inFileName, timestep string) {
+	var (
+		file  *SnapFile
+		entry *SnapEntry
+		err   error
+	)
+	if file, err = OpenSnapFile(inFileName, stdKind(inFileName)); err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	if entry = file.Find(timestep); entry == nil {
+		log.Fatalf("No complete timestep %v in %v\n", timestep, inFileName)
+	}
+	if err = file.WriteEntry(os.Stdout, entry); err != nil {
+		log.Fatal(err)
+	}
+}
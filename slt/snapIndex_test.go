@@ -0,0 +1,112 @@
+package slt
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapIndexOffsets(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		tests = []struct {
+			fileName string
+			data     string
+			kind     string
+			// snapshots are the complete entries, a truncated one follows
+			// if truncated.
+			snapshots []string
+			truncated bool
+		}{
+			{"out-x.txt", outData(0, 4, false), "out", strings.SplitAfter(outData(0, 4, false), ")Particle\n)Particle\n")[:4], false},
+			{"out-x.txt.gz", outData(0, 4, true), "out", strings.SplitAfter(outData(0, 4, false), ")Particle\n)Particle\n")[:4], true},
+			{"err-x.txt", errData(0, 3), "err", strings.SplitAfter(errData(0, 3), errEndOfSnap+"\n")[:3], true},
+		}
+	)
+	for _, test := range tests {
+		var (
+			path  = filepath.Join(dir, test.fileName)
+			file  *SnapFile
+			entry *SnapEntry
+			out   bytes.Buffer
+			err   error
+		)
+		writeStd(t, path, test.data)
+		if kind := stdKind(path); kind != test.kind {
+			t.Errorf("%v: kind %v, want %v", test.fileName, kind, test.kind)
+		}
+		if file, err = OpenSnapFile(path, test.kind); err != nil {
+			t.Fatal(err)
+		}
+		if entries := len(test.snapshots); test.truncated && len(file.Entries) != entries+1 || !test.truncated && len(file.Entries) != entries {
+			t.Fatalf("%v: %v entries", test.fileName, len(file.Entries))
+		}
+		if last := file.Entries[len(file.Entries)-1]; last.Integrity == test.truncated {
+			t.Errorf("%v: last entry %+v", test.fileName, *last)
+		}
+		// Backward, to read the compressed files again from the start
+		for idx := len(test.snapshots) - 1; idx >= 0; idx-- {
+			entry = file.Entries[idx]
+			if entry.Offset != int64(len(strings.Join(test.snapshots[:idx], ""))) || entry.Length != int64(len(test.snapshots[idx])) {
+				t.Errorf("%v: entry %v at %v+%v", test.fileName, idx, entry.Offset, entry.Length)
+			}
+			if file.Find(entry.Timestep) != entry {
+				t.Errorf("%v: Find(%v) is not entry %v", test.fileName, entry.Timestep, idx)
+			}
+			out.Reset()
+			if err = file.WriteEntry(&out, entry); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != test.snapshots[idx] {
+				t.Errorf("%v: entry %v is\n%v", test.fileName, idx, out.String())
+			}
+		}
+		file.Close()
+	}
+}
+
+func TestSnapIndexRebuild(t *testing.T) {
+	var (
+		path  = filepath.Join(t.TempDir(), "out-x.txt")
+		index *SnapIndex
+		file  *os.File
+		err   error
+	)
+	writeStd(t, path, outData(0, 2, true))
+	if index, err = LoadSnapIndex(path, "out"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(IndexName(path)); err != nil {
+		t.Fatal("index not saved: ", err)
+	}
+	if len(index.Entries) != 3 || index.Find("1") == nil || index.Cut("7") != index.Find("1") {
+		t.Fatalf("entries %v", len(index.Entries))
+	}
+
+	// A saved index is used as it is while the file doesn't change
+	index.Entries = index.Entries[:1]
+	if err = index.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if index, err = LoadSnapIndex(path, "out"); err != nil || len(index.Entries) != 1 {
+		t.Fatalf("saved index not used: %v", err)
+	}
+
+	// kira goes on writing, completing snapshot 3 and adding another one,
+	// the index is rebuilt
+	time.Sleep(10 * time.Millisecond)
+	if file, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644); err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(testSnapshot[300:] + outData(4, 1, false))
+	file.Close()
+	if index, err = LoadSnapIndex(path, "out"); err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Entries) != 4 || !index.Entries[3].Integrity || index.Find("4") != index.Entries[3] {
+		t.Errorf("index not rebuilt: %v entries", len(index.Entries))
+	}
+}
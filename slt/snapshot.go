@@ -0,0 +1,369 @@
+package slt
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brunetto/goutils/debug"
+)
+
+// Section is one of the stories (Log, Dynamics, Hydro, Star) attached to
+// a StarLab particle. The raw lines are kept so that the snapshot can be
+// written back exactly as it was read.
+type Section struct {
+	// Name is the story name without parentheses, i.e. "Dynamics".
+	Name string
+	// Lines are the raw lines between "(Name" and ")Name".
+	Lines []string
+	// open and close are the raw tag lines, if read from a file.
+	open, close string
+}
+
+// Get returns the value of the first "key = value" line of the section
+// with the given key.
+func (sec *Section) Get(key string) (string, bool) {
+	if sec == nil {
+		return "", false
+	}
+	for _, line := range sec.Lines {
+		if k, v, ok := splitKeyValue(line); ok && k == key {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// Float returns the value of key parsed as a float64.
+func (sec *Section) Float(key string) (float64, bool) {
+	var (
+		value string
+		f     float64
+		ok    bool
+		err   error
+	)
+	if value, ok = sec.Get(key); !ok {
+		return 0, false
+	}
+	if f, err = strconv.ParseFloat(value, 64); err != nil {
+		return 0, false
+	}
+	return f, true
+}
+
+// Vector returns the value of key parsed as a three components vector
+// (r, v, a in the Dynamics story).
+func (sec *Section) Vector(key string) ([3]float64, bool) {
+	var (
+		vec    [3]float64
+		value  string
+		fields []string
+		ok     bool
+		err    error
+	)
+	if value, ok = sec.Get(key); !ok {
+		return vec, false
+	}
+	if fields = strings.Fields(value); len(fields) != 3 {
+		return vec, false
+	}
+	for idx, field := range fields {
+		if vec[idx], err = strconv.ParseFloat(field, 64); err != nil {
+			return vec, false
+		}
+	}
+	return vec, true
+}
+
+// Particle is a node of the StarLab tree: the root of the cluster,
+// a center of mass (binary, multiple) or a single star.
+type Particle struct {
+	// I is the particle index ("i = "), 0 if not present.
+	I int
+	// Name is the particle name ("name = "), for single stars usually
+	// equal to the index, for binaries in the form "(a,b)".
+	Name string
+	// N is the number of leaves below this node ("N = ").
+	N int
+	// Mass, R, V and A come from the Dynamics story (m, r, v, a).
+	Mass    float64
+	R, V, A [3]float64
+
+	Log      *Section
+	Dynamics *Section
+	Hydro    *Section
+	Star     *Section
+
+	Parent   *Particle
+	Children []*Particle
+
+	// Header contains the raw lines between "(Particle" and the first story.
+	Header []string
+	// items keeps the order in which stories, children and stray lines
+	// were read so that WriteSnapshot reproduces the original text.
+	items       []interface{}
+	open, close string
+}
+
+// IsRoot tells whether the particle is the root of the tree.
+func (p *Particle) IsRoot() bool {
+	return p.Parent == nil
+}
+
+// IsLeaf tells whether the particle is a single star.
+func (p *Particle) IsLeaf() bool {
+	return len(p.Children) == 0
+}
+
+// Leaves returns all the single stars below (and including) p.
+func (p *Particle) Leaves() []*Particle {
+	if p.IsLeaf() {
+		return []*Particle{p}
+	}
+	leaves := []*Particle{}
+	for _, child := range p.Children {
+		leaves = append(leaves, child.Leaves()...)
+	}
+	return leaves
+}
+
+// Walk calls fn for p and all its descendants, depth first.
+func (p *Particle) Walk(fn func(*Particle)) {
+	fn(p)
+	for _, child := range p.Children {
+		child.Walk(fn)
+	}
+}
+
+// Snapshot is a complete StarLab snapshot parsed into a tree of particles.
+type Snapshot struct {
+	// Timestep is the root system_time, "-1" if not present (e.g. ICs).
+	Timestep string
+	// Preamble contains the lines found before the root particle
+	// (kiraWrap headers and so on).
+	Preamble []string
+	// Root is the root particle of the tree.
+	Root *Particle
+	// trailer contains the lines after the root particle.
+	trailer []string
+}
+
+// Parse builds the particle tree from the lines of a DumbSnapshot.
+func (snap *DumbSnapshot) Parse() (*Snapshot, error) {
+	return ParseSnapshot(snap.Lines)
+}
+
+// ParseSnapshot builds the particle tree from the lines of one
+// StarLab snapshot.
+func ParseSnapshot(lines []string) (*Snapshot, error) {
+	if Debug {
+		defer debug.TimeMe(time.Now())
+	}
+	var (
+		snap    = &Snapshot{Timestep: "-1"}
+		idx     int
+		err     error
+		sysTime string
+		ok      bool
+	)
+
+	// Skip what comes before the root particle
+	for idx = 0; idx < len(lines); idx++ {
+		if strings.HasPrefix(strings.TrimSpace(lines[idx]), "(Particle") {
+			break
+		}
+		snap.Preamble = append(snap.Preamble, lines[idx])
+	}
+	if idx == len(lines) {
+		return nil, fmt.Errorf("no particle found in %v lines", len(lines))
+	}
+
+	if snap.Root, idx, err = parseParticle(lines, idx, nil); err != nil {
+		return nil, err
+	}
+	for ; idx < len(lines); idx++ {
+		if strings.TrimSpace(lines[idx]) != "" {
+			return nil, fmt.Errorf("unexpected line %v after the root particle: %v", idx+1, lines[idx])
+		}
+		snap.trailer = append(snap.trailer, lines[idx])
+	}
+
+	if sysTime, ok = snap.Root.Dynamics.Get("system_time"); ok {
+		snap.Timestep = sysTime
+	}
+	return snap, nil
+}
+
+// parseParticle parses the particle starting at lines[start] ("(Particle")
+// and returns it with the index of the line following ")Particle".
+func parseParticle(lines []string, start int, parent *Particle) (*Particle, int, error) {
+	var (
+		p       = &Particle{Parent: parent, open: lines[start]}
+		idx     int
+		line    string
+		child   *Particle
+		sec     *Section
+		err     error
+		inHead  = true
+		ok      bool
+		tmpInt  int64
+		tmpVec  [3]float64
+		tmpMass float64
+	)
+
+	for idx = start + 1; idx < len(lines); idx++ {
+		line = strings.TrimSpace(lines[idx])
+		switch {
+		case strings.HasPrefix(line, ")Particle"):
+			p.close = lines[idx]
+			if p.Dynamics != nil {
+				if tmpMass, ok = p.Dynamics.Float("m"); ok {
+					p.Mass = tmpMass
+				}
+				if tmpVec, ok = p.Dynamics.Vector("r"); ok {
+					p.R = tmpVec
+				}
+				if tmpVec, ok = p.Dynamics.Vector("v"); ok {
+					p.V = tmpVec
+				}
+				if tmpVec, ok = p.Dynamics.Vector("a"); ok {
+					p.A = tmpVec
+				}
+			}
+			return p, idx + 1, nil
+		case strings.HasPrefix(line, "(Particle"):
+			inHead = false
+			if child, idx, err = parseParticle(lines, idx, p); err != nil {
+				return nil, idx, err
+			}
+			idx-- // the loop increment would skip the line after ")Particle"
+			p.Children = append(p.Children, child)
+			p.items = append(p.items, child)
+		case strings.HasPrefix(line, "("):
+			inHead = false
+			if sec, idx, err = parseSection(lines, idx); err != nil {
+				return nil, idx, err
+			}
+			idx--
+			switch sec.Name {
+			case "Log":
+				p.Log = sec
+			case "Dynamics":
+				p.Dynamics = sec
+			case "Hydro":
+				p.Hydro = sec
+			case "Star":
+				p.Star = sec
+			}
+			p.items = append(p.items, sec)
+		case inHead:
+			p.Header = append(p.Header, lines[idx])
+			if key, value, found := splitKeyValue(line); found {
+				switch key {
+				case "i":
+					if tmpInt, err = strconv.ParseInt(value, 10, 64); err == nil {
+						p.I = int(tmpInt)
+					}
+				case "name":
+					p.Name = value
+				case "N":
+					if tmpInt, err = strconv.ParseInt(value, 10, 64); err == nil {
+						p.N = int(tmpInt)
+					}
+				}
+			}
+		case line == "":
+			p.items = append(p.items, lines[idx])
+		default:
+			return nil, idx, fmt.Errorf("unexpected line %v inside particle: %v", idx+1, lines[idx])
+		}
+	}
+	return nil, idx, fmt.Errorf("particle starting at line %v is not closed", start+1)
+}
+
+// parseSection parses the story starting at lines[start] ("(Name") and
+// returns it with the index of the line following ")Name".
+func parseSection(lines []string, start int) (*Section, int, error) {
+	var (
+		sec   = &Section{Name: strings.TrimPrefix(strings.TrimSpace(lines[start]), "("), open: lines[start]}
+		depth = 0
+		line  string
+	)
+	for idx := start + 1; idx < len(lines); idx++ {
+		line = strings.TrimSpace(lines[idx])
+		// Stories can contain sub-stories, only the matching closing
+		// tag at depth 0 ends the section
+		if strings.HasPrefix(line, "(") {
+			depth++
+		} else if strings.HasPrefix(line, ")") {
+			if depth == 0 {
+				if line != ")"+sec.Name {
+					return nil, idx, fmt.Errorf("line %v closes %v inside %v", idx+1, line, sec.Name)
+				}
+				sec.close = lines[idx]
+				return sec, idx + 1, nil
+			}
+			depth--
+		}
+		sec.Lines = append(sec.Lines, lines[idx])
+	}
+	return nil, len(lines), fmt.Errorf("story %v starting at line %v is not closed", sec.Name, start+1)
+}
+
+// splitKeyValue splits a "key = value" story line.
+func splitKeyValue(line string) (key, value string, ok bool) {
+	var idx int
+	if idx = strings.Index(line, "="); idx < 0 {
+		return "", "", false
+	}
+	return strings.TrimSpace(line[:idx]), strings.TrimSpace(line[idx+1:]), true
+}
+
+// Lines returns the snapshot as StarLab text, one line per element.
+func (snap *Snapshot) Lines() []string {
+	lines := append([]string{}, snap.Preamble...)
+	lines = snap.Root.appendLines(lines)
+	return append(lines, snap.trailer...)
+}
+
+func (p *Particle) appendLines(lines []string) []string {
+	lines = append(lines, orDefault(p.open, "(Particle"))
+	lines = append(lines, p.Header...)
+	for _, item := range p.items {
+		switch item := item.(type) {
+		case *Section:
+			lines = append(lines, orDefault(item.open, "("+item.Name))
+			lines = append(lines, item.Lines...)
+			lines = append(lines, orDefault(item.close, ")"+item.Name))
+		case *Particle:
+			lines = item.appendLines(lines)
+		case string:
+			lines = append(lines, item)
+		}
+	}
+	return append(lines, orDefault(p.close, ")Particle"))
+}
+
+// orDefault returns the raw tag line if known, else the canonical one.
+func orDefault(raw, canonical string) string {
+	if raw == "" {
+		return canonical
+	}
+	return raw
+}
+
+// WriteSnapshot writes the snapshot back in the StarLab format it was read from.
+// The text comes from the raw lines, so changes to the typed fields are not written.
+func (snap *Snapshot) WriteSnapshot(nWriter *bufio.Writer) (err error) {
+	if Debug {
+		defer debug.TimeMe(time.Now())
+	}
+	for _, line := range snap.Lines() {
+		if _, err = nWriter.WriteString(line + "\n"); err != nil {
+			return err
+		}
+	}
+	return nWriter.Flush()
+}
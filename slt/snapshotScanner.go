@@ -0,0 +1,239 @@
+package slt
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/brunetto/goutils/readfile"
+)
+
+// Errors reported by the snapshot readers. They are wrapped in a
+// *SnapshotError, use errors.Is to check for them.
+var (
+	// ErrTruncatedSnapshot means the input ended in the middle of a snapshot.
+	ErrTruncatedSnapshot = errors.New("truncated snapshot")
+	// ErrMissingRoot means a snapshot was closed without a root particle.
+	ErrMissingRoot = errors.New("no root particle in a snapshot that seems complete")
+	// ErrUnbalancedNesting means a particle was closed without being opened.
+	ErrUnbalancedNesting = errors.New("unbalanced particle nesting")
+)
+
+var (
+	outSysTimeReg = regexp.MustCompile(`system_time\s*=\s*(\d+)`)
+	errSysTimeReg = regexp.MustCompile(`^Time = (\d+)`)
+)
+
+// errEndOfSnap marks the end of a timestep in the STDERR.
+const errEndOfSnap = "----------------------------------------"
+
+// SnapshotError describes a corrupted snapshot.
+type SnapshotError struct {
+	// Timestep is the last timestep read, if any.
+	Timestep string
+	// Line is the input line number where the problem was detected.
+	Line int64
+	// Snapshot contains what was read of the bad snapshot.
+	Snapshot *DumbSnapshot
+	Err      error
+}
+
+func (e *SnapshotError) Error() string {
+	return fmt.Sprintf("timestep %v, line %v: %v", e.Timestep, e.Line, e.Err)
+}
+
+func (e *SnapshotError) Unwrap() error {
+	return e.Err
+}
+
+// SnapshotScanner reads one snapshot at a time from a STDOUT or a STDERR.
+// Use it like:
+//
+//	scanner := NewOutSnapshotScanner(reader)
+//	for scanner.Scan() {
+//		snap := scanner.Snapshot()
+//	}
+//	if err := scanner.Err(); err != nil {
+//		...
+//	}
+type SnapshotScanner struct {
+	nReader *bufio.Reader
+	read    func(*bufio.Reader, *int64) (*DumbSnapshot, error)
+	snap    *DumbSnapshot
+	err     error
+	line    int64
+}
+
+// NewOutSnapshotScanner returns a scanner over the snapshots of a STDOUT.
+func NewOutSnapshotScanner(r io.Reader) *SnapshotScanner {
+	return &SnapshotScanner{nReader: toBufio(r), read: readOutSnapshot}
+}
+
+// NewICsSnapshotScanner returns a scanner over the snapshots of an ICs file.
+// ICs written by the StarLab tools have no "name = root" line,
+// so the root check is skipped.
+func NewICsSnapshotScanner(r io.Reader) *SnapshotScanner {
+	return &SnapshotScanner{nReader: toBufio(r), read: readICsSnapshot}
+}
+
+// NewErrSnapshotScanner returns a scanner over the timesteps of a STDERR.
+func NewErrSnapshotScanner(r io.Reader) *SnapshotScanner {
+	return &SnapshotScanner{nReader: toBufio(r), read: readErrSnapshot}
+}
+
+func toBufio(r io.Reader) *bufio.Reader {
+	if nReader, ok := r.(*bufio.Reader); ok {
+		return nReader
+	}
+	return bufio.NewReader(r)
+}
+
+// Scan reads the next complete snapshot. It returns false at the end of
+// the input or at the first corrupted snapshot, see Err.
+func (s *SnapshotScanner) Scan() bool {
+	if s.err != nil {
+		return false
+	}
+	s.snap, s.err = s.read(s.nReader, &s.line)
+	return s.err == nil
+}
+
+// Snapshot returns the last snapshot read by Scan. After a failed Scan
+// it contains the incomplete snapshot, if any.
+func (s *SnapshotScanner) Snapshot() *DumbSnapshot {
+	return s.snap
+}
+
+// Err returns the first error found, nil if the input ended cleanly.
+func (s *SnapshotScanner) Err() error {
+	if s.err == io.EOF {
+		return nil
+	}
+	return s.err
+}
+
+// Line returns the number of lines read so far.
+func (s *SnapshotScanner) Line() int64 {
+	return s.line
+}
+
+// readOutSnapshot reads one STDOUT snapshot without side effects.
+// It returns io.EOF if the input ends outside of a snapshot.
+func readOutSnapshot(nReader *bufio.Reader, lineNo *int64) (*DumbSnapshot, error) {
+	return readParticleSnapshot(nReader, lineNo, true)
+}
+
+// readICsSnapshot is readOutSnapshot without the root check.
+func readICsSnapshot(nReader *bufio.Reader, lineNo *int64) (*DumbSnapshot, error) {
+	return readParticleSnapshot(nReader, lineNo, false)
+}
+
+func readParticleSnapshot(nReader *bufio.Reader, lineNo *int64, requireRoot bool) (*DumbSnapshot, error) {
+	var (
+		snap              = &DumbSnapshot{Lines: make([]string, 0)}
+		line              string
+		err               error
+		resSysTime        []string
+		cumulativeNesting int = 0
+	)
+
+	for {
+		// Read line by line
+		if line, err = readfile.Readln(nReader); err != nil {
+			if err == io.EOF && cumulativeNesting != 0 {
+				err = ErrTruncatedSnapshot
+			}
+			if err != io.EOF {
+				err = &SnapshotError{Timestep: snap.Timestep, Line: *lineNo, Snapshot: snap, Err: err}
+			}
+			return snap, err
+		}
+		*lineNo++
+
+		// Add line to the snapshots in memory
+		snap.Lines = append(snap.Lines, line)
+
+		// Search for timestep number
+		if resSysTime = outSysTimeReg.FindStringSubmatch(line); resSysTime != nil {
+			snap.Timestep = resSysTime[1]
+		}
+
+		// Check if entering or exiting a particle
+		// and update the nesting level
+		if strings.Contains(line, "(Particle") {
+			snap.NestingLevel++
+			cumulativeNesting++
+			if snap.NestingLevel > snap.MaxNesting {
+				snap.MaxNesting = snap.NestingLevel
+			}
+		} else if strings.Contains(line, ")Particle") {
+			if snap.NestingLevel == 0 {
+				return snap, &SnapshotError{Timestep: snap.Timestep, Line: *lineNo, Snapshot: snap, Err: ErrUnbalancedNesting}
+			}
+			snap.NestingLevel--
+		}
+
+		// Doesn't work with ICs because they are without name = root grrrr
+		if strings.Contains(line, "name = root") {
+			snap.CheckRoot = true
+		}
+
+		// The whole snapshot is in memory when the root particle is closed.
+		// We need cumulative nesting in case of a header: before the
+		// particles section the nesting is 0 too.
+		if snap.NestingLevel == 0 && cumulativeNesting != 0 {
+			if requireRoot && !snap.CheckRoot {
+				return snap, &SnapshotError{Timestep: snap.Timestep, Line: *lineNo, Snapshot: snap, Err: ErrMissingRoot}
+			}
+			snap.Integrity = true
+			return snap, nil
+		}
+	}
+}
+
+// readErrSnapshot reads one STDERR timestep without side effects.
+// It returns io.EOF if the input ends before a new timestep begins.
+func readErrSnapshot(nReader *bufio.Reader, lineNo *int64) (*DumbSnapshot, error) {
+	var (
+		snap       = &DumbSnapshot{Lines: make([]string, 0), Timestep: "-1"}
+		line       string
+		err        error
+		resSysTime []string
+		started    bool
+	)
+
+	for {
+		// Read line by line
+		if line, err = readfile.Readln(nReader); err != nil {
+			if err == io.EOF && started {
+				err = ErrTruncatedSnapshot
+			}
+			if err != io.EOF {
+				err = &SnapshotError{Timestep: snap.Timestep, Line: *lineNo, Snapshot: snap, Err: err}
+			}
+			return snap, err
+		}
+		*lineNo++
+
+		// Add line to the snapshots in memory
+		snap.Lines = append(snap.Lines, line)
+		// kiraWrap header and footer lines don't start a timestep
+		if trimmed := strings.TrimSpace(line); trimmed != "" &&
+			!strings.HasPrefix(trimmed, "#") && !strings.HasPrefix(trimmed, "==") {
+			started = true
+		}
+
+		// Search for timestep number
+		if resSysTime = errSysTimeReg.FindStringSubmatch(line); resSysTime != nil {
+			snap.Timestep = resSysTime[1]
+		}
+
+		if strings.Contains(line, errEndOfSnap) {
+			snap.Integrity = true
+			return snap, nil
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package slt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testSnapshot is a STDOUT snapshot with a root and two stars,
+// outData changes its timestep and root position.
+const testSnapshot = `#==============================
+(Particle
+  name = root
+  N = 2
+(Log
+  ===>  Mon Jan  6 2014
+)Log
+(Dynamics
+  system_time  =  3
+  m  =  1
+  r  =  0 0 0
+  v  =  0 0 0
+)Dynamics
+(Hydro
+)Hydro
+(Star
+  mass_scale     =  0.0001
+  size_scale     =  2.25e-08
+  time_scale     =  4.0
+)Star
+(Particle
+  i = 1
+  N = 1
+(Log
+)Log
+(Dynamics
+  m  =  0.5
+  r  =  1 2 3
+  v  =  0.1 0.2 0.3
+  a  =  0 0 -1e-3
+)Dynamics
+(Hydro
+)Hydro
+(Star
+)Star
+)Particle
+(Particle
+  i = 2
+  N = 1
+(Log
+)Log
+(Dynamics
+  m  =  0.5
+  r  =  -1 -2 -3
+  v  =  0 0 0
+)Dynamics
+(Hydro
+)Hydro
+(Star
+)Star
+)Particle
+)Particle
+`
+
+// outData returns n STDOUT snapshots with timesteps from, from+1, ...
+// and the root at x = timestep. If truncate, the beginning of one more
+// snapshot follows.
+func outData(from, n int, truncate bool) string {
+	var data strings.Builder
+	for idx := from; idx < from+n; idx++ {
+		snap := strings.Replace(testSnapshot, "system_time  =  3", fmt.Sprintf("system_time  =  %d", idx), 1)
+		data.WriteString(strings.Replace(snap, "r  =  0 0 0", fmt.Sprintf("r  =  %d 0 0", idx), 1))
+	}
+	if truncate {
+		data.WriteString(testSnapshot[:300])
+	}
+	return data.String()
+}
+
+// errData returns a STDERR with n timesteps from from, the last one
+// (Time = 99) not complete.
+func errData(from, n int) string {
+	var data strings.Builder
+	data.WriteString("initial random seed = 42\n")
+	for idx := from; idx < from+n; idx++ {
+		fmt.Fprintf(&data, "Time = %d\n  step %d\n%v\n", idx, idx, errEndOfSnap)
+	}
+	data.WriteString("Time = 99\n  partial\n")
+	return data.String()
+}
+
+func TestOutSnapshotScanner(t *testing.T) {
+	var tests = []struct {
+		name      string
+		input     string
+		timesteps []string
+		err       error
+	}{
+		{"empty", "", nil, nil},
+		{"complete", outData(0, 3, false), []string{"0", "1", "2"}, nil},
+		{"footer", outData(5, 1, false) + "#footer\n", []string{"5"}, nil},
+		{"truncated", outData(0, 2, true), []string{"0", "1"}, ErrTruncatedSnapshot},
+		{"missing root", strings.Replace(testSnapshot, "name = root", "", 1), nil, ErrMissingRoot},
+		{"unbalanced", outData(0, 1, false) + ")Particle\n", []string{"0"}, ErrUnbalancedNesting},
+	}
+	for _, test := range tests {
+		var (
+			scanner   = NewOutSnapshotScanner(strings.NewReader(test.input))
+			timesteps []string
+		)
+		for scanner.Scan() {
+			if !scanner.Snapshot().Integrity || !scanner.Snapshot().CheckRoot {
+				t.Errorf("%v: snapshot %v not marked complete", test.name, scanner.Snapshot().Timestep)
+			}
+			timesteps = append(timesteps, scanner.Snapshot().Timestep)
+		}
+		if fmt.Sprint(timesteps) != fmt.Sprint(test.timesteps) {
+			t.Errorf("%v: timesteps %v, want %v", test.name, timesteps, test.timesteps)
+		}
+		if err := scanner.Err(); !errors.Is(err, test.err) || (err == nil) != (test.err == nil) {
+			t.Errorf("%v: error %v, want %v", test.name, err, test.err)
+		}
+		if scanner.Scan() {
+			t.Errorf("%v: Scan goes on after the end", test.name)
+		}
+	}
+}
+
+func TestSnapshotErrorLine(t *testing.T) {
+	var (
+		scanner = NewOutSnapshotScanner(strings.NewReader(outData(0, 1, true)))
+		snapErr *SnapshotError
+		lines   = int64(strings.Count(outData(0, 1, true), "\n"))
+	)
+	for scanner.Scan() {
+	}
+	if !errors.As(scanner.Err(), &snapErr) {
+		t.Fatalf("error %v is not a *SnapshotError", scanner.Err())
+	}
+	if snapErr.Line != lines || scanner.Line() != lines {
+		t.Errorf("error at line %v, read %v, want %v", snapErr.Line, scanner.Line(), lines)
+	}
+	if snapErr.Snapshot == nil || snapErr.Snapshot.Integrity {
+		t.Errorf("the truncated snapshot should be in the error: %+v", snapErr.Snapshot)
+	}
+}
+
+func TestICsSnapshotScanner(t *testing.T) {
+	var (
+		ics     = strings.Replace(strings.Replace(testSnapshot, "name = root", "", 1), "system_time  =  3", "", 1)
+		scanner = NewICsSnapshotScanner(strings.NewReader(ics))
+	)
+	if !scanner.Scan() {
+		t.Fatalf("ICs without root not read: %v", scanner.Err())
+	}
+	if scanner.Snapshot().Timestep != "" || len(scanner.Snapshot().Lines) != strings.Count(ics, "\n") {
+		t.Errorf("timestep %q and %v lines, want none and %v", scanner.Snapshot().Timestep,
+			len(scanner.Snapshot().Lines), strings.Count(ics, "\n"))
+	}
+	if scanner.Scan() || scanner.Err() != nil {
+		t.Errorf("one snapshot expected, error %v", scanner.Err())
+	}
+}
+
+func TestErrSnapshotScanner(t *testing.T) {
+	var tests = []struct {
+		name      string
+		input     string
+		timesteps []string
+		err       error
+	}{
+		{"empty", "", nil, nil},
+		{"truncated", errData(0, 3), []string{"0", "1", "2"}, ErrTruncatedSnapshot},
+		{"complete", strings.TrimSuffix(errData(4, 2), "Time = 99\n  partial\n"), []string{"4", "5"}, nil},
+		{"wrapper footer", strings.TrimSuffix(errData(0, 1), "Time = 99\n  partial\n") + "==> kiraWrap exit\n", []string{"0"}, nil},
+	}
+	for _, test := range tests {
+		var (
+			scanner   = NewErrSnapshotScanner(strings.NewReader(test.input))
+			timesteps []string
+		)
+		for scanner.Scan() {
+			timesteps = append(timesteps, scanner.Snapshot().Timestep)
+		}
+		if fmt.Sprint(timesteps) != fmt.Sprint(test.timesteps) {
+			t.Errorf("%v: timesteps %v, want %v", test.name, timesteps, test.timesteps)
+		}
+		if err := scanner.Err(); !errors.Is(err, test.err) || (err == nil) != (test.err == nil) {
+			t.Errorf("%v: error %v, want %v", test.name, err, test.err)
+		}
+	}
+}
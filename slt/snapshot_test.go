@@ -0,0 +1,244 @@
+package slt
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// kiraOut is a kira STDOUT snapshot with a binary and a single star.
+const kiraOut = `#==============================
+(Particle
+  N = 3
+(Log
+  ===>  Fri Mar 14 10:10:51 2014
+       Starlab 4.4.4 (user brunetto) : kira -t 500 -d 1 -D 1 -f 0 -n 10 -e 0 -B -b 1
+)Log
+(Dynamics
+  system_time  =  12.5
+  m  =  1
+  r  =  0 0 0
+  v  =  0 0 0
+  com_pos  =  1.2e-05 -3.4e-06 0
+  total_energy  =  -0.2500123
+)Dynamics
+(Hydro
+)Hydro
+(Star
+  mass_scale     =  0.001761158
+  size_scale     =  2.255e-08
+  time_scale     =  0.4216
+)Star
+(Particle
+  name = (1,2)
+  N = 2
+(Log
+)Log
+(Dynamics
+  m  =  0.6
+  r  =  0.12 -0.5 0.03
+  v  =  0.4 0.01 -0.2
+)Dynamics
+(Hydro
+)Hydro
+(Star
+)Star
+(Particle
+  i = 1
+  N = 1
+(Log
+)Log
+(Dynamics
+  m  =  0.4
+  r  =  0.001 0 0
+  v  =  0 0.9 0
+  a  =  -0.8 0 0
+)Dynamics
+(Hydro
+)Hydro
+(Star
+  Type   =  main_sequence
+  T_cur  =  12.5
+  M_rel  =  35.2
+)Star
+)Particle
+(Particle
+  i = 2
+  N = 1
+(Log
+)Log
+(Dynamics
+  m  =  0.2
+  r  =  -0.002 0 0
+  v  =  0 -1.8 0
+  a  =  1.6 0 0
+)Dynamics
+(Hydro
+)Hydro
+(Star
+  Type   =  black_hole
+  T_cur  =  12.5
+  M_rel  =  17.6
+)Star
+)Particle
+)Particle
+(Particle
+  i = 3
+  N = 1
+(Log
+)Log
+(Dynamics
+  m  =  0.4
+  r  =  -0.18 0.75 -0.045
+  v  =  -0.6 -0.015 0.3
+)Dynamics
+(Hydro
+)Hydro
+(Star
+)Star
+)Particle
+)Particle
+`
+
+// kiraICs are ICs as written by makeking and friends: no kiraWrap
+// header and no system_time.
+const kiraICs = `(Particle
+  N = 2
+(Log
+  ===>  Mon Jan  6 11:35:52 2014
+       Starlab 4.4.4 (user brunetto) : makeking -n 2 -w 5 -i -u -s 1
+  initial_mass  =  1
+  initial_rvirial  =  1
+)Log
+(Dynamics
+  m  =  1
+  r  =  0 0 0
+  v  =  0 0 0
+  total_energy  =  -0.25
+)Dynamics
+(Hydro
+)Hydro
+(Star
+)Star
+(Particle
+  i = 1
+  N = 1
+(Log
+)Log
+(Dynamics
+  m  =  0.7
+  r  =  0.3 0.1 -0.2
+  v  =  -0.1 0.2 0
+)Dynamics
+(Hydro
+)Hydro
+(Star
+)Star
+)Particle
+(Particle
+  i = 2
+  N = 1
+(Log
+)Log
+(Dynamics
+  m  =  0.3
+  r  =  -0.7 -0.233333 0.466667
+  v  =  0.233333 -0.466667 0
+)Dynamics
+(Hydro
+)Hydro
+(Star
+)Star
+)Particle
+)Particle
+`
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	var tests = []struct {
+		name     string
+		text     string
+		timestep string
+		preamble int
+		// children are the names (or indexes) of the root children,
+		// with their N.
+		children []string
+		n        []int
+		leaves   int
+	}{
+		{"STDOUT", kiraOut, "12.5", 1, []string{"(1,2)", "3"}, []int{2, 1}, 3},
+		{"ICs", kiraICs, "-1", 0, []string{"1", "2"}, []int{1, 1}, 2},
+	}
+	for _, test := range tests {
+		var (
+			snap *Snapshot
+			out  bytes.Buffer
+			err  error
+		)
+		if snap, err = ParseSnapshot(strings.Split(strings.TrimSuffix(test.text, "\n"), "\n")); err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		if err = snap.WriteSnapshot(bufio.NewWriter(&out)); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != test.text {
+			t.Errorf("%v written back as\n%v", test.name, out.String())
+		}
+
+		if snap.Timestep != test.timestep || len(snap.Preamble) != test.preamble {
+			t.Errorf("%v: timestep %v, %v preamble lines", test.name, snap.Timestep, len(snap.Preamble))
+		}
+		root := snap.Root
+		if !root.IsRoot() || root.N != test.leaves || len(root.Children) != len(test.children) || len(root.Leaves()) != test.leaves {
+			t.Fatalf("%v: root N %v, %v children, %v leaves", test.name, root.N, len(root.Children), len(root.Leaves()))
+		}
+		for idx, child := range root.Children {
+			name := child.Name
+			if name == "" {
+				name = strconv.Itoa(child.I)
+			}
+			if name != test.children[idx] || child.N != test.n[idx] || child.Parent != root {
+				t.Errorf("%v: child %v is %v with N %v", test.name, idx, name, child.N)
+			}
+		}
+		// N is the number of leaves at each node and the masses add up
+		root.Walk(func(p *Particle) {
+			if p.N != len(p.Leaves()) {
+				t.Errorf("%v: particle %v%v has N %v and %v leaves", test.name, p.Name, p.I, p.N, len(p.Leaves()))
+			}
+			if p.IsLeaf() {
+				return
+			}
+			var mass float64
+			for _, child := range p.Children {
+				mass += child.Mass
+			}
+			if diff := mass - p.Mass; diff > 1e-12 || diff < -1e-12 {
+				t.Errorf("%v: particle %v%v has mass %v, children %v", test.name, p.Name, p.I, p.Mass, mass)
+			}
+		})
+		if root.Log == nil || root.Hydro == nil || root.Star == nil || len(root.Log.Lines) < 2 {
+			t.Errorf("%v: root stories missing", test.name)
+		}
+		if energy, ok := root.Dynamics.Float("total_energy"); !ok || energy > -0.25 || energy < -0.26 {
+			t.Errorf("%v: root total_energy %v, %v", test.name, energy, ok)
+		}
+	}
+
+	// The stories of the stars below the binary
+	snap, err := ParseSnapshot(strings.Split(strings.TrimSuffix(kiraOut, "\n"), "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scale, ok := snap.Root.Star.Float("mass_scale"); !ok || scale != 0.001761158 {
+		t.Errorf("mass_scale %v, %v", scale, ok)
+	}
+	bh := snap.Root.Children[0].Children[1]
+	if kind, _ := bh.Star.Get("Type"); bh.I != 2 || kind != "black_hole" || bh.Parent.Parent != snap.Root {
+		t.Errorf("star %v is %v", bh.I, kind)
+	}
+	if bh.R != [3]float64{-0.002, 0, 0} || bh.V != [3]float64{0, -1.8, 0} || bh.A != [3]float64{1.6, 0, 0} {
+		t.Errorf("star %v at %v, %v, %v", bh.I, bh.R, bh.V, bh.A)
+	}
+}
@@ -0,0 +1,284 @@
+package slt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KiraLimits are the thresholds used by kiraWrap to kill kira.
+// They are set with the kiraWrap flags or in the configuration file:
+//
+//	"KiraLimits": {
+//		"MaxStderrGB": 2,
+//		"MinDiskGB": 5,
+//		"MaxMemPerCent": 98,
+//		"StallMinutes": 120,
+//		"MaxGrowthMBPerMin": 100,
+//		"PollMinutes": 2
+//	}
+//
+// Zero disables StallMinutes and MaxGrowthMBPerMin.
+type KiraLimits struct {
+	// MaxStderrGB is the STDERR size at which kira is considered stalled.
+	MaxStderrGB float64
+	// MinDiskGB is the minimum free space in the working folder.
+	MinDiskGB float64
+	// MaxMemPerCent is the maximum percentage of used memory on the system.
+	MaxMemPerCent float64
+	// StallMinutes is how long the simulated time can stay still.
+	StallMinutes float64
+	// MaxGrowthMBPerMin is the maximum growth rate of STDOUT plus STDERR.
+	MaxGrowthMBPerMin float64
+	// PollMinutes is the interval between checks.
+	PollMinutes float64
+}
+
+// Limits are the thresholds used by KiraWrap.
+var Limits = KiraLimits{
+	MaxStderrGB:       2,
+	MinDiskGB:         5,
+	MaxMemPerCent:     98,
+	StallMinutes:      120,
+	MaxGrowthMBPerMin: 100,
+	PollMinutes:       2,
+}
+
+// limitFlags maps the kiraWrap flags to the limits they set.
+var limitFlags = map[string]func(*KiraLimits) *float64{
+	"max-stderr":  func(limits *KiraLimits) *float64 { return &limits.MaxStderrGB },
+	"min-disk":    func(limits *KiraLimits) *float64 { return &limits.MinDiskGB },
+	"max-mem":     func(limits *KiraLimits) *float64 { return &limits.MaxMemPerCent },
+	"stall-after": func(limits *KiraLimits) *float64 { return &limits.StallMinutes },
+	"max-growth":  func(limits *KiraLimits) *float64 { return &limits.MaxGrowthMBPerMin },
+	"poll":        func(limits *KiraLimits) *float64 { return &limits.PollMinutes },
+}
+
+var errTimeReg = regexp.MustCompile(`^Time = ([0-9.eE+-]+)`)
+
+// LoadConf reads the KiraLimits of the configuration file, if any,
+// keeping the values of the flags for which changed is true.
+func (limits *KiraLimits) LoadConf(confName string, changed func(flag string) bool) error {
+	var (
+		confFile []byte
+		flags    = *limits
+		conf     struct{ KiraLimits *KiraLimits }
+		err      error
+	)
+	if confName == "" {
+		return limits.Validate()
+	}
+	if confFile, err = ioutil.ReadFile(confName); err != nil {
+		return err
+	}
+	// Fields missing in the file keep their current value
+	conf.KiraLimits = limits
+	if err = json.Unmarshal(confFile, &conf); err != nil {
+		return fmt.Errorf("parse config: %v", err)
+	}
+	for flag, field := range limitFlags {
+		if changed(flag) {
+			*field(limits) = *field(&flags)
+		}
+	}
+	return limits.Validate()
+}
+
+// Validate checks the limits.
+func (limits *KiraLimits) Validate() error {
+	for flag, field := range limitFlags {
+		if *field(limits) < 0 {
+			return fmt.Errorf("negative %v limit", flag)
+		}
+	}
+	if limits.PollMinutes == 0 {
+		return fmt.Errorf("zero poll interval")
+	}
+	if limits.MaxMemPerCent > 100 {
+		return fmt.Errorf("memory limit %v%% over 100%%", limits.MaxMemPerCent)
+	}
+	return nil
+}
+
+// Poll is the interval between the checks of killTrigger.
+func (limits *KiraLimits) Poll() time.Duration {
+	return time.Duration(limits.PollMinutes * float64(time.Minute))
+}
+
+// tailLineMax is how much of a line tailedFile keeps, the times are at
+// the beginning of the lines.
+const tailLineMax = 4096
+
+// tailedFile reads what is appended to a file since the last call.
+type tailedFile struct {
+	name   string
+	offset int64
+	// partial is the last line, not yet terminated, at most tailLineMax bytes.
+	partial []byte
+}
+
+// newTailedFile starts tailing fileName from its current end,
+// what is already there was written before this run of kira.
+func newTailedFile(fileName string) *tailedFile {
+	tail := &tailedFile{name: fileName}
+	if fileInfo, err := os.Stat(fileName); err == nil {
+		tail.offset = fileInfo.Size()
+	}
+	return tail
+}
+
+// ReadLines passes to fn the complete lines appended since the last call,
+// cut at tailLineMax bytes. The file is read a buffer at a time, so that
+// a fast growing STDERR doesn't end up in memory; fn must not keep line.
+func (tail *tailedFile) ReadLines(fn func(line []byte)) error {
+	var (
+		file    *os.File
+		nReader *bufio.Reader
+		chunk   []byte
+		line    = tail.partial
+		err     error
+	)
+	if file, err = os.Open(tail.name); err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err = file.Seek(tail.offset, io.SeekStart); err != nil {
+		return err
+	}
+	nReader = bufio.NewReaderSize(file, 64*1024)
+	for {
+		chunk, err = nReader.ReadSlice('\n')
+		tail.offset += int64(len(chunk))
+		if room := tailLineMax - len(line); room > 0 {
+			if len(chunk) > room {
+				chunk = chunk[:room]
+			}
+			line = append(line, chunk...)
+		}
+		switch err {
+		case nil:
+			fn(bytes.TrimSuffix(line, []byte("\n")))
+			line = line[:0]
+		case bufio.ErrBufferFull:
+			// A line longer than the buffer, go on to its end
+		case io.EOF:
+			tail.partial = append([]byte(nil), line...)
+			return nil
+		default:
+			return err
+		}
+	}
+}
+
+// stallDetector follows the simulated time in the STDERR ("Time = N")
+// and in the STDOUT (system_time) while kira runs.
+type stallDetector struct {
+	limits   *KiraLimits
+	out, err *tailedFile
+	// simTime is the last simulated time seen, lastAdvance when it changed.
+	simTime     float64
+	lastAdvance time.Time
+	lastCheck   time.Time
+	// outGrowth and errGrowth are the growth rates in MB/min at the last check.
+	outGrowth, errGrowth float64
+}
+
+func newStallDetector(outName, errName string, limits *KiraLimits) *stallDetector {
+	return &stallDetector{
+		limits:      limits,
+		out:         newTailedFile(outName),
+		err:         newTailedFile(errName),
+		simTime:     -1,
+		lastAdvance: time.Now(),
+		lastCheck:   time.Now(),
+	}
+}
+
+// Check reads the new output and returns why kira is stalled,
+// an empty string if it is not.
+func (detector *stallDetector) Check(now time.Time) (string, error) {
+	var (
+		last     float64
+		found    bool
+		grownMB  float64
+		minutes  = now.Sub(detector.lastCheck).Minutes()
+		advanced bool
+		err      error
+	)
+	for _, tail := range []*tailedFile{detector.err, detector.out} {
+		offset := tail.offset
+		found = false
+		if err = tail.ReadLines(func(line []byte) {
+			res := errTimeReg.FindSubmatch(line)
+			if res == nil {
+				res = outSysTimeReg.FindSubmatch(line)
+			}
+			if res == nil {
+				return
+			}
+			if simTime, err := strconv.ParseFloat(string(res[1]), 64); err == nil {
+				last, found = simTime, true
+			}
+		}); err != nil {
+			return "", err
+		}
+		grownMB += float64(tail.offset-offset) / (1024 * 1024)
+		if minutes > 0 && tail == detector.out {
+			detector.outGrowth = float64(tail.offset-offset) / (1024 * 1024) / minutes
+		} else if minutes > 0 {
+			detector.errGrowth = float64(tail.offset-offset) / (1024 * 1024) / minutes
+		}
+		if found && last > detector.simTime {
+			detector.simTime = last
+			advanced = true
+		}
+	}
+	detector.lastCheck = now
+	if advanced {
+		detector.lastAdvance = now
+	}
+
+	if detector.limits.MaxGrowthMBPerMin > 0 && minutes > 0 && grownMB/minutes > detector.limits.MaxGrowthMBPerMin {
+		return fmt.Sprintf("%v: output growing %2.1f MB/min", stallReason, grownMB/minutes), nil
+	}
+	if detector.limits.StallMinutes > 0 && now.Sub(detector.lastAdvance).Minutes() > detector.limits.StallMinutes {
+		return fmt.Sprintf("%v: simulated time stuck at %v for %2.0f minutes", stallReason,
+			detector.simTime, now.Sub(detector.lastAdvance).Minutes()), nil
+	}
+	return "", nil
+}
+
+// memUsedPerCent returns the percentage of memory not available to new
+// processes, reading MemAvailable from /proc/meminfo.
+func memUsedPerCent() (float64, error) {
+	var (
+		content []byte
+		values  = map[string]float64{}
+		fields  []string
+		value   float64
+		err     error
+	)
+	if content, err = ioutil.ReadFile("/proc/meminfo"); err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if fields = strings.Fields(line); len(fields) < 2 {
+			continue
+		}
+		if value, err = strconv.ParseFloat(fields[1], 64); err == nil {
+			values[strings.TrimSuffix(fields[0], ":")] = value
+		}
+	}
+	if values["MemTotal"] == 0 || values["MemAvailable"] == 0 {
+		return 0, fmt.Errorf("no MemTotal or MemAvailable in /proc/meminfo")
+	}
+	return 100 - values["MemAvailable"]/values["MemTotal"]*100, nil
+}
@@ -0,0 +1,99 @@
+package slt
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// appendTo appends content to fileName.
+func appendTo(t *testing.T, fileName, content string) {
+	file, err := os.OpenFile(fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err = file.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTailedFile(t *testing.T) {
+	var (
+		fileName = filepath.Join(t.TempDir(), "err.txt")
+		long     = strings.Repeat("x", 200*1024)
+		tail     *tailedFile
+		tests    = []struct {
+			appended string
+			lines    []string
+		}{
+			{"Time = 1\nTime = 2", []string{"Time = 1"}},
+			{"", nil},
+			{" more\n" + long + "\nTime", []string{"Time = 2 more", long[:tailLineMax]}},
+			{" = 3\n", []string{"Time = 3"}},
+		}
+	)
+	// What is there before is not read
+	appendTo(t, fileName, "Time = 0\n")
+	tail = newTailedFile(fileName)
+	for idx, test := range tests {
+		var lines []string
+		appendTo(t, fileName, test.appended)
+		if err := tail.ReadLines(func(line []byte) {
+			lines = append(lines, string(line))
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(lines, test.lines) {
+			t.Errorf("append %v: %v lines, want %v", idx, len(lines), len(test.lines))
+		}
+		if info, _ := os.Stat(fileName); tail.offset != info.Size() {
+			t.Errorf("append %v: offset %v, size %v", idx, tail.offset, info.Size())
+		}
+	}
+}
+
+func TestStallDetector(t *testing.T) {
+	var (
+		dir      = t.TempDir()
+		outName  = filepath.Join(dir, "out.txt")
+		errName  = filepath.Join(dir, "err.txt")
+		limits   = KiraLimits{StallMinutes: 10}
+		start    = time.Now()
+		detector *stallDetector
+		tests    = []struct {
+			minutes  int
+			err, out string
+			stalled  bool
+		}{
+			{5, "Time = 1\n  step\nTime = 2\n", "", false},
+			{10, "", "  system_time  =  3\n", false},
+			// Nothing new, not for long enough
+			{15, "Time = 3\n", "", false},
+			{21, "", "", true},
+			// Going on again
+			{22, "Time = 4\n", "", false},
+		}
+	)
+	appendTo(t, outName, "")
+	appendTo(t, errName, "")
+	detector = newStallDetector(outName, errName, &limits)
+	detector.lastAdvance, detector.lastCheck = start, start
+	for _, test := range tests {
+		appendTo(t, errName, test.err)
+		appendTo(t, outName, test.out)
+		reason, err := detector.Check(start.Add(time.Duration(test.minutes) * time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (reason != "") != test.stalled {
+			t.Errorf("minute %v: reason %q, simulated time %v", test.minutes, reason, detector.simTime)
+		}
+	}
+	if detector.simTime != 4 {
+		t.Errorf("simulated time %v, want 4", detector.simTime)
+	}
+}
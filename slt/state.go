@@ -0,0 +1,238 @@
+package slt
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// StateFileName is the name of the state database in each campaign folder.
+// It doesn't match any of the patterns cleaned by SimClean.
+const StateFileName = "slt-state.json"
+
+// stateLockName is the lock file used to serialize the updates of the state
+// from CAC on the login node and kiraWrap on the compute nodes.
+const stateLockName = ".slt-state.lock"
+
+// RoundState records what happened in a round of a run.
+type RoundState struct {
+	Round int
+	// ICs, Out and Err are the file names of the round.
+	ICs, Out, Err string
+	RandomSeed    string
+	// StartTimestep is the timestep of the ICs, EndTimestep the last
+	// complete snapshot of the STDOUT, empty if not known yet.
+	StartTimestep string
+	EndTimestep   string
+	// JobID is what the scheduler answered when the round was submitted.
+	JobID     string
+	Submitted time.Time
+	Started   time.Time
+	Ended     time.Time
+	// ExitReason comes from kiraWrap, i.e. "killed because probable pp3 stalling".
+	ExitReason string
+	// Restarts counts the relaunches of the kiraWrap supervisor.
+	Restarts int
+	// Checksums maps the file names to their SHA-256, only for the ICs:
+	// the STDOUT is too big to be read whole, see OutSize.
+	Checksums map[string]string
+	// OutSize and OutModTime identify the STDOUT when the round was closed.
+	OutSize    int64
+	OutModTime time.Time
+	// Removed is true if CAC removed the outputs as broken.
+	Removed bool
+}
+
+// RunState records the rounds of a run.
+type RunState struct {
+	// Name is the base name of the run, like comb16-...-Z010-run06.
+	Name     string
+	Complete bool
+	Rounds   []*RoundState
+}
+
+// StateDB is the persistent state of the runs in a folder.
+// Get it with ReadState to look at it or with UpdateState to change it.
+type StateDB struct {
+	Updated time.Time
+	Runs    map[string]*RunState
+}
+
+// stateKey identifies a run in the state.
+func stateKey(id RunID) string {
+	return id.BaseName() + "-run" + id.RunStr()
+}
+
+// ReadState loads the state of the folder dir, an empty one if there isn't any.
+func ReadState(dir string) (*StateDB, error) {
+	var (
+		db      = &StateDB{Runs: map[string]*RunState{}}
+		content []byte
+		err     error
+	)
+	if content, err = ioutil.ReadFile(filepath.Join(dir, StateFileName)); err != nil {
+		if os.IsNotExist(err) {
+			return db, nil
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal(content, db); err != nil {
+		return nil, fmt.Errorf("parse state %v: %v", filepath.Join(dir, StateFileName), err)
+	}
+	if db.Runs == nil {
+		db.Runs = map[string]*RunState{}
+	}
+	return db, nil
+}
+
+// UpdateState loads the state of the folder dir, passes it to update and
+// saves it if update doesn't return an error. The folder state is locked
+// for the whole time so that concurrent processes don't lose updates.
+func UpdateState(dir string, update func(*StateDB) error) error {
+	var (
+		lock    *os.File
+		db      *StateDB
+		content []byte
+		tmpName = filepath.Join(dir, StateFileName+".tmp")
+		err     error
+	)
+	if lock, err = os.OpenFile(filepath.Join(dir, stateLockName), os.O_CREATE|os.O_RDWR, 0600); err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("can't lock the state in %v: %v", dir, err)
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	if db, err = ReadState(dir); err != nil {
+		return err
+	}
+	if err = update(db); err != nil {
+		return err
+	}
+	db.Updated = time.Now()
+	if content, err = json.MarshalIndent(db, "", "\t"); err != nil {
+		return err
+	}
+	// Write and rename so that a crash doesn't leave a truncated state
+	if err = ioutil.WriteFile(tmpName, append(content, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, filepath.Join(dir, StateFileName))
+}
+
+// Run returns the state of the run of id, creating it if needed.
+func (db *StateDB) Run(id RunID) *RunState {
+	var (
+		key = stateKey(id)
+		run *RunState
+		ok  bool
+	)
+	if run, ok = db.Runs[key]; !ok {
+		run = &RunState{Name: key}
+		db.Runs[key] = run
+	}
+	return run
+}
+
+// Round returns the state of the round of id, creating it if needed.
+func (db *StateDB) Round(id RunID) *RoundState {
+	var run = db.Run(id)
+	for _, round := range run.Rounds {
+		if round.Round == id.Round {
+			return round
+		}
+	}
+	round := &RoundState{Round: id.Round, Checksums: map[string]string{}}
+	run.Rounds = append(run.Rounds, round)
+	sort.Sort(byRound(run.Rounds))
+	return round
+}
+
+// LastRound returns the state of the last round of the run of id,
+// nil if the run is unknown.
+func (db *StateDB) LastRound(id RunID) *RoundState {
+	var (
+		run *RunState
+		ok  bool
+	)
+	if run, ok = db.Runs[stateKey(id)]; !ok || len(run.Rounds) == 0 {
+		return nil
+	}
+	return run.Rounds[len(run.Rounds)-1]
+}
+
+// RunNames returns the names of the runs in the state, sorted.
+func (db *StateDB) RunNames() []string {
+	var names = make([]string, 0, len(db.Runs))
+	for name := range db.Runs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetChecksum records sum, from FileChecksum, as the SHA-256 of fileName.
+// Compute it before UpdateState, not to keep the state locked while
+// reading the file.
+func (round *RoundState) SetChecksum(fileName, sum string) {
+	if round.Checksums == nil {
+		round.Checksums = map[string]string{}
+	}
+	round.Checksums[filepath.Base(fileName)] = sum
+}
+
+type byRound []*RoundState
+
+func (rounds byRound) Len() int           { return len(rounds) }
+func (rounds byRound) Swap(i, j int)      { rounds[i], rounds[j] = rounds[j], rounds[i] }
+func (rounds byRound) Less(i, j int) bool { return rounds[i].Round < rounds[j].Round }
+
+// FileChecksum returns the hex SHA-256 of the content of fileName.
+func FileChecksum(fileName string) (string, error) {
+	var (
+		file *os.File
+		hash = sha256.New()
+		err  error
+	)
+	if file, err = os.Open(fileName); err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if _, err = io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// RecordSubmission stores the job ID the scheduler gave to the job script
+// pbsFile in the state of its folder. Scripts with non-standard names
+// are ignored.
+func RecordSubmission(pbsFile, jobID string) {
+	var (
+		id  RunID
+		err error
+	)
+	if id, err = ParseRunID(pbsFile); err != nil {
+		return
+	}
+	if err = UpdateState(filepath.Dir(pbsFile), func(db *StateDB) error {
+		round := db.Round(id)
+		round.JobID = strings.TrimSpace(jobID)
+		round.Submitted = time.Now()
+		return nil
+	}); err != nil {
+		log.Println("Can't update the state: ", err)
+	}
+}
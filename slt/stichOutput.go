@@ -1,13 +1,11 @@
 package slt
 
 import (
-	"bufio"
-	"compress/gzip"
 	"fmt"
 	"log"
+	"io"
 	"os"
 	"path/filepath"
-	"runtime"
 	"sort"
 	"strings"
 	"strconv"
@@ -18,6 +16,7 @@ import (
 
 // StichThemAll launch the stiching in parallel on all the simulation files in
 // the folder, accordingly to their names (run 01 is different from run 02 and so on).
+// Jobs runs are stiched at the same time, a run that fails doesn't stop the others.
 func StichThemAll(sampleFile string) {
 	if Debug {
 		defer debug.TimeMe(time.Now())
@@ -27,24 +26,20 @@ func StichThemAll(sampleFile string) {
 		err          error
 		inFiles      []string
 		prefixes                    = []string{"out-", "err-"}
-		run, baseName string
-		tmp map[string]string
+		baseName string
+		id RunID
 		runs         StringSet // set = list of unique objects (run numbers)
 		nRuns        []int
 		globName     string
-		maxProcs int = 1
-		inFileNameChan = make(chan string, maxProcs)
-		done = make(chan struct{})
+		names        = []string{}
 	)
 
-	runtime.GOMAXPROCS(maxProcs)
-	
 	nRuns = make([]int, 0)
 
-	if tmp, err = Reg(sampleFile); err != nil {
+	if id, err = ParseRunID(sampleFile); err != nil {
 		log.Fatal(err)
 	}
-	baseName = tmp["baseName"]
+	baseName = id.BaseName()
 	
 	// Search for all the STDOUT and STDERR files in the folder
 	for idx := 0; idx < 2; idx++ {
@@ -62,12 +57,11 @@ func StichThemAll(sampleFile string) {
 
 		// Find the numbers of the different runs
 		for _, inFileName := range inFiles {
-			if tmp, err = Reg(inFileName); err != nil {
+			if id, err = ParseRunID(inFileName); err != nil {
 				log.Fatal(err)
 			}
-			run = tmp["run"]
 			// Add the new number in the set
-			runs.Add(run)
+			runs.Add(id.RunStr())
 		}
 		if Verb {
 			log.Println("Found runs:")
@@ -76,20 +70,16 @@ func StichThemAll(sampleFile string) {
 		nRuns = append(nRuns, len(runs))
 	}
 
-	// Launch maxProcs goroutines
-	for idx:=0; idx< maxProcs; idx++ {
-		go StichOutput(inFileNameChan, done)
-	}
-	
 	// Launch all the stiching
 	for _, runIdx := range runs.Sorted() {
 		name := "out-" + baseName + "-run" + runIdx + "-rnd00.*"
 		if Verb {log.Println("Launching stich based on ", name)}
-		inFileNameChan <- name
+		names = append(names, name)
 	}
-	close(inFileNameChan)
-	for idx:=0; idx< maxProcs; idx++ {
-		<- done // wait the goroutines to finish
+	if err = JobsError(ForEachRun(len(names), func(idx int, out io.Writer) error {
+		return stichRun(names[idx], out)
+	}, nil)); err != nil {
+		log.Fatal(err)
 	}
 }
 
@@ -118,99 +108,112 @@ func StichOutput(inFileNameChan chan string, done chan struct{}) {
 
 	var (
 		inFileName string
+		err        error
+	)
+	
+	for inFileName = range inFileNameChan {
+		if err = stichRun(inFileName, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+	}
+	done <- struct{}{}
+}
+
+// stichRun stiches the STDOUTs and STDERRs of the run of inFileName,
+// the list of the timesteps goes to out.
+func stichRun(inFileName string, out io.Writer) error {
+	var (
 		run          string
 		stdOuts      string
 		stdErrs      string
 		baseName string
-		tmp map[string]string
+		id RunID
 		err error
 	)
 	
-	for inFileName = range inFileNameChan {
-		
-		if inFileName == "" {
-			log.Fatal("You need to specify an input file template with the -i flag!!!")
-		}
-		
-		// Extract parameters from the name
-		if tmp, err = Reg(inFileName); err != nil {
-			log.Fatal(err)
-		}
-		run = tmp["run"]
-		baseName = tmp["baseName"]
-		
-		log.Println("Stiching *-" + baseName + `-run` + run + `-rnd*.*`)
+	if inFileName == "" {
+		return fmt.Errorf("you need to specify an input file template with the -i flag")
+	}
+	
+	// Extract parameters from the name
+	if id, err = ParseRunID(inFileName); err != nil {
+		return err
+	}
+	run = id.RunStr()
+	baseName = id.BaseName()
+	
+	log.Println("Stiching *-" + baseName + `-run` + run + `-rnd*.*`)
 
-		// Check if only have to run STDERR stich
-		if !OnlyErr {
-			//
-			// STDOUT
-			//
-			stdOuts = "out-" + baseName + `-run` + run + `-rnd*.*`
-			StdStich(stdOuts, "out")
-		} else {
-			log.Println("Only stich STDERRs")
+	// Check if only have to run STDERR stich
+	if !OnlyErr {
+		//
+		// STDOUT
+		//
+		stdOuts = "out-" + baseName + `-run` + run + `-rnd*.*`
+		if err = StdStich(stdOuts, "out", out); err != nil {
+			return err
 		}
+	} else {
+		log.Println("Only stich STDERRs")
+	}
 
-		// Check if only have to run STDOUT stich
-		if !OnlyOut {
-			//
-			// STDERR
-			//
-			stdErrs = "err-" + baseName + `-run` + run + `-rnd*.*`
-			StdStich(stdErrs, "err")
-
-		} else {
-			log.Println("Only stich STDOUTs")
+	// Check if only have to run STDOUT stich
+	if !OnlyOut {
+		//
+		// STDERR
+		//
+		stdErrs = "err-" + baseName + `-run` + run + `-rnd*.*`
+		if err = StdStich(stdErrs, "err", out); err != nil {
+			return err
 		}
+	} else {
+		log.Println("Only stich STDOUTs")
 	}
-	done <- struct{}{}
+	return nil
 }
 
 // StdStich stiches a given STD??? according to the type passed with stdWhat.
-func StdStich(stdFiles, stdWhat string) {
+// The timesteps written go to out.
+func StdStich(stdFiles, stdWhat string, out io.Writer) (err error) {
 	if Debug {
 		defer debug.TimeMe(time.Now())
 	}
 
 	var (
-		fZip                                  *gzip.Reader
-		inFile                                *os.File
-		snapshot/*s = make([]*/ *DumbSnapshot /*, 2)*/
+		file                                  *SnapFile
+		entry                                 *SnapEntry
 		inFiles                               []string
 		outFileName                           string
-		outFile                               *os.File
-		err                                   error
-		nReader                               *bufio.Reader
-		nWriter                               *bufio.Writer
+		outFile                               *StdWriter
 		timestep                              int64
 		timesteps                             = make([]int64, 0)
-		ext                                   string
 	)
 
 	log.Println("Stich std" + stdWhat)
 
 	tmp := strings.TrimSuffix(stdFiles, "-rnd*.*")
 	
-	outFileName =  tmp + "-all.txt"
+	if outFileName, err = CompressedName(tmp + "-all.txt", compress); err != nil {
+		return err
+	}
 	log.Println("Output file will be ", outFileName)
 
 	log.Println("Opening STDOUT output file...")
 
-	// Open output file
-	if outFile, err = os.Create(outFileName); err != nil {
-		log.Fatal(err)
+	// Open output file, compressed accordingly to its name
+	if outFile, err = CreateStd(outFileName); err != nil {
+		return err
 	}
-	defer outFile.Close()
-
-	// Create reader and writer
-	nWriter = bufio.NewWriter(outFile)
-	defer nWriter.Flush()
+	defer func() {
+		if closeErr := outFile.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("error while closing %v: %v", outFileName, closeErr)
+		}
+	}()
 
 	log.Println("Globbing and sorting " + stdWhat + " input files")
 	// Open infiles
 	if inFiles, err = filepath.Glob(stdFiles); err != nil {
-		log.Fatal("Error globbing "+stdWhat+" files for output stiching: ", err)
+		return fmt.Errorf("error globbing %v files for output stiching: %v", stdWhat, err)
 	}
 
 	sort.Strings(inFiles)
@@ -218,7 +221,7 @@ func StdStich(stdFiles, stdWhat string) {
 	if Verb {
 		log.Println("Found:")
 		for idx, file := range inFiles {
-			fmt.Println(idx, ": ", file)
+			fmt.Fprintln(out, idx, ": ", file)
 		}
 	}
 
@@ -226,42 +229,16 @@ func StdStich(stdFiles, stdWhat string) {
 		if Verb {
 			log.Println("Working on ", inFileName)
 		}
-		if inFile, err = os.Open(inFileName); err != nil {
-			log.Fatal(err)
-		}
-		defer inFile.Close()
-		ext = filepath.Ext(inFileName)
-		// Try to open the file if it is a gzipped one or a simple txt
-		switch ext {
-		case ".txt":
-			{
-				nReader = bufio.NewReader(inFile)
-			}
-		case ".gz":
-			{
-				fZip, err = gzip.NewReader(inFile)
-				if err != nil {
-					log.Fatal("Can't open %s: error: %s\n", inFileName, err)
-				}
-				nReader = bufio.NewReader(fZip)
-			}
-		default:
-			{
-				log.Fatal("Unrecognized file type", inFileName)
-			}
+		// Open the file and its index, the compression is detected from its content
+		if file, err = OpenSnapFile(inFileName, stdWhat); err != nil {
+			return err
 		}
+		defer file.Close()
 
 		//Read snapshots and write them if everything is OK
-	SnapLoop:// label
-		for {
-			if stdWhat == "out" {
-				snapshot, err = ReadOutSnapshot(nReader)
-			} else if stdWhat == "err" {
-				snapshot, err = ReadErrSnapshot(nReader)
-			} else {
-				log.Fatal("Unrecognized stdWhat: ", stdWhat)
-			}
-			if err != nil {
+	SnapLoop: // label
+		for _, entry = range file.Entries {
+			if !entry.Integrity {
 				if Verb {
 					log.Println("Incomplete snapshot, moving to the next file")
 				}
@@ -270,50 +247,38 @@ func StdStich(stdFiles, stdWhat string) {
 			// -1 is the "ICs to 0" timestep, skipping
 			// I will skip this also because it creates problems of duplication
 			// and timestep check
-			if snapshot.Timestep == "-1" && len(timesteps) > 0 {
+			if entry.Timestep == "-1" && len(timesteps) > 0 {
 				continue SnapLoop /*to the next timestep*/
 			}
 
 			// I will loose the last timestep on STDERR because it is probably not complete
-			// TODO: find out how to manage this
-			// BUG: I can't find a univoque way to define the last snapshot complete
-			if snapshot.Integrity == true {
-				timestep, err = strconv.ParseInt(snapshot.Timestep, 10, 64)
-				// Skip the first loop (=first timestep) with len = 0
-				if len(timesteps) > 0 {
-					if AbsInt(timestep-timesteps[len(timesteps)-1]) > 1 {
-						if Verb {
-							log.Println("Read timestep: ")
-							for _, ts := range timesteps {
-								fmt.Print(ts, " ")
-							}
-							fmt.Println()
+			timestep, err = strconv.ParseInt(entry.Timestep, 10, 64)
+			// Skip the first loop (=first timestep) with len = 0
+			if len(timesteps) > 0 {
+				if AbsInt(timestep-timesteps[len(timesteps)-1]) > 1 {
+					if Verb {
+						log.Println("Read timestep: ")
+						for _, ts := range timesteps {
+							fmt.Fprint(out, ts, " ")
 						}
-						log.Fatal("More that one timestep of distance between ", timesteps[len(timesteps)-1], " and ", timestep)
-					} else if AbsInt(timestep-timesteps[len(timesteps)-1]) < 1 {
-						log.Println("Duplicated timestep ", timestep, ", continue.")
-						continue SnapLoop /*to the next timestep*/
+						fmt.Fprintln(out)
 					}
+					return fmt.Errorf("more that one timestep of distance between %v and %v in %v", timesteps[len(timesteps)-1], timestep, inFileName)
+				} else if AbsInt(timestep-timesteps[len(timesteps)-1]) < 1 {
+					log.Println("Duplicated timestep ", timestep, ", continue.")
+					continue SnapLoop /*to the next timestep*/
 				}
-				timesteps = append(timesteps, timestep) // Write the snapshot
-				if err = snapshot.WriteSnapshot(nWriter); err != nil {
-					log.Fatal("Error while writing snapshot to file: ", err)
-				}
-			} else {
-				// This shouldn't happend because of the break in reading the snapshots
-				// This shoud be a redundant check
-				// TODO: check if it is true!!!
-				fmt.Println("************************ ATTENTION *************************")
-				fmt.Println("************************************************************")
-				log.Println("Skipping incomplete snapshot at timestep", snapshot.Timestep)
-				fmt.Println("************************************************************")
-				fmt.Println("************************************************************")
+			}
+			timesteps = append(timesteps, timestep) // Write the snapshot as it is in the file
+			if err = file.WriteEntry(outFile.Writer, entry); err != nil {
+				return fmt.Errorf("error while writing snapshot to file: %v", err)
 			}
 		} // end reading snapshot from a single file loop
 	} // end reading file loop
-	fmt.Println("\n")
+	fmt.Fprintln(out)
 	log.Println("Wrote ", len(timesteps), "snapshots to ", outFileName)
-	fmt.Println(timesteps)
+	fmt.Fprintln(out, timesteps)
+	return nil
 
 }
 
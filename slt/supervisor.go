@@ -0,0 +1,184 @@
+package slt
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Supervisor settings for KiraWrap, set by the kiraWrap flags.
+var (
+	// KiraSupervise restarts kira inside the same job when it stalls.
+	KiraSupervise bool
+	// KiraMaxRestarts is the retry budget of a round.
+	KiraMaxRestarts int = 3
+	// KiraCutBack is how many complete snapshots to drop before restarting,
+	// the stall usually begins some time before it is detected.
+	KiraCutBack int = 2
+)
+
+// stallReason is the killTrigger reason that can be cured by a restart.
+const stallReason = "probable pp3 stalling"
+
+// kiraRestart describes how to relaunch kira after a stall.
+type kiraRestart struct {
+	// ICs is the new ICs file, made of the snapshot at Timestep.
+	ICs      string
+	Timestep int64
+	// Time is the new value of the -t flag.
+	Time string
+}
+
+// prepareRestart cuts STDOUT and STDERR before the snapshot cutBack
+// snapshots before the last complete one, writes that snapshot in a new
+// ICs file and computes the remaining time to reach endTimestep.
+// The new ICs is named like the round ICs with the "rstNN" prefix so that
+// it is not confused with the ICs of the next round.
+func prepareRestart(icsName, outName, errName string, endTimestep int64, cutBack, restart int) (*kiraRestart, error) {
+	var (
+		file      *SnapFile
+		complete  = []*SnapEntry{}
+		entry     *SnapEntry
+		snap      *DumbSnapshot
+		rst       = new(kiraRestart)
+		icsFile   *os.File
+		icsWriter *bufio.Writer
+		id        RunID
+		err       error
+	)
+
+	// Find the complete snapshots in the STDOUT and where they begin with
+	// the index, only the one to restart from is read
+	if file, err = OpenSnapFile(outName, "out"); err != nil {
+		return nil, err
+	}
+	for _, entry = range file.Entries {
+		if entry.Integrity {
+			complete = append(complete, entry)
+		}
+	}
+	if len(complete) == 0 {
+		file.Close()
+		return nil, fmt.Errorf("no complete snapshot in %v", outName)
+	}
+	if cutBack >= len(complete) {
+		cutBack = len(complete) - 1
+	}
+	entry = complete[len(complete)-1-cutBack]
+	snap, err = file.Snapshot(entry)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+	if rst.Timestep, err = strconv.ParseInt(snap.Timestep, 10, 64); err != nil {
+		return nil, fmt.Errorf("bad timestep %v in %v", snap.Timestep, outName)
+	}
+	if rst.Timestep >= endTimestep {
+		return nil, fmt.Errorf("timestep %v already past the end %v", rst.Timestep, endTimestep)
+	}
+
+	// kira writes the starting snapshot again, so the files are cut before it
+	if err = os.Truncate(outName, entry.Offset); err != nil {
+		return nil, err
+	}
+	if err = cutStdErrBefore(errName, rst.Timestep); err != nil {
+		return nil, err
+	}
+
+	// Write the new ICs
+	if id, err = ParseRunID(icsName); err == nil {
+		rst.ICs = filepath.Join(filepath.Dir(icsName), id.WithPrefix(fmt.Sprintf("rst%02d", restart)).WithExt(".txt").String())
+	} else {
+		rst.ICs = filepath.Join(filepath.Dir(icsName), fmt.Sprintf("rst%02d-", restart)+filepath.Base(TrimCodecExt(icsName)))
+	}
+	if icsFile, err = os.Create(rst.ICs); err != nil {
+		return nil, err
+	}
+	defer icsFile.Close()
+	icsWriter = bufio.NewWriter(icsFile)
+	if err = snap.WriteSnapshot(icsWriter); err != nil {
+		return nil, err
+	}
+	rst.Time = strconv.FormatInt(endTimestep-rst.Timestep, 10)
+	return rst, nil
+}
+
+// cutStdErrBefore truncates the STDERR before the first timestep not
+// earlier than timestep.
+func cutStdErrBefore(errName string, timestep int64) error {
+	var (
+		inFile  *StdReader
+		scanner *SnapshotScanner
+		keep    int64
+		errTime int64
+		err     error
+	)
+	if inFile, err = OpenStd(errName); err != nil {
+		return err
+	}
+	scanner = NewErrSnapshotScanner(inFile.Reader)
+	for scanner.Scan() {
+		if errTime, err = strconv.ParseInt(scanner.Snapshot().Timestep, 10, 64); err == nil && errTime >= timestep {
+			break
+		}
+		keep = scanner.Line()
+	}
+	inFile.Close()
+	return truncateLines(errName, keep)
+}
+
+// truncateLines cuts the plain text file fileName after nLines lines.
+func truncateLines(fileName string, nLines int64) error {
+	var (
+		file    *os.File
+		nReader *bufio.Reader
+		line    string
+		offset  int64
+		err     error
+	)
+	if file, err = os.Open(fileName); err != nil {
+		return err
+	}
+	nReader = bufio.NewReader(file)
+	for idx := int64(0); idx < nLines; idx++ {
+		if line, err = nReader.ReadString('\n'); err != nil {
+			if err == io.EOF {
+				break
+			}
+			file.Close()
+			return err
+		}
+		offset += int64(len(line))
+	}
+	file.Close()
+	return os.Truncate(fileName, offset)
+}
+
+// icsStartTimestep returns the system time of the ICs, 0 for the ICs
+// created by the StarLab tools that don't have one.
+func icsStartTimestep(icsName string) (int64, error) {
+	var (
+		inFile   *StdReader
+		scanner  *SnapshotScanner
+		timestep int64
+		err      error
+	)
+	if inFile, err = OpenStd(icsName); err != nil {
+		return 0, err
+	}
+	defer inFile.Close()
+	scanner = NewICsSnapshotScanner(inFile.Reader)
+	if !scanner.Scan() {
+		return 0, fmt.Errorf("no snapshot in %v: %v", icsName, scanner.Err())
+	}
+	if scanner.Snapshot().Timestep == "" {
+		return 0, nil
+	}
+	if timestep, err = strconv.ParseInt(scanner.Snapshot().Timestep, 10, 64); err != nil {
+		return 0, fmt.Errorf("bad timestep %v in %v", scanner.Snapshot().Timestep, icsName)
+	}
+	return timestep, nil
+}
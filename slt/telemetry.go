@@ -0,0 +1,159 @@
+package slt
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clockTicks is the USER_HZ of /proc/<pid>/stat, 100 on every Linux we run on.
+const clockTicks = 100
+
+// TelemetrySample is what killTrigger measures at each check.
+// The samples are written one per line in the telemetry file.
+type TelemetrySample struct {
+	Time time.Time
+	// SimTime is the last simulated time in the outputs, -1 if none yet.
+	SimTime        float64
+	StderrGB       float64
+	StdoutMBPerMin float64
+	StderrMBPerMin float64
+	MemUsedPerCent float64
+	DiskAvailGB    float64
+	// KiraRSSMB and KiraCPUSeconds come from /proc/<pid> of kira.
+	KiraRSSMB      float64
+	KiraCPUSeconds float64
+	// Reason is set on the sample that made killTrigger kill kira.
+	Reason string `json:",omitempty"`
+}
+
+// TelemetryWriter appends the samples to a JSON lines file.
+// A nil TelemetryWriter discards them.
+type TelemetryWriter struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// TelemetryName returns the name of the telemetry file of a STDOUT,
+// i.e. telemetry-cineca-comb16-...-run06-rnd00.jsonl.
+func TelemetryName(outName string) string {
+	return sidecarName(outName, "telemetry", ".jsonl")
+}
+
+// sidecarName names a file about a STDOUT, in the same folder and with the
+// same run and round but another prefix and extension.
+func sidecarName(outName, prefix, ext string) string {
+	if id, err := ParseRunID(outName); err == nil {
+		return filepath.Join(filepath.Dir(outName), id.WithPrefix(prefix).WithExt(ext).String())
+	}
+	base := strings.TrimPrefix(filepath.Base(TrimCodecExt(outName)), "out-")
+	return filepath.Join(filepath.Dir(outName), prefix+"-"+strings.TrimSuffix(base, filepath.Ext(base))+ext)
+}
+
+// NewTelemetryWriter creates the telemetry file.
+func NewTelemetryWriter(fileName string) (*TelemetryWriter, error) {
+	var (
+		file *os.File
+		err  error
+	)
+	if file, err = os.Create(fileName); err != nil {
+		return nil, err
+	}
+	return &TelemetryWriter{file: file}, nil
+}
+
+// Write appends a sample, errors are only logged: the telemetry must not
+// stop the run.
+func (telemetry *TelemetryWriter) Write(sample *TelemetrySample) {
+	if telemetry == nil {
+		return
+	}
+	telemetry.mutex.Lock()
+	defer telemetry.mutex.Unlock()
+	content, err := json.Marshal(sample)
+	if err == nil {
+		_, err = telemetry.file.Write(append(content, '\n'))
+	}
+	if err != nil {
+		log.Println("Can't write the telemetry: ", err)
+	}
+}
+
+// Close closes the telemetry file.
+func (telemetry *TelemetryWriter) Close() error {
+	if telemetry == nil {
+		return nil
+	}
+	return telemetry.file.Close()
+}
+
+// ReadTelemetry reads the samples of a telemetry file.
+func ReadTelemetry(fileName string) ([]*TelemetrySample, error) {
+	var (
+		content []byte
+		samples = []*TelemetrySample{}
+		err     error
+	)
+	if content, err = ioutil.ReadFile(fileName); err != nil {
+		return nil, err
+	}
+	for idx, line := range strings.Split(string(content), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		sample := new(TelemetrySample)
+		if err = json.Unmarshal([]byte(line), sample); err != nil {
+			return samples, fmt.Errorf("%v line %v: %v", fileName, idx+1, err)
+		}
+		samples = append(samples, sample)
+	}
+	return samples, nil
+}
+
+// procStats returns the resident memory in MB and the CPU time in seconds
+// of the process pid.
+func procStats(pid int) (rssMB, cpuSeconds float64, err error) {
+	var (
+		content []byte
+		fields  []string
+		ticks   float64
+		value   float64
+	)
+	if content, err = ioutil.ReadFile(fmt.Sprintf("/proc/%v/stat", pid)); err != nil {
+		return 0, 0, err
+	}
+	// The command name can contain spaces, the fields start after it
+	stat := string(content)
+	if fields = strings.Fields(stat[strings.LastIndex(stat, ")")+1:]); len(fields) < 13 {
+		return 0, 0, fmt.Errorf("short /proc/%v/stat", pid)
+	}
+	// utime and stime are the 14th and 15th fields, after pid and comm
+	for _, field := range fields[11:13] {
+		if value, err = strconv.ParseFloat(field, 64); err != nil {
+			return 0, 0, err
+		}
+		ticks += value
+	}
+	cpuSeconds = ticks / clockTicks
+
+	if content, err = ioutil.ReadFile(fmt.Sprintf("/proc/%v/status", pid)); err != nil {
+		return 0, cpuSeconds, err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if fields = strings.Fields(line); len(fields) >= 2 && fields[0] == "VmRSS:" {
+			if value, err = strconv.ParseFloat(fields[1], 64); err != nil {
+				return 0, cpuSeconds, err
+			}
+			// In kB
+			return value / 1024, cpuSeconds, nil
+		}
+	}
+	return 0, cpuSeconds, nil
+}
@@ -0,0 +1,158 @@
+package slt
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"text/template"
+)
+
+// ScriptTemplates are the files with the text/template templates used to
+// write the scripts, the built-in ones are used for the empty fields.
+// They can be set in the configuration (or in the Base of a campaign) and
+// in the machine profile, the configuration wins.
+// The templates receive a ScriptData.
+type ScriptTemplates struct {
+	// KiraLaunch runs kira or kiraWrap, defaults to KiraLaunchTemplate.
+	KiraLaunch string
+	// Job is submitted to the scheduler, defaults to the scheduler Template.
+	Job string
+	// ICs creates the ICs, defaults to ICsTemplate.
+	ICs string
+}
+
+// ScriptData is what the templates can use, i.e. {{.ID.RunStr}},
+// {{.Queue}} or {{.Conf.NcmStr}}.
+type ScriptData struct {
+	// JobSpec gives name, account, queue, walltime, resources, setup and the
+	// command of the job.
+	JobSpec
+	ID RunID
+	// Dir is the absolute path of the folder of the run, ICs, Out, Err and
+	// KiraLaunch the absolute paths of the files.
+	Dir        string
+	ICs        string
+	Out        string
+	Err        string
+	KiraLaunch string
+	// RemainingTime is the number of timesteps to integrate.
+	RemainingTime string
+	// Seed is the random seed, empty to let kira choose.
+	Seed string
+	// ICsSeeds are the seeds of the ICs programs by name, empty without a
+	// master seed in the configuration, i.e. {{index .ICsSeeds "makeking"}}.
+	ICsSeeds map[string]string
+	// Tidal is true to run the Allen-Santillan version of kira.
+	Tidal    bool
+	KiraWrap string
+	// Kira is set when kira is run directly instead of kiraWrap,
+	// KiraArgs are then its arguments from the kira profile.
+	Kira     string
+	KiraArgs string
+	// ConfFile is the absolute path of the configuration, passed to
+	// kiraWrap for the kira profile and the limits. Empty if none.
+	ConfFile string
+	Machine *MachineProfile
+	// Conf is only set for the ICs script.
+	Conf *ConfigStruct
+}
+
+// KiraLaunchTemplate is the built-in kiraLaunch script.
+const KiraLaunchTemplate = `{{if .Kira}}echo $PWD
+echo $LD_LIBRARY_PATH
+echo $HOSTNAME
+date
+{{.Kira}} {{.KiraArgs}} \
+<  {{.ICs}} \
+>  {{.Out}} \
+2> {{.Err}}
+{{else}}#echo $PWD
+#echo $LD_LIBRARY_PATH
+#echo $HOSTNAME
+#date
+{{.KiraWrap}}{{if .Tidal}} -a {{end}} -i {{.ICs}} -t {{.RemainingTime}} {{if .Seed}}-s {{.Seed}}{{end}}{{if .Walltime}} --walltime {{.Walltime}}{{end}}{{if .ConfFile}} -c {{.ConfFile}}{{end}}
+{{end}}`
+
+// ICsTemplate is the built-in script creating the ICs with the StarLab tools.
+const ICsTemplate = `#!/bin/bash
+set -xeu
+makeking -n {{.Conf.NcmStr}} -w {{.Conf.WStr}} -i -u{{with index .ICsSeeds "makeking"}} -s {{.}}{{end}} \
+| makemass -f 8  -l 0.1 -u 150{{with index .ICsSeeds "makemass"}} -s {{.}}{{end}} \
+| makesecondary -f {{.Conf.FpbStr}} -q -l 0.1{{with index .ICsSeeds "makesecondary"}} -s {{.}}{{end}} \
+| add_star -R {{.Conf.RvStr}} -Z {{.Conf.ZStr}} \
+| scale -R 1 -M 1\
+| makebinary -f 2 -o 1 -l 1 -u 107836.09{{with index .ICsSeeds "makebinary"}} -s {{.}}{{end}} \
+> {{.ICs}}
+`
+
+// LoadTemplate parses the template in fileName, the builtin one if
+// fileName is empty. Environment variables in fileName are expanded.
+func LoadTemplate(name, fileName, builtin string) (*template.Template, error) {
+	var (
+		content []byte
+		err     error
+	)
+	if fileName == "" {
+		return template.New(name).Parse(builtin)
+	}
+	if content, err = ioutil.ReadFile(os.ExpandEnv(fileName)); err != nil {
+		return nil, err
+	}
+	return template.New(name).Parse(string(content))
+}
+
+// RenderScript executes the template with data.
+func RenderScript(tmpl *template.Template, data ScriptData) (string, error) {
+	var (
+		buf bytes.Buffer
+		err error
+	)
+	if err = tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Merge returns the templates of override, falling back to the ones of
+// templates for the empty fields.
+func (templates *ScriptTemplates) Merge(override *ScriptTemplates) ScriptTemplates {
+	var merged ScriptTemplates
+	if templates != nil {
+		merged = *templates
+	}
+	if override == nil {
+		return merged
+	}
+	if override.KiraLaunch != "" {
+		merged.KiraLaunch = override.KiraLaunch
+	}
+	if override.Job != "" {
+		merged.Job = override.Job
+	}
+	if override.ICs != "" {
+		merged.ICs = override.ICs
+	}
+	return merged
+}
+
+// loadConfTemplates reads the templates set in the configuration file,
+// if any, without checking the rest of the configuration.
+func loadConfTemplates(confName string) *ScriptTemplates {
+	var (
+		confFile []byte
+		conf     struct{ Templates *ScriptTemplates }
+		err      error
+	)
+	if confName == "" {
+		return nil
+	}
+	if confFile, err = ioutil.ReadFile(confName); err != nil {
+		log.Fatal(err)
+	}
+	if err = json.Unmarshal(confFile, &conf); err != nil {
+		log.Fatal("Parse config: ", err)
+	}
+	return conf.Templates
+}
@@ -0,0 +1,178 @@
+package slt
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// RsunInPc is the solar radius in parsec, StarLab size_scale is per Rsun.
+const RsunInPc = 2.2546e-8
+
+// Units converts between N-body and physical units. The scales are the
+// ones add_star writes in the root Star story:
+//
+//	(Star
+//	  mass_scale     =  0.0001
+//	  size_scale     =  2.25e-08
+//	  time_scale     =  4.0
+//	)Star
+//
+// that is, N-body units per solar mass, per solar radius and per Myr.
+type Units struct {
+	MassScale float64
+	SizeScale float64
+	TimeScale float64
+	// Approx is true if the scales were guessed from the file name
+	// because the snapshot has none.
+	Approx bool
+}
+
+// UnitsFromSnapshot reads the scales from the root Star story of snap.
+func UnitsFromSnapshot(snap *Snapshot) (Units, error) {
+	var (
+		units Units
+		ok    bool
+	)
+	if snap == nil || snap.Root == nil {
+		return units, fmt.Errorf("no root particle")
+	}
+	for _, scale := range []struct {
+		dst  *float64
+		name string
+	}{{&units.MassScale, "mass_scale"}, {&units.SizeScale, "size_scale"}, {&units.TimeScale, "time_scale"}} {
+		if *scale.dst, ok = snap.Root.Star.Float(scale.name); !ok || *scale.dst <= 0 {
+			return Units{}, fmt.Errorf("no valid %v in the root Star story", scale.name)
+		}
+	}
+	return units, nil
+}
+
+// UnitsFromFile reads the scales from the first snapshot of a STDOUT or
+// an ICs file.
+func UnitsFromFile(fileName string) (Units, error) {
+	var (
+		inFile  *StdReader
+		scanner *SnapshotScanner
+		snap    *Snapshot
+		units   Units
+		err     error
+	)
+	if inFile, err = OpenStd(fileName); err != nil {
+		return Units{}, err
+	}
+	defer inFile.Close()
+	if id, err := ParseRunID(fileName); err == nil && id.Prefix == "ics" {
+		scanner = NewICsSnapshotScanner(inFile.Reader)
+	} else {
+		scanner = NewOutSnapshotScanner(inFile.Reader)
+	}
+	if !scanner.Scan() {
+		if err = scanner.Err(); err == nil {
+			err = fmt.Errorf("no snapshot")
+		}
+		return Units{}, fmt.Errorf("%v: %v", fileName, err)
+	}
+	if snap, err = scanner.Snapshot().Parse(); err != nil {
+		return Units{}, fmt.Errorf("%v: %v", fileName, err)
+	}
+	if units, err = UnitsFromSnapshot(snap); err != nil {
+		return Units{}, fmt.Errorf("%v: %v", fileName, err)
+	}
+	return units, nil
+}
+
+// ApproxUnits guesses the time scale from the cluster parameters in the
+// name, scaling the clusters of the first simulations:
+//
+//	timeUnit2 = sqrt(timeUnit1**2 * (length2/length1)**3 * (m1 / m2))
+//
+// with timeUnit1 ~ 0.25 Myr, length1 = 1 pc and the masses approximated
+// with the number of stars, m1 = 5500 and m2 = NCM * (1 + fPB).
+// Only the time scale is meaningful.
+func ApproxUnits(id RunID) (Units, error) {
+	if !id.Full || id.Ncm == 0 || id.Rv == 0 {
+		return Units{}, fmt.Errorf("no cluster parameters in the name")
+	}
+	timeUnit := math.Sqrt(0.25 * 0.25 * math.Pow(float64(id.Rv), 3) * (5500. / id.NStars()))
+	return Units{TimeScale: 1 / timeUnit, Approx: true}, nil
+}
+
+// FindUnits returns the units of the run of fileName: the scales in the
+// snapshot if present, otherwise the approximation from the name.
+func FindUnits(fileName string) (Units, error) {
+	var (
+		units Units
+		id    RunID
+		err   error
+	)
+	if units, err = UnitsFromFile(fileName); err == nil {
+		return units, nil
+	}
+	log.Println("Can't read the units from the snapshot, approximate them from the name: ", err)
+	if id, err = ParseRunID(fileName); err != nil {
+		return Units{}, err
+	}
+	return ApproxUnits(id)
+}
+
+// Myr converts an N-body time to Myr.
+func (units Units) Myr(nbody float64) float64 {
+	return nbody / units.TimeScale
+}
+
+// NBody converts a time in Myr to N-body units.
+func (units Units) NBody(myr float64) float64 {
+	return myr * units.TimeScale
+}
+
+// Msun converts an N-body mass to solar masses.
+func (units Units) Msun(nbody float64) float64 {
+	return nbody / units.MassScale
+}
+
+// Parsec converts an N-body length to parsec.
+func (units Units) Parsec(nbody float64) float64 {
+	return nbody / units.SizeScale * RsunInPc
+}
+
+// TimeUnitMyr is the N-body time unit in Myr.
+func (units Units) TimeUnitMyr() float64 {
+	return 1 / units.TimeScale
+}
+
+// StopTimestep returns the first integer timestep after endTime.
+// endTime is in N-body units if nbody is true, in Myr otherwise.
+func (units Units) StopTimestep(endTime float64, nbody bool) int64 {
+	if !nbody {
+		endTime = units.NBody(endTime)
+	}
+	return 1 + int64(math.Floor(endTime))
+}
+
+// String describes the units for the reports.
+func (units Units) String() string {
+	if units.Approx {
+		return fmt.Sprintf("time unit ~%2.4f Myr (approximated from the name)", units.TimeUnitMyr())
+	}
+	return fmt.Sprintf("time unit %2.4f Myr, mass unit %2.2f Msun, length unit %2.4f pc",
+		units.TimeUnitMyr(), units.Msun(1), units.Parsec(1))
+}
+
+// ParseSimTime reads an end of simulation time, in Myr like "110" or
+// "110Myr", or in N-body units like "440nb".
+func ParseSimTime(str string) (value float64, nbody bool, err error) {
+	str = strings.TrimSpace(str)
+	switch {
+	case strings.HasSuffix(str, "nb"):
+		str, nbody = strings.TrimSuffix(str, "nb"), true
+	case strings.HasSuffix(str, "Myr"):
+		str = strings.TrimSuffix(str, "Myr")
+	}
+	if value, err = strconv.ParseFloat(str, 64); err != nil {
+		return 0, false, fmt.Errorf("end of simulation %v: %v", str, err)
+	}
+	return value, nbody, nil
+}
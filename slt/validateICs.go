@@ -0,0 +1,366 @@
+package slt
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/brunetto/goutils/debug"
+)
+
+// icsMassTolerance is the relative tolerance on the total mass and on
+// the virial radius set by "scale -R 1 -M 1".
+const icsMassTolerance = 1e-3
+
+// icsMetallicityTolerance is the relative tolerance on the metallicity
+// written by "add_star -Z", at least icsMetallicityFloor in absolute.
+// Z is written as given, only the rounding of the printout is allowed.
+const (
+	icsMetallicityTolerance = 1e-3
+	icsMetallicityFloor     = 1e-7
+)
+
+// ICsExpect is what an ICs file should contain.
+type ICsExpect struct {
+	// Cluster is true if Ncm, Fpb and Z are known, from the
+	// configuration or from the file name.
+	Cluster bool
+	Ncm     int
+	Fpb     float64
+	Z       float64
+	// Fresh is true for ICs written by the ICs pipeline: the number of
+	// stars, the binary fraction and the scaling are exact. The ICs
+	// written by out2ics come from an evolved cluster and only their
+	// integrity and metallicity are checked.
+	Fresh bool
+}
+
+// ExpectedICs returns the expectations for the ICs created from conf.
+func (conf *ConfigStruct) ExpectedICs() ICsExpect {
+	return ICsExpect{Cluster: true, Ncm: conf.Ncm, Fpb: conf.Fpb, Z: conf.Z, Fresh: true}
+}
+
+// ICsExpectFromName returns the expectations from the cluster parameters
+// in the name: the ICs are fresh if they are the round 0.
+func ICsExpectFromName(fileName string) ICsExpect {
+	id, err := ParseRunID(fileName)
+	if err != nil {
+		return ICsExpect{}
+	}
+	return ICsExpect{Cluster: id.Full, Ncm: id.Ncm, Fpb: id.Fpb, Z: id.Z, Fresh: id.Round == 0}
+}
+
+// ICsReport is the result of ValidateICs.
+type ICsReport struct {
+	FileName string
+	// Systems are the children of the root, Ncm for fresh ICs.
+	Systems  int
+	Binaries int
+	Stars    int
+	Mass     float64
+	// Problems make the ICs unusable, Warnings are checks that couldn't
+	// be done.
+	Problems []string
+	Warnings []string
+}
+
+// OK tells whether the ICs passed the validation.
+func (report *ICsReport) OK() bool {
+	return len(report.Problems) == 0
+}
+
+// Err returns the problems as an error, nil if none.
+func (report *ICsReport) Err() error {
+	if report.OK() {
+		return nil
+	}
+	return fmt.Errorf("invalid ICs %v:\n\t%v", report.FileName, strings.Join(report.Problems, "\n\t"))
+}
+
+func (report *ICsReport) problem(format string, args ...interface{}) {
+	report.Problems = append(report.Problems, fmt.Sprintf(format, args...))
+}
+
+func (report *ICsReport) warning(format string, args ...interface{}) {
+	report.Warnings = append(report.Warnings, fmt.Sprintf(format, args...))
+}
+
+// Print writes the report for the ics validate command.
+func (report *ICsReport) Print() {
+	status := "OK"
+	if !report.OK() {
+		status = "FAILED"
+	}
+	fmt.Printf("%v: %v\n", report.FileName, status)
+	fmt.Printf("\tsystems: %v, binaries: %v, stars: %v, mass: %v\n", report.Systems, report.Binaries, report.Stars, report.Mass)
+	for _, problem := range report.Problems {
+		fmt.Println("\tproblem: ", problem)
+	}
+	for _, warning := range report.Warnings {
+		fmt.Println("\twarning: ", warning)
+	}
+}
+
+// ValidateICs parses the ICs in fileName and checks them against expect:
+//
+//   - the file contains a complete snapshot
+//   - no NaN or Inf in masses, positions and velocities
+//   - no duplicate star ids
+//   - Ncm systems with Ncm*(1+Fpb) stars and a binary fraction Fpb (fresh ICs)
+//   - total mass and virial radius 1, as set by scale -R 1 -M 1 (fresh ICs)
+//   - metallicity Z, if add_star recorded it
+//
+// The error is only for files that can't be read, the problems are in the report.
+func ValidateICs(fileName string, expect ICsExpect) (*ICsReport, error) {
+	if Debug {
+		defer debug.TimeMe(time.Now())
+	}
+	var (
+		report  = &ICsReport{FileName: fileName}
+		inFile  *StdReader
+		scanner *SnapshotScanner
+		snap    *Snapshot
+		err     error
+	)
+	if inFile, err = OpenStd(fileName); err != nil {
+		return nil, err
+	}
+	defer inFile.Close()
+
+	scanner = NewICsSnapshotScanner(inFile.Reader)
+	if !scanner.Scan() {
+		if err = scanner.Err(); err != nil {
+			report.problem("no complete snapshot: %v", err)
+		} else {
+			report.problem("empty file")
+		}
+		return report, nil
+	}
+	if snap, err = scanner.Snapshot().Parse(); err != nil {
+		report.problem("can't parse the snapshot: %v", err)
+		return report, nil
+	}
+	if scanner.Scan() {
+		report.warning("more than one snapshot, kira only reads the first")
+	}
+
+	checkICsTree(report, snap.Root, expect)
+	checkICsValues(report, snap.Root)
+	checkICsIDs(report, snap.Root)
+	checkICsMetallicity(report, snap.Root, expect)
+	return report, nil
+}
+
+// ValidateICsFiles validates fileNames, all the ICs in the folder if empty,
+// prints the reports and returns how many failed. The expectations come
+// from the configuration confName if given, from the names otherwise.
+func ValidateICsFiles(fileNames []string, confName string) int {
+	var (
+		conf   *ConfigStruct
+		expect ICsExpect
+		report *ICsReport
+		failed = 0
+		err    error
+	)
+	LoadNaming(confName)
+	if confName != "" {
+		conf = new(ConfigStruct)
+		conf.ReadConf(confName)
+	}
+	if len(fileNames) == 0 {
+		if fileNames, err = Naming.GlobFiles(".", "ics", ".txt*"); err != nil {
+			log.Fatal(err)
+		}
+		if len(fileNames) == 0 {
+			log.Fatal("No ICs found in this folder")
+		}
+	}
+	for _, fileName := range fileNames {
+		expect = ICsExpectFromName(fileName)
+		if conf != nil {
+			fresh := expect.Fresh
+			if _, err = ParseRunID(fileName); err != nil {
+				// The configuration describes the ICs created by createICs
+				fresh = true
+			}
+			expect = conf.ExpectedICs()
+			expect.Fresh = fresh
+		}
+		if report, err = ValidateICs(fileName, expect); err != nil {
+			log.Println("Can't validate ", fileName, ": ", err)
+			failed++
+			continue
+		}
+		report.Print()
+		if !report.OK() {
+			failed++
+		}
+	}
+	return failed
+}
+
+// checkICsTree counts systems, binaries and stars and checks them and
+// the mass against expect.
+func checkICsTree(report *ICsReport, root *Particle, expect ICsExpect) {
+	var (
+		leaves    = root.Leaves()
+		multiples = 0
+		massless  = []string{}
+		expected  float64
+		tolerance float64
+		value     float64
+		ok        bool
+	)
+	report.Systems = len(root.Children)
+	report.Stars = len(leaves)
+	for _, system := range root.Children {
+		switch {
+		case len(system.Children) == 2:
+			report.Binaries++
+		case len(system.Children) > 0:
+			multiples++
+		}
+	}
+	for _, leaf := range leaves {
+		report.Mass += leaf.Mass
+		if leaf.Mass <= 0 {
+			massless = append(massless, particleID(leaf))
+		}
+	}
+	if len(massless) > 0 {
+		report.problem("%v stars with zero or negative mass: %v", len(massless), sample(massless))
+	}
+	if root.N > 0 && root.N != report.Stars {
+		report.problem("the root says N = %v but there are %v stars, the file is probably truncated", root.N, report.Stars)
+	}
+
+	if !expect.Cluster {
+		report.warning("unknown cluster parameters, stars and binaries not checked")
+	} else {
+		// makesecondary draws the binaries, allow 5 sigmas of a binomial
+		expected = float64(expect.Ncm) * expect.Fpb
+		tolerance = 5*math.Sqrt(expected*(1-expect.Fpb)) + 1
+		if expect.Fresh {
+			if report.Systems != expect.Ncm {
+				report.problem("%v systems, expected Ncm = %v", report.Systems, expect.Ncm)
+			}
+			if multiples > 0 {
+				report.problem("%v systems with more than two stars", multiples)
+			}
+			if math.Abs(float64(report.Binaries)-expected) > tolerance {
+				report.problem("binary fraction %.4f, expected Fpb = %v", float64(report.Binaries)/float64(expect.Ncm), expect.Fpb)
+			}
+			if math.Abs(float64(report.Stars)-float64(expect.Ncm)-expected) > tolerance {
+				report.problem("%v stars, expected Ncm*(1+Fpb) = %.0f", report.Stars, float64(expect.Ncm)+expected)
+			}
+		} else if float64(report.Stars) > float64(expect.Ncm)+expected+tolerance {
+			report.problem("%v stars, more than the initial Ncm*(1+Fpb) = %.0f", report.Stars, float64(expect.Ncm)+expected)
+		}
+	}
+
+	if !expect.Fresh {
+		return
+	}
+	// scale -R 1 -M 1
+	if math.Abs(report.Mass-1) > icsMassTolerance {
+		report.problem("total mass %v, expected 1 from scale -M 1", report.Mass)
+	}
+	for _, initial := range []string{"initial_mass", "initial_rvirial"} {
+		if value, ok = root.Log.Float(initial); !ok {
+			report.warning("no %v in the root Log story, can't check the scaling", initial)
+		} else if math.Abs(value-1) > icsMassTolerance {
+			report.problem("%v = %v, expected 1 from scale -R 1 -M 1", initial, value)
+		}
+	}
+}
+
+// checkICsValues looks for NaN and Inf in the dynamics of every particle.
+func checkICsValues(report *ICsReport, root *Particle) {
+	var bad = []string{}
+	root.Walk(func(p *Particle) {
+		if p.Dynamics == nil {
+			return
+		}
+		for _, line := range p.Dynamics.Lines {
+			key, value, ok := splitKeyValue(line)
+			if !ok {
+				continue
+			}
+			for _, field := range strings.Fields(value) {
+				field = strings.ToLower(strings.TrimLeft(field, "+-"))
+				if field == "nan" || strings.HasPrefix(field, "inf") {
+					bad = append(bad, particleID(p)+" ("+key+")")
+					break
+				}
+			}
+		}
+	})
+	if len(bad) > 0 {
+		report.problem("%v NaN or Inf values: %v", len(bad), sample(bad))
+	}
+}
+
+// checkICsIDs looks for stars sharing the same index or name.
+func checkICsIDs(report *ICsReport, root *Particle) {
+	var (
+		seen       = map[string]bool{}
+		duplicates = []string{}
+		anonymous  = 0
+	)
+	for _, leaf := range root.Leaves() {
+		id := particleID(leaf)
+		if id == "" {
+			anonymous++
+			continue
+		}
+		if seen[id] {
+			duplicates = append(duplicates, id)
+		}
+		seen[id] = true
+	}
+	if anonymous > 0 {
+		report.problem("%v stars without index or name", anonymous)
+	}
+	if len(duplicates) > 0 {
+		sort.Strings(duplicates)
+		report.problem("%v duplicate star ids: %v", len(duplicates), sample(duplicates))
+	}
+}
+
+// checkICsMetallicity compares the metallicity add_star wrote in the
+// root stories with expect.Z.
+func checkICsMetallicity(report *ICsReport, root *Particle, expect ICsExpect) {
+	var (
+		z  float64
+		ok bool
+	)
+	if z, ok = root.Star.Float("metallicity"); !ok {
+		z, ok = root.Log.Float("metallicity")
+	}
+	switch {
+	case !ok:
+		report.warning("no metallicity in the root stories, Z not checked")
+	case !expect.Cluster:
+	case math.Abs(z-expect.Z) > math.Max(icsMetallicityTolerance*expect.Z, icsMetallicityFloor):
+		report.problem("metallicity %v, expected Z = %v", z, expect.Z)
+	}
+}
+
+// particleID returns the index of a star or its name if it has none.
+func particleID(p *Particle) string {
+	if p.I > 0 {
+		return fmt.Sprint(p.I)
+	}
+	return p.Name
+}
+
+// sample returns the first few items of a list for the messages.
+func sample(items []string) string {
+	if len(items) > 5 {
+		return strings.Join(items[:5], ", ") + ", ..."
+	}
+	return strings.Join(items, ", ")
+}
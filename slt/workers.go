@@ -0,0 +1,109 @@
+package slt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Jobs is how many runs are worked at the same time, set by --jobs:
+// the combinations and the ICs created by createICs and campaign create,
+// the STDOUTs read by out2ics, cac and continue, the runs stiched by
+// stichOutput -A and checked by checkStatus.
+var Jobs = 1
+
+var (
+	jobSlots     chan struct{}
+	jobSlotsOnce sync.Once
+)
+
+// workerCount is Jobs, at least 1.
+func workerCount() int {
+	if Jobs < 1 {
+		return 1
+	}
+	return Jobs
+}
+
+// withJobSlot runs work when less than Jobs of them are running, so that
+// nested pools, like the combinations of createICs and their runs, don't
+// run Jobs*Jobs works at the same time. Only the innermost work must
+// take a slot.
+func withJobSlot(work func() error) error {
+	jobSlotsOnce.Do(func() { jobSlots = make(chan struct{}, workerCount()) })
+	jobSlots <- struct{}{}
+	defer func() { <-jobSlots }()
+	return work()
+}
+
+// ForEachRun calls work for the items 0..n-1 on Jobs goroutines. Each work
+// writes its report to out: the reports are printed on the standard output
+// in the order of the items, so the output doesn't depend on Jobs. done, if
+// not nil, is called in the same order on the goroutine of the caller, with
+// the error of the item, right after its report. A failing item doesn't
+// stop the others. The errors of the items are returned.
+// Only the report is ordered, the log of the items can be interleaved.
+func ForEachRun(n int, work func(idx int, out io.Writer) error, done func(idx int, err error)) []error {
+	var (
+		errs     = make([]error, n)
+		reports  = make([]bytes.Buffer, n)
+		finished = make([]chan struct{}, n)
+		items    = make(chan int)
+		workers  = Jobs
+	)
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		for idx := 0; idx < n; idx++ {
+			errs[idx] = work(idx, os.Stdout)
+			if done != nil {
+				done(idx, errs[idx])
+			}
+		}
+		return errs
+	}
+
+	for idx := range finished {
+		finished[idx] = make(chan struct{})
+	}
+	for worker := 0; worker < workers; worker++ {
+		go func() {
+			for idx := range items {
+				errs[idx] = work(idx, &reports[idx])
+				close(finished[idx])
+			}
+		}()
+	}
+	go func() {
+		for idx := 0; idx < n; idx++ {
+			items <- idx
+		}
+		close(items)
+	}()
+	for idx := 0; idx < n; idx++ {
+		<-finished[idx]
+		reports[idx].WriteTo(os.Stdout)
+		if done != nil {
+			done(idx, errs[idx])
+		}
+	}
+	return errs
+}
+
+// JobsError returns an error listing the failed items, nil if none.
+func JobsError(errs []error) error {
+	var failed = []string{}
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%v out of %v failed:\n%v", len(failed), len(errs), strings.Join(failed, "\n"))
+}